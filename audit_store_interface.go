@@ -0,0 +1,31 @@
+package meritop
+
+import "time"
+
+// Possible values of AuditRecord.Event.
+const (
+	AuditEpochStarted   = "epoch started"
+	AuditEpochCompleted = "epoch completed"
+	AuditEpochAborted   = "epoch aborted"
+	AuditDataServed     = "data served"
+	AuditDataReceived   = "data received"
+	AuditPushReceived   = "push received"
+)
+
+// AuditRecord is an entry of the audit log of a task.
+type AuditRecord struct {
+	Epoch  uint64
+	TaskID uint64
+	Event  string
+	// the task data was exchanged with, and the hex SHA-256 of the data, for
+	// data events.
+	PeerID       uint64
+	GradientHash string `json:",omitempty"`
+	Time         time.Time
+}
+
+// AuditStore keeps the audit log of tasks, see
+// Bootstrap.EnableEpochAuditLog. Records are only ever appended.
+type AuditStore interface {
+	Append(record AuditRecord) error
+}
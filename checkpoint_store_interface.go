@@ -0,0 +1,15 @@
+package meritop
+
+import "errors"
+
+var ErrNoCheckpoint = errors.New("checkpoint store: no checkpoint")
+
+// CheckpointStore is where the framework keeps the checkpoints of
+// Checkpointable tasks, see Bootstrap.SetCheckpointStore.
+type CheckpointStore interface {
+	Save(taskID, epoch uint64, data []byte) error
+	// Load returns the last checkpoint of the task saved at or before the
+	// given epoch, and the epoch it was saved at. It returns ErrNoCheckpoint
+	// if there is none.
+	Load(taskID, epoch uint64) (uint64, []byte, error)
+}
@@ -0,0 +1,15 @@
+package meritop
+
+import "time"
+
+// CommitStrategy decides when the job moves on to the next epoch, see
+// framework.ManualCommit and its siblings for the ones provided. Only tasks
+// without parents commit, as the aggregation ends with them.
+type CommitStrategy interface {
+	// Ready tells if the epoch can be committed once responded out of
+	// numChildren children responded to data requests in it.
+	Ready(responded, numChildren int) bool
+	// Timeout is how long after SetEpoch the epoch is committed no matter
+	// how many children responded, zero for no limit.
+	Timeout() time.Duration
+}
@@ -19,6 +19,9 @@ type Controller struct {
 	failDetectStop chan bool
 	logger         *log.Logger
 	jobStatusChan  chan string
+	keyPrefix      string
+	namespace      string
+	customEpochKey func(jobName string, epoch uint64) string
 }
 
 func New(name string, etcd *etcd.Client, numOfTasks uint64) *Controller {
@@ -30,6 +33,26 @@ func New(name string, etcd *etcd.Client, numOfTasks uint64) *Controller {
 	}
 }
 
+// SetKeyPrefix scopes all etcd keys of the job under <prefix>/<name>.
+// It must be called before Start and match the prefix used by the tasks.
+func (c *Controller) SetKeyPrefix(prefix string) { c.keyPrefix = prefix }
+
+// SetEtcdNamespace puts the key prefix under the given namespace. Like the
+// prefix, it must match the namespace used by the tasks.
+func (c *Controller) SetEtcdNamespace(ns string) error {
+	if err := etcdutil.CheckNamespace(ns); err != nil {
+		return err
+	}
+	c.namespace = ns
+	return nil
+}
+
+// SetCustomEpochKey changes where the job epoch is kept. It must match the
+// one set on the tasks' bootstrap.
+func (c *Controller) SetCustomEpochKey(fn func(jobName string, epoch uint64) string) {
+	c.customEpochKey = fn
+}
+
 // A controller typical workflow:
 // 1. controller sets up etcd layout before any task starts running.
 // 2. Being ready, controller lets other tasks to run and reports any failure found.
@@ -58,16 +81,16 @@ func (c *Controller) Stop() error {
 
 func (c *Controller) InitEtcdLayout() error {
 	// Initilize the job epoch to 0
-	etcdutil.MustCreate(c.etcdclient, c.logger, etcdutil.EpochPath(c.name), "0", 0)
+	etcdutil.MustCreate(c.etcdclient, c.logger, c.epochKey(), "0", 0)
 	c.setupWatchOnJobStatus()
 	// initiate etcd data layout for tasks
 	// currently it creates as many unassigned tasks as task masters.
 	for i := uint64(0); i < c.numOfTasks; i++ {
-		key := etcdutil.FreeTaskPath(c.name, strconv.FormatUint(i, 10))
+		key := etcdutil.FreeTaskPath(c.jobPath(), strconv.FormatUint(i, 10))
 		etcdutil.MustCreate(c.etcdclient, c.logger, key, "", 0)
-		key = etcdutil.ParentMetaPath(c.name, i)
+		key = etcdutil.ParentMetaPath(c.jobPath(), i)
 		etcdutil.MustCreate(c.etcdclient, c.logger, key, "", 0)
-		key = etcdutil.ChildMetaPath(c.name, i)
+		key = etcdutil.ChildMetaPath(c.jobPath(), i)
 		etcdutil.MustCreate(c.etcdclient, c.logger, key, "", 0)
 	}
 	return nil
@@ -80,12 +103,12 @@ func (c *Controller) DestroyEtcdLayout() error {
 
 func (c *Controller) startFailureDetection() error {
 	c.failDetectStop = make(chan bool, 1)
-	return etcdutil.DetectFailure(c.etcdclient, c.name, c.failDetectStop, c.logger)
+	return etcdutil.DetectFailure(c.etcdclient, c.jobPath(), c.failDetectStop, c.logger)
 }
 
 func (c *Controller) setupWatchOnJobStatus() {
 	c.jobStatusChan = make(chan string, 1)
-	key := etcdutil.JobStatusPath(c.name)
+	key := etcdutil.JobStatusPath(c.jobPath())
 	resp := etcdutil.MustCreate(c.etcdclient, c.logger, key, "", 0)
 	go func() {
 		resp, err := c.etcdclient.Watch(key, resp.EtcdIndex+1, false, nil, nil)
@@ -100,3 +123,18 @@ func (c *Controller) stopFailureDetection() error {
 	c.failDetectStop <- true
 	return nil
 }
+
+func (c *Controller) epochKey() string {
+	if c.customEpochKey != nil {
+		return c.customEpochKey(c.name, 0)
+	}
+	return etcdutil.EpochPath(c.jobPath())
+}
+
+func (c *Controller) jobPath() string {
+	prefix := c.keyPrefix
+	if prefix == "" {
+		prefix = etcdutil.DefaultKeyPrefix
+	}
+	return etcdutil.JobPath(c.namespace, prefix, c.name)
+}
@@ -36,15 +36,15 @@ func TestControllerInitEtcdLayout(t *testing.T) {
 		c.InitEtcdLayout()
 
 		for taskID := uint64(0); taskID < tt.numberOfTasks; taskID++ {
-			key := etcdutil.FreeTaskPath(c.name, strconv.FormatUint(taskID, 10))
+			key := etcdutil.FreeTaskPath(c.jobPath(), strconv.FormatUint(taskID, 10))
 			if _, err := etcdClient.Get(key, false, false); err != nil {
 				t.Errorf("task %d: etcdClient.Get %v failed: %v", i, key, err)
 			}
-			key = etcdutil.ParentMetaPath(c.name, taskID)
+			key = etcdutil.ParentMetaPath(c.jobPath(), taskID)
 			if _, err := etcdClient.Get(key, false, false); err != nil {
 				t.Errorf("task %d: etcdClient.Get %v failed: %v", i, key, err)
 			}
-			key = etcdutil.ChildMetaPath(c.name, taskID)
+			key = etcdutil.ChildMetaPath(c.jobPath(), taskID)
 			if _, err := etcdClient.Get(key, false, false); err != nil {
 				t.Errorf("task %d: etcdClient.Get %v failed: %v", i, key, err)
 			}
@@ -53,3 +53,22 @@ func TestControllerInitEtcdLayout(t *testing.T) {
 		c.DestroyEtcdLayout()
 	}
 }
+
+func TestControllerSetEtcdNamespace(t *testing.T) {
+	c := &Controller{name: "job"}
+	if err := c.SetEtcdNamespace("team-1"); err != nil {
+		t.Fatalf("SetEtcdNamespace failed: %v", err)
+	}
+	if p := c.jobPath(); p != "/team-1/meritop/job" {
+		t.Errorf("jobPath() = %s, want /team-1/meritop/job", p)
+	}
+
+	for _, ns := range []string{"../other", "a/b", "team_1"} {
+		if err := c.SetEtcdNamespace(ns); err == nil {
+			t.Errorf("SetEtcdNamespace(%q) = nil, want error", ns)
+		}
+	}
+	if ns := c.namespace; ns != "team-1" {
+		t.Errorf("namespace = %s after invalid ones, want team-1", ns)
+	}
+}
@@ -0,0 +1,33 @@
+package meritop
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrCompareFailed = errors.New("coordination backend: compare failed")
+	ErrKeyExists     = errors.New("coordination backend: key exists")
+)
+
+// CoordinationBackend is what tasks of a job coordinate through: the epoch,
+// the job status, task addresses and metadata. It is backed by etcd unless
+// the application plugs in something else, see
+// Bootstrap.SetCoordinationBackend.
+type CoordinationBackend interface {
+	MetadataStore
+
+	// Register records that a member, e.g. a task, is reachable at value,
+	// until it's deregistered.
+	Register(ctx context.Context, key, value string) error
+	Deregister(ctx context.Context, key string) error
+
+	// AtomicCompareAndSwap sets key to value if it's prevValue. It returns
+	// ErrCompareFailed if it isn't, and ErrKeyNotFound if there is no key.
+	AtomicCompareAndSwap(ctx context.Context, key, prevValue, value string) error
+
+	// CreateEphemeral creates key unless it exists, in which case it returns
+	// ErrKeyExists. The key is gone after ttl.
+	CreateEphemeral(ctx context.Context, key, value string, ttl time.Duration) error
+}
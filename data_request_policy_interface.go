@@ -0,0 +1,13 @@
+package meritop
+
+// DataRequestPolicy decides when a data request of the task is actually
+// sent, see framework.FetchImmediately and its siblings for the ones
+// provided.
+type DataRequestPolicy interface {
+	// Execute is called on Context.DataRequest. It sends the request by
+	// calling fetch, now, later or not at all.
+	Execute(ctx Context, peerID uint64, meta string, fetch func(peerID uint64, meta string))
+	// Flush sends the requests held back in the epoch of ctx. It is called
+	// on Context.FlushDataRequests.
+	Flush(ctx Context)
+}
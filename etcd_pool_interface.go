@@ -0,0 +1,14 @@
+package meritop
+
+import (
+	"net/http"
+	"time"
+)
+
+// EtcdPool shares connections to etcd among the frameworks of a process,
+// e.g. etcdutil.Pool.
+type EtcdPool interface {
+	// Transport returns the transport shared by etcd clients of the given
+	// machines with the given timeouts. Zero requestTimeout means none.
+	Transport(machines []string, dialTimeout, requestTimeout time.Duration) *http.Transport
+}
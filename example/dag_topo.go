@@ -0,0 +1,173 @@
+package example
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DAGTopology connects tasks as given by an adjacency list from each task to
+// its children. Unlike a tree, a task can have more than one parent.
+// The structure stays the same between epochs.
+type DAGTopology struct {
+	numOfTasks        uint64
+	adjacency         map[uint64][]uint64
+	taskID            uint64
+	parents, children []uint64
+}
+
+func (t *DAGTopology) SetTaskID(taskID uint64) {
+	t.taskID = taskID
+	t.parents = make([]uint64, 0)
+	t.children = append(make([]uint64, 0, len(t.adjacency[taskID])), t.adjacency[taskID]...)
+	for id := uint64(0); id < t.numOfTasks; id++ {
+		if containsID(t.adjacency[id], taskID) {
+			t.parents = append(t.parents, id)
+		}
+	}
+}
+
+func (t *DAGTopology) GetParents(epoch uint64) []uint64 { return t.parents }
+
+func (t *DAGTopology) GetChildren(epoch uint64) []uint64 { return t.children }
+
+// GetAncestors returns every task from which taskID can be reached, in
+// breadth first order going up from its parents. A task reachable over paths
+// of different lengths comes at its shortest distance.
+func (t *DAGTopology) GetAncestors(taskID, epoch uint64) []uint64 {
+	parents := make(map[uint64][]uint64)
+	for p, cs := range t.adjacency {
+		for _, c := range cs {
+			parents[c] = append(parents[c], p)
+		}
+	}
+	seen := map[uint64]bool{taskID: true}
+	res := make([]uint64, 0)
+	for queue := []uint64{taskID}; len(queue) > 0; queue = queue[1:] {
+		ps := parents[queue[0]]
+		sort.Sort(uint64Slice(ps))
+		for _, p := range ps {
+			if !seen[p] {
+				seen[p] = true
+				res = append(res, p)
+				queue = append(queue, p)
+			}
+		}
+	}
+	return res
+}
+
+func (t *DAGTopology) GetAdjacencyList(epoch uint64) map[uint64][]uint64 {
+	adj := make(map[uint64][]uint64, len(t.adjacency))
+	for p, cs := range t.adjacency {
+		if len(cs) > 0 {
+			adj[p] = append([]uint64(nil), cs...)
+		}
+	}
+	return adj
+}
+
+func (t *DAGTopology) GetLeafTasks(epoch uint64) []uint64 {
+	res := make([]uint64, 0)
+	for id := uint64(0); id < t.numOfTasks; id++ {
+		if len(t.adjacency[id]) == 0 {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// GetDepth returns the length of the longest path from a task without
+// parents to a task without children.
+func (t *DAGTopology) GetDepth(epoch uint64) uint64 {
+	return longestPath(t.adjacency)
+}
+
+// longestPath returns the number of edges on the longest path following
+// children in adj, which must not have a cycle.
+func longestPath(adj map[uint64][]uint64) uint64 {
+	depth := make(map[uint64]uint64)
+	var visit func(id uint64) uint64
+	visit = func(id uint64) uint64 {
+		if d, ok := depth[id]; ok {
+			return d
+		}
+		d := uint64(0)
+		for _, c := range adj[id] {
+			if cd := visit(c) + 1; cd > d {
+				d = cd
+			}
+		}
+		depth[id] = d
+		return d
+	}
+	res := uint64(0)
+	for id := range adj {
+		if d := visit(id); d > res {
+			res = d
+		}
+	}
+	return res
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (t *DAGTopology) GetTaskCount() uint64 { return t.numOfTasks }
+
+func (t *DAGTopology) SetNumberOfTasks(nt uint64) { t.numOfTasks = nt }
+
+// Validate checks that all tasks in the adjacency list exist and that
+// following children never leads back to where it started.
+func (t *DAGTopology) Validate() error {
+	for p, cs := range t.adjacency {
+		if p >= t.numOfTasks {
+			return fmt.Errorf("dag topology: task %d out of %d tasks", p, t.numOfTasks)
+		}
+		for _, c := range cs {
+			if c >= t.numOfTasks {
+				return fmt.Errorf("dag topology: task %d out of %d tasks", c, t.numOfTasks)
+			}
+		}
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[uint64]int)
+	var visit func(id uint64) error
+	visit = func(id uint64) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("dag topology: task %d is on a cycle", id)
+		case visited:
+			return nil
+		}
+		state[id] = visiting
+		for _, c := range t.adjacency[id] {
+			if err := visit(c); err != nil {
+				return err
+			}
+		}
+		state[id] = visited
+		return nil
+	}
+	for id := uint64(0); id < t.numOfTasks; id++ {
+		if err := visit(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Creates a new DAG topology of nTasks tasks, where adjacency maps a task to
+// its children.
+func NewDAGTopology(nTasks uint64, adjacency map[uint64][]uint64) *DAGTopology {
+	return &DAGTopology{
+		numOfTasks: nTasks,
+		adjacency:  adjacency,
+	}
+}
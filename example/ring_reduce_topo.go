@@ -0,0 +1,24 @@
+package example
+
+// NewRingReduceTopology creates a topology of numWorkers workers and a
+// master, numWorkers+1 tasks in all. The workers form a ring in increasing
+// order of task ID, each reducing its own gradient with what the one before
+// it passed on; the last one hands the fully reduced gradient to the master.
+// In terms of parents and children: every worker is the parent of the one
+// before it, and the master is the parent of the last worker.
+func NewRingReduceTopology(numWorkers, masterID uint64) *DAGTopology {
+	workers := make([]uint64, 0, numWorkers)
+	for id := uint64(0); id <= numWorkers; id++ {
+		if id != masterID {
+			workers = append(workers, id)
+		}
+	}
+	adjacency := make(map[uint64][]uint64, len(workers))
+	if len(workers) > 0 {
+		adjacency[masterID] = []uint64{workers[len(workers)-1]}
+	}
+	for i := 1; i < len(workers); i++ {
+		adjacency[workers[i]] = []uint64{workers[i-1]}
+	}
+	return NewDAGTopology(numWorkers+1, adjacency)
+}
@@ -0,0 +1,42 @@
+package example
+
+import (
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/topology"
+)
+
+func init() {
+	topology.Register("tree", func(c topology.Config) meritop.Topology {
+		return NewTreeTopology(c.Fanout, c.NumTasks)
+	})
+	// For a dag, numTasks defaults to one more than the largest task ID.
+	topology.Register("dag", func(c topology.Config) meritop.Topology {
+		n := c.NumTasks
+		if n == 0 {
+			for p, cs := range c.Adjacency {
+				n = maxID(n, p+1)
+				for _, id := range cs {
+					n = maxID(n, id+1)
+				}
+			}
+		}
+		return NewDAGTopology(n, c.Adjacency)
+	})
+}
+
+// Config describes the tree in the format topology.ParseJSON takes.
+func (t *TreeTopology) Config() topology.Config {
+	return topology.Config{Type: "tree", Fanout: t.fanout, NumTasks: t.numOfTasks}
+}
+
+// Config describes the dag in the format topology.ParseJSON takes.
+func (t *DAGTopology) Config() topology.Config {
+	return topology.Config{Type: "dag", NumTasks: t.numOfTasks, Adjacency: t.adjacency}
+}
+
+func maxID(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
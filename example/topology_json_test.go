@@ -0,0 +1,62 @@
+package example
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-distributed/meritop/pkg/topology"
+)
+
+func TestParseTopologyJSON(t *testing.T) {
+	tests := []struct {
+		config   string
+		taskID   uint64
+		parents  []uint64
+		children []uint64
+	}{
+		{`{"type": "tree", "fanout": 2, "numTasks": 7}`, 1, []uint64{0}, []uint64{3, 4}},
+		{`{"type": "dag", "adjacency": {"0": [1, 2], "1": [2]}}`, 2, []uint64{0, 1}, []uint64{}},
+		{`{"type": "dag", "adjacency": {"0": [1, 2], "1": [2]}}`, 1, []uint64{0}, []uint64{2}},
+	}
+	for i, tt := range tests {
+		topo, err := topology.ParseJSON([]byte(tt.config))
+		if err != nil {
+			t.Fatalf("#%d: ParseTopologyJSON failed: %v", i, err)
+		}
+		topo.SetTaskID(tt.taskID)
+		if get := topo.GetParents(0); !reflect.DeepEqual(get, tt.parents) {
+			t.Errorf("#%d: parents = %v, want %v", i, get, tt.parents)
+		}
+		if get := topo.GetChildren(0); !reflect.DeepEqual(get, tt.children) {
+			t.Errorf("#%d: children = %v, want %v", i, get, tt.children)
+		}
+
+		data, err := topology.MarshalJSON(topo)
+		if err != nil {
+			t.Fatalf("#%d: MarshalTopologyJSON failed: %v", i, err)
+		}
+		again, err := topology.ParseJSON(data)
+		if err != nil {
+			t.Fatalf("#%d: topology.ParseJSON(%s) failed: %v", i, data, err)
+		}
+		again.SetTaskID(tt.taskID)
+		if !reflect.DeepEqual(again, topo) {
+			t.Errorf("#%d: round trip = %+v, want %+v", i, again, topo)
+		}
+	}
+}
+
+func TestParseTopologyJSONInvalid(t *testing.T) {
+	tests := []string{
+		`{"type": "ring", "numTasks": 7}`,
+		`{"type": "tree", "fanout": 0, "numTasks": 7}`,
+		`{"type": "dag", "adjacency": {"0": [1], "1": [0]}}`,
+		`{"type": "dag", "numTasks": 2, "adjacency": {"0": [5]}}`,
+		`not json`,
+	}
+	for i, tt := range tests {
+		if _, err := topology.ParseJSON([]byte(tt)); err == nil {
+			t.Errorf("#%d: topology.ParseJSON(%s) = nil error, want error", i, tt)
+		}
+	}
+}
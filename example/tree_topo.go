@@ -1,11 +1,15 @@
 package example
 
+import "fmt"
+
 //The tree structure is basically assume that all the task forms a tree.
 //Also the tree structure stays the same between epochs.
 type TreeTopology struct {
 	fanout, numOfTasks uint64
 	taskID             uint64
 	parents, children  []uint64
+	// computed on first use, the tree being the same in every epoch.
+	leaves []uint64
 }
 
 func (t *TreeTopology) SetTaskID(taskID uint64) {
@@ -33,7 +37,130 @@ func (t *TreeTopology) GetParents(epoch uint64) []uint64 { return t.parents }
 
 func (t *TreeTopology) GetChildren(epoch uint64) []uint64 { return t.children }
 
-func (t *TreeTopology) SetNumberOfTasks(nt uint64) { t.numOfTasks = nt }
+// GetAncestors returns the path from the parent of taskID up to task 0.
+func (t *TreeTopology) GetAncestors(taskID, epoch uint64) []uint64 {
+	res := make([]uint64, 0)
+	for id := taskID; id > 0 && id < t.numOfTasks; {
+		id = (id - 1) / t.fanout
+		res = append(res, id)
+	}
+	return res
+}
+
+func (t *TreeTopology) GetAdjacencyList(epoch uint64) map[uint64][]uint64 {
+	adj := make(map[uint64][]uint64)
+	for id := uint64(1); id < t.numOfTasks; id++ {
+		p := (id - 1) / t.fanout
+		adj[p] = append(adj[p], id)
+	}
+	return adj
+}
+
+// GetLeafTasks returns the tasks from the first one without children on,
+// as children of task p are p*fanout+1 to p*fanout+fanout.
+func (t *TreeTopology) GetLeafTasks(epoch uint64) []uint64 {
+	if t.leaves == nil {
+		t.leaves = make([]uint64, 0)
+		for id := uint64(0); id < t.numOfTasks; id++ {
+			if id*t.fanout+1 >= t.numOfTasks {
+				t.leaves = append(t.leaves, id)
+			}
+		}
+	}
+	return t.leaves
+}
+
+// GetDepth is the number of ancestors of the last task, which is on the
+// deepest level of the tree.
+func (t *TreeTopology) GetDepth(epoch uint64) uint64 {
+	if t.numOfTasks == 0 {
+		return 0
+	}
+	return uint64(len(t.GetAncestors(t.numOfTasks-1, epoch)))
+}
+
+func (t *TreeTopology) GetTaskCount() uint64 { return t.numOfTasks }
+
+func (t *TreeTopology) SetNumberOfTasks(nt uint64) {
+	t.numOfTasks = nt
+	t.leaves = nil
+}
+
+// Validate checks the tree built for every task: task 0 is the only root,
+// every other task has exactly one parent, following parents always leads to
+// the root, and a task is a child of its parent.
+func (t *TreeTopology) Validate() error {
+	if t.fanout == 0 {
+		return fmt.Errorf("tree topology: fanout must be positive")
+	}
+	if t.numOfTasks == 0 {
+		return fmt.Errorf("tree topology: no tasks")
+	}
+	parents := make([][]uint64, t.numOfTasks)
+	children := make([][]uint64, t.numOfTasks)
+	for id := uint64(0); id < t.numOfTasks; id++ {
+		topo := NewTreeTopology(t.fanout, t.numOfTasks)
+		topo.SetTaskID(id)
+		parents[id] = topo.GetParents(0)
+		children[id] = topo.GetChildren(0)
+	}
+
+	if len(parents[0]) != 0 {
+		return fmt.Errorf("tree topology: root has parents %v", parents[0])
+	}
+	for id := uint64(1); id < t.numOfTasks; id++ {
+		if len(parents[id]) != 1 {
+			return fmt.Errorf("tree topology: task %d has parents %v, want exactly one", id, parents[id])
+		}
+		if p := parents[id][0]; p >= t.numOfTasks || !containsID(children[p], id) {
+			return fmt.Errorf("tree topology: task %d isn't a child of its parent %d", id, p)
+		}
+	}
+	for id := uint64(0); id < t.numOfTasks; id++ {
+		for _, c := range children[id] {
+			if c >= t.numOfTasks || !containsID(parents[c], id) {
+				return fmt.Errorf("tree topology: task %d isn't the parent of its child %d", id, c)
+			}
+		}
+	}
+	// A path to the root is at most numOfTasks-1 steps long; a longer one
+	// goes around a cycle.
+	for id := uint64(1); id < t.numOfTasks; id++ {
+		p := id
+		for steps := uint64(0); p != 0; steps++ {
+			if steps == t.numOfTasks {
+				return fmt.Errorf("tree topology: task %d is on a cycle", id)
+			}
+			p = parents[p][0]
+		}
+	}
+	return nil
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSubtreeTaskIDs returns the tasks of the subtree rooted at rootID, rootID
+// included, in breadth first order.
+func (t *TreeTopology) GetSubtreeTaskIDs(rootID uint64) ([]uint64, error) {
+	if rootID >= t.numOfTasks {
+		return nil, fmt.Errorf("task %d not in tree of %d tasks", rootID, t.numOfTasks)
+	}
+	res := []uint64{rootID}
+	for i := 0; i < len(res); i++ {
+		// children of task p are p*fanout+1 to p*fanout+fanout.
+		for c := res[i]*t.fanout + 1; c <= res[i]*t.fanout+t.fanout && c < t.numOfTasks; c++ {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
 
 // Creates a new tree topology with given fanout and number of tasks.
 // This will be called during the task graph configuration.
@@ -44,3 +171,93 @@ func NewTreeTopology(fanout, nTasks uint64) *TreeTopology {
 	}
 	return m
 }
+
+// SparseTreeTopology is a tree topology in which only some of the tasks take
+// part in a given epoch, e.g. the experts of a mixture-of-experts model.
+// An inactive task has neither parents nor children in that epoch, which cuts
+// off its whole subtree as well.
+type SparseTreeTopology struct {
+	*TreeTopology
+	active func(epoch, taskID uint64) bool
+}
+
+func (t *SparseTreeTopology) GetParents(epoch uint64) []uint64 {
+	return t.activeOnly(epoch, t.TreeTopology.GetParents(epoch))
+}
+
+func (t *SparseTreeTopology) GetChildren(epoch uint64) []uint64 {
+	return t.activeOnly(epoch, t.TreeTopology.GetChildren(epoch))
+}
+
+// GetAncestors returns the ancestors of taskID up to the first inactive one,
+// which cuts taskID off from those above. An inactive task has none.
+func (t *SparseTreeTopology) GetAncestors(taskID, epoch uint64) []uint64 {
+	res := make([]uint64, 0)
+	if !t.active(epoch, taskID) {
+		return res
+	}
+	for _, id := range t.TreeTopology.GetAncestors(taskID, epoch) {
+		if !t.active(epoch, id) {
+			break
+		}
+		res = append(res, id)
+	}
+	return res
+}
+
+// GetAdjacencyList leaves out the tasks inactive at the epoch.
+func (t *SparseTreeTopology) GetAdjacencyList(epoch uint64) map[uint64][]uint64 {
+	adj := make(map[uint64][]uint64)
+	for p, cs := range t.TreeTopology.GetAdjacencyList(epoch) {
+		if !t.active(epoch, p) {
+			continue
+		}
+		for _, c := range cs {
+			if t.active(epoch, c) {
+				adj[p] = append(adj[p], c)
+			}
+		}
+	}
+	return adj
+}
+
+// GetLeafTasks returns the active tasks without active children at the
+// epoch.
+func (t *SparseTreeTopology) GetLeafTasks(epoch uint64) []uint64 {
+	adj := t.GetAdjacencyList(epoch)
+	res := make([]uint64, 0)
+	for id := uint64(0); id < t.numOfTasks; id++ {
+		if t.active(epoch, id) && len(adj[id]) == 0 {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// GetDepth only counts the levels reachable from task 0 through active
+// tasks.
+func (t *SparseTreeTopology) GetDepth(epoch uint64) uint64 {
+	return longestPath(t.GetAdjacencyList(epoch))
+}
+
+func (t *SparseTreeTopology) activeOnly(epoch uint64, taskIDs []uint64) []uint64 {
+	res := make([]uint64, 0, len(taskIDs))
+	if !t.active(epoch, t.taskID) {
+		return res
+	}
+	for _, id := range taskIDs {
+		if t.active(epoch, id) {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// Creates a new sparse tree topology with given fanout and number of tasks.
+// activeFn tells whether a task takes part in the given epoch.
+func NewSparseTreeTopology(fanout, nTasks uint64, activeFn func(epoch, taskID uint64) bool) *SparseTreeTopology {
+	return &SparseTreeTopology{
+		TreeTopology: NewTreeTopology(fanout, nTasks),
+		active:       activeFn,
+	}
+}
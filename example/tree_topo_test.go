@@ -1,6 +1,12 @@
 package example
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/topoutil"
+)
 
 type treeTopoTest struct {
 	id                uint64
@@ -83,3 +89,249 @@ func testTreeTopology(fanout, number uint64, tests []treeTopoTest, t *testing.T)
 		}
 	}
 }
+
+func TestSparseTreeTopology(t *testing.T) {
+	//     0
+	//   1   2
+	//  3 4 5 6
+	// Task 2 is active at even epochs only.
+	active := func(epoch, taskID uint64) bool { return taskID != 2 || epoch%2 == 0 }
+	tests := []struct {
+		id, epoch         uint64
+		parents, children []uint64
+	}{
+		{0, 0, []uint64{}, []uint64{1, 2}},
+		{0, 1, []uint64{}, []uint64{1}},
+		{2, 0, []uint64{0}, []uint64{5, 6}},
+		{2, 1, []uint64{}, []uint64{}},
+		{5, 1, []uint64{}, []uint64{}},
+		{3, 1, []uint64{1}, []uint64{}},
+	}
+	for i, tt := range tests {
+		topo := NewSparseTreeTopology(2, 7, active)
+		topo.SetTaskID(tt.id)
+		if get := topo.GetParents(tt.epoch); !reflect.DeepEqual(get, tt.parents) {
+			t.Errorf("#%d: parents = %v, want %v", i, get, tt.parents)
+		}
+		if get := topo.GetChildren(tt.epoch); !reflect.DeepEqual(get, tt.children) {
+			t.Errorf("#%d: children = %v, want %v", i, get, tt.children)
+		}
+	}
+}
+
+func TestTopologyGetAncestors(t *testing.T) {
+	//     0
+	//   1   2
+	//  3 4 5 6
+	// 7
+	tree := NewTreeTopology(2, 8)
+	// Task 1 is active at even epochs only.
+	sparse := NewSparseTreeTopology(2, 8, func(epoch, taskID uint64) bool { return taskID != 1 || epoch%2 == 0 })
+	// 0 -> 1 -> 3, 0 -> 2 -> 3, 2 -> 4 -> 3
+	dag := NewDAGTopology(5, map[uint64][]uint64{0: {1, 2}, 1: {3}, 2: {3, 4}, 4: {3}})
+	tests := []struct {
+		topo      meritop.Topology
+		id, epoch uint64
+		want      []uint64
+	}{
+		{tree, 0, 0, []uint64{}},
+		{tree, 2, 0, []uint64{0}},
+		{tree, 7, 0, []uint64{3, 1, 0}},
+		{sparse, 7, 0, []uint64{3, 1, 0}},
+		{sparse, 7, 1, []uint64{3}},
+		{sparse, 1, 1, []uint64{}},
+		{dag, 3, 0, []uint64{1, 2, 4, 0}},
+		{dag, 4, 0, []uint64{2, 0}},
+		{dag, 0, 0, []uint64{}},
+	}
+	for i, tt := range tests {
+		if get := tt.topo.GetAncestors(tt.id, tt.epoch); !reflect.DeepEqual(get, tt.want) {
+			t.Errorf("#%d: ancestors of %d = %v, want %v", i, tt.id, get, tt.want)
+		}
+	}
+}
+
+func TestTopologyGetAdjacencyList(t *testing.T) {
+	tests := []struct {
+		topo  meritop.Topology
+		epoch uint64
+		want  map[uint64][]uint64
+	}{
+		{NewTreeTopology(2, 6), 0, map[uint64][]uint64{0: {1, 2}, 1: {3, 4}, 2: {5}}},
+		{NewTreeTopology(2, 1), 0, map[uint64][]uint64{}},
+		// Task 1 is active at even epochs only.
+		{
+			NewSparseTreeTopology(2, 6, func(epoch, taskID uint64) bool { return taskID != 1 || epoch%2 == 0 }),
+			1,
+			map[uint64][]uint64{0: {2}, 2: {5}},
+		},
+		{NewDAGTopology(3, map[uint64][]uint64{0: {1, 2}, 1: {2}, 2: {}}), 0, map[uint64][]uint64{0: {1, 2}, 1: {2}}},
+	}
+	for i, tt := range tests {
+		if get := tt.topo.GetAdjacencyList(tt.epoch); !reflect.DeepEqual(get, tt.want) {
+			t.Errorf("#%d: adjacency list = %v, want %v", i, get, tt.want)
+		}
+	}
+}
+
+func TestTopologyGetLeafTasks(t *testing.T) {
+	tests := []struct {
+		topo  meritop.Topology
+		epoch uint64
+		want  []uint64
+	}{
+		{NewTreeTopology(2, 6), 0, []uint64{3, 4, 5}},
+		{NewTreeTopology(3, 13), 0, []uint64{4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{NewTreeTopology(2, 1), 0, []uint64{0}},
+		// Task 1 is active at even epochs only.
+		{NewSparseTreeTopology(2, 6, func(epoch, taskID uint64) bool { return taskID != 1 || epoch%2 == 0 }), 0, []uint64{3, 4, 5}},
+		// Task 5 is active at even epochs only, leaving task 2 a leaf.
+		{NewSparseTreeTopology(2, 6, func(epoch, taskID uint64) bool { return taskID != 5 || epoch%2 == 0 }), 1, []uint64{2, 3, 4}},
+		{NewDAGTopology(3, map[uint64][]uint64{0: {1, 2}, 1: {2}, 2: {}}), 0, []uint64{2}},
+	}
+	for i, tt := range tests {
+		if get := tt.topo.GetLeafTasks(tt.epoch); !reflect.DeepEqual(get, tt.want) {
+			t.Errorf("#%d: leaf tasks = %v, want %v", i, get, tt.want)
+		}
+	}
+}
+
+func TestTopologyGetDepth(t *testing.T) {
+	tests := []struct {
+		topo  meritop.Topology
+		epoch uint64
+		want  uint64
+	}{
+		{NewTreeTopology(2, 1), 0, 0},
+		{NewTreeTopology(2, 2), 0, 1},
+		{NewTreeTopology(2, 3), 0, 1},
+		{NewTreeTopology(2, 7), 0, 2},
+		{NewTreeTopology(2, 8), 0, 3},
+		{NewTreeTopology(3, 13), 0, 2},
+		{NewTreeTopology(1, 4), 0, 3},
+		// Task 2 is active at even epochs only, and task 5 is below it.
+		{NewSparseTreeTopology(2, 6, func(epoch, taskID uint64) bool { return taskID != 2 || epoch%2 == 0 }), 0, 2},
+		{NewSparseTreeTopology(2, 4, func(epoch, taskID uint64) bool { return taskID != 1 || epoch%2 == 0 }), 1, 1},
+		{NewDAGTopology(1, map[uint64][]uint64{}), 0, 0},
+		{NewDAGTopology(2, map[uint64][]uint64{0: {1}}), 0, 1},
+		{NewDAGTopology(4, map[uint64][]uint64{0: {1, 3}, 1: {2}, 2: {3}}), 0, 3},
+		{NewRingReduceTopology(3, 0), 0, 3},
+	}
+	for i, tt := range tests {
+		if get := tt.topo.GetDepth(tt.epoch); get != tt.want {
+			t.Errorf("#%d: depth = %d, want %d", i, get, tt.want)
+		}
+	}
+}
+
+func TestTreeTopologyGetSubtreeTaskIDs(t *testing.T) {
+	tests := []struct {
+		root uint64
+		want []uint64
+	}{
+		{0, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{1, []uint64{1, 3, 4, 7, 8}},
+		{3, []uint64{3, 7, 8}},
+		{6, []uint64{6}},
+	}
+	topo := NewTreeTopology(2, 9)
+	for i, tt := range tests {
+		get, err := topo.GetSubtreeTaskIDs(tt.root)
+		if err != nil {
+			t.Fatalf("#%d: GetSubtreeTaskIDs failed: %v", i, err)
+		}
+		if !reflect.DeepEqual(get, tt.want) {
+			t.Errorf("#%d: subtree = %v, want %v", i, get, tt.want)
+		}
+	}
+	if _, err := topo.GetSubtreeTaskIDs(9); err == nil {
+		t.Errorf("GetSubtreeTaskIDs(9) should fail on a tree of 9 tasks")
+	}
+}
+
+func TestTreeTopologyValidate(t *testing.T) {
+	tests := []struct {
+		fanout, numOfTasks uint64
+		valid              bool
+	}{
+		{2, 7, true},
+		{3, 13, true},
+		{5, 6, true},
+		{1, 1, true},
+		{0, 7, false},
+		{2, 0, false},
+	}
+	for i, tt := range tests {
+		err := NewTreeTopology(tt.fanout, tt.numOfTasks).Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("#%d: Validate() = %v, want valid = %v", i, err, tt.valid)
+		}
+	}
+}
+
+func TestTaskGraph(t *testing.T) {
+	g := topoutil.TaskGraph(NewTreeTopology(2, 6), []uint64{0, 1, 2, 3, 4, 5}, 0)
+	if want := [][2]uint64{{0, 1}, {0, 2}, {1, 3}, {1, 4}, {2, 5}}; !reflect.DeepEqual(g.Edges, want) {
+		t.Errorf("edges = %v, want %v", g.Edges, want)
+	}
+	if get, want := g.BFS(0), []uint64{0, 1, 2, 3, 4, 5}; !reflect.DeepEqual(get, want) {
+		t.Errorf("BFS(0) = %v, want %v", get, want)
+	}
+	if get, want := g.DFS(0), []uint64{0, 1, 3, 4, 2, 5}; !reflect.DeepEqual(get, want) {
+		t.Errorf("DFS(0) = %v, want %v", get, want)
+	}
+	if get, want := g.BFS(4), []uint64{4, 1, 0, 3, 2, 5}; !reflect.DeepEqual(get, want) {
+		t.Errorf("BFS(4) = %v, want %v", get, want)
+	}
+	path, err := g.ShortestPath(3, 5)
+	if err != nil {
+		t.Fatalf("ShortestPath(3, 5) failed: %v", err)
+	}
+	if want := []uint64{3, 1, 0, 2, 5}; !reflect.DeepEqual(path, want) {
+		t.Errorf("ShortestPath(3, 5) = %v, want %v", path, want)
+	}
+	if path, err := g.ShortestPath(2, 2); err != nil || !reflect.DeepEqual(path, []uint64{2}) {
+		t.Errorf("ShortestPath(2, 2) = %v, %v, want [2]", path, err)
+	}
+	if g.BFS(9) != nil {
+		t.Errorf("BFS(9) = %v, want nil for unknown task", g.BFS(9))
+	}
+
+	// Without task 2, task 5 is cut off.
+	g = topoutil.TaskGraph(NewTreeTopology(2, 6), []uint64{0, 1, 3, 4, 5}, 0)
+	if _, err := g.ShortestPath(3, 5); err != meritop.ErrNoPath {
+		t.Errorf("ShortestPath(3, 5) = %v, want %v", err, meritop.ErrNoPath)
+	}
+}
+
+func TestRingReduceTopology(t *testing.T) {
+	tests := []struct {
+		numWorkers, masterID uint64
+		id                   uint64
+		parents, children    []uint64
+	}{
+		{3, 0, 0, []uint64{}, []uint64{3}},
+		{3, 0, 3, []uint64{0}, []uint64{2}},
+		{3, 0, 1, []uint64{2}, []uint64{}},
+		// The ring skips the master wherever it is.
+		{3, 2, 3, []uint64{2}, []uint64{1}},
+		{3, 2, 1, []uint64{3}, []uint64{0}},
+		{3, 2, 0, []uint64{1}, []uint64{}},
+	}
+	for i, tt := range tests {
+		topo := NewRingReduceTopology(tt.numWorkers, tt.masterID)
+		if err := topo.Validate(); err != nil {
+			t.Fatalf("#%d: Validate failed: %v", i, err)
+		}
+		topo.SetTaskID(tt.id)
+		if get := topo.GetParents(0); !reflect.DeepEqual(get, tt.parents) {
+			t.Errorf("#%d: parents of %d = %v, want %v", i, tt.id, get, tt.parents)
+		}
+		if get := topo.GetChildren(0); !reflect.DeepEqual(get, tt.children) {
+			t.Errorf("#%d: children of %d = %v, want %v", i, tt.id, get, tt.children)
+		}
+	}
+	if err := NewRingReduceTopology(3, 4).Validate(); err == nil {
+		t.Error("Validate with master out of range = nil, want error")
+	}
+}
@@ -0,0 +1,70 @@
+package framework
+
+import (
+	gocontext "context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// epochAbort aborts an epoch in the given attempt, i.e. after as many aborts
+// of it before. Those of earlier attempts are late or duplicates.
+type epochAbort struct {
+	epoch   uint64
+	attempt uint64
+	reason  string
+}
+
+// abortEpoch tells every task, this one included, that the epoch is
+// aborted in the attempt this task is at.
+func (f *framework) abortEpoch(epoch uint64, reason string) {
+	value := fmt.Sprintf("%d-%d-%s", epoch, atomic.LoadUint64(&f.epochAborts), reason)
+	if err := f.metaStore.Put(gocontext.TODO(), etcdutil.AbortPath(f.jobPath()), value); err != nil {
+		f.log.Printf("task %d failed to abort epoch %d: %v", f.taskID, epoch, err)
+		atomic.AddUint64(&f.errorCount, 1)
+	}
+}
+
+// watchAborts passes epoch aborts made from now on to the event loop until
+// stop.
+func (f *framework) watchAborts(stop chan struct{}) error {
+	key := etcdutil.AbortPath(f.jobPath())
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	events, err := f.metaStore.Watch(ctx, key)
+	if err != nil {
+		cancel()
+		return err
+	}
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		for ev := range events {
+			if ev.Type != meritop.KVPut || ev.Key != key {
+				continue
+			}
+			values := strings.SplitN(ev.Value, "-", 3)
+			if len(values) != 3 {
+				f.log.Printf("WARN: malformed epoch abort: %s", ev.Value)
+				continue
+			}
+			epoch, err := strconv.ParseUint(values[0], 10, 64)
+			if err != nil {
+				f.log.Printf("WARN: malformed epoch abort: %s", ev.Value)
+				continue
+			}
+			attempt, err := strconv.ParseUint(values[1], 10, 64)
+			if err != nil {
+				f.log.Printf("WARN: malformed epoch abort: %s", ev.Value)
+				continue
+			}
+			f.abortChan <- &epochAbort{epoch: epoch, attempt: attempt, reason: values[2]}
+		}
+	}()
+	return nil
+}
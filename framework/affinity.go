@@ -0,0 +1,45 @@
+package framework
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+func (f *framework) SetTaskAffinityGroups(groups map[string][]uint64) {
+	f.affinityGroups = make(map[uint64]string)
+	f.affinityErr = nil
+	for group, taskIDs := range groups {
+		for _, id := range taskIDs {
+			if other, ok := f.affinityGroups[id]; ok && other != group {
+				f.affinityErr = fmt.Errorf("task %d is in affinity groups %q and %q", id, other, group)
+			}
+			f.affinityGroups[id] = group
+		}
+	}
+}
+
+// tagAffinityGroup records the affinity group of this task in etcd for
+// schedulers to find.
+func (f *framework) tagAffinityGroup() {
+	group, ok := f.affinityGroups[f.taskID]
+	if !ok {
+		return
+	}
+	if _, err := f.etcdClient.Set(etcdutil.TaskAffinityPath(f.jobPath(), f.taskID), group, 0); err != nil {
+		f.log.Printf("task %d failed to tag affinity group %q: %v", f.taskID, group, err)
+		atomic.AddUint64(&f.errorCount, 1)
+	}
+}
+
+func (f *framework) GetAffinityGroup(taskID uint64) (string, error) {
+	resp, err := f.etcdClient.Get(etcdutil.TaskAffinityPath(f.jobPath(), taskID), false, false)
+	if err != nil {
+		if etcdutil.IsKeyNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return resp.Node.Value, nil
+}
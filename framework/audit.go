@@ -0,0 +1,101 @@
+package framework
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
+)
+
+// NewFileAuditStore returns an audit store appending records to the file at
+// path, one JSON object per line.
+func NewFileAuditStore(path string) meritop.AuditStore {
+	return &fileAuditStore{path: path}
+}
+
+type fileAuditStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (s *fileAuditStore) Append(record meritop.AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// NewEtcdAuditStore returns an audit store appending records as JSON to the
+// etcd directory dir. They are kept in order under keys etcd assigns from
+// its index, so every record is versioned by the etcd index it was written
+// at.
+func NewEtcdAuditStore(client *etcd.Client, dir string) meritop.AuditStore {
+	return &etcdAuditStore{client: client, dir: dir}
+}
+
+type etcdAuditStore struct {
+	client *etcd.Client
+	dir    string
+}
+
+func (s *etcdAuditStore) Append(record meritop.AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.CreateInOrder(s.dir, string(b), 0)
+	return err
+}
+
+func (f *framework) EnableEpochAuditLog(store meritop.AuditStore) { f.auditStore = store }
+
+// auditEpoch appends a record of the epoch event to the audit log, if
+// enabled.
+func (f *framework) auditEpoch(event string, epoch uint64) {
+	if f.auditStore == nil {
+		return
+	}
+	f.appendAudit(meritop.AuditRecord{Epoch: epoch, TaskID: f.taskID, Event: event, Time: time.Now()})
+}
+
+// auditData appends a record of the data exchanged with peerID to the audit
+// log, if enabled.
+func (f *framework) auditData(event string, epoch, peerID uint64, data []byte) {
+	if f.auditStore == nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	f.appendAudit(meritop.AuditRecord{
+		Epoch:        epoch,
+		TaskID:       f.taskID,
+		Event:        event,
+		PeerID:       peerID,
+		GradientHash: hex.EncodeToString(sum[:]),
+		Time:         time.Now(),
+	})
+}
+
+func (f *framework) appendAudit(record meritop.AuditRecord) {
+	if err := f.auditStore.Append(record); err != nil {
+		f.log.Printf("task %d: appending %q of epoch %d to audit log failed: %v",
+			f.taskID, record.Event, record.Epoch, err)
+		atomic.AddUint64(&f.errorCount, 1)
+	}
+}
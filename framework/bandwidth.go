@@ -0,0 +1,140 @@
+package framework
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+// bandwidthWindow is the time constant of the moving averages of transfer
+// rates: bytes sent that long ago weigh 1/e of those sent just now.
+const bandwidthWindow = 10 * time.Second
+
+// bandwidthMeter keeps the rates of data sent to and received from every
+// task this task talked to.
+type bandwidthMeter struct {
+	mu    sync.Mutex
+	peers map[uint64]*linkRates
+}
+
+type linkRates struct {
+	send, recv decayingCounter
+}
+
+// decayingCounter is the exponential moving average of a byte count. Its
+// value divided by bandwidthWindow is the rate of bytes per second.
+type decayingCounter struct {
+	v    float64
+	last time.Time
+}
+
+func (c *decayingCounter) valueAt(now time.Time) float64 {
+	if c.last.IsZero() || !now.After(c.last) {
+		return c.v
+	}
+	return c.v * math.Exp(-now.Sub(c.last).Seconds()/bandwidthWindow.Seconds())
+}
+
+func (c *decayingCounter) add(now time.Time, n int) {
+	c.v = c.valueAt(now) + float64(n)
+	c.last = now
+}
+
+func (m *bandwidthMeter) link(taskID uint64) *linkRates {
+	if m.peers == nil {
+		m.peers = make(map[uint64]*linkRates)
+	}
+	l, ok := m.peers[taskID]
+	if !ok {
+		l = &linkRates{}
+		m.peers[taskID] = l
+	}
+	return l
+}
+
+func (m *bandwidthMeter) sent(now time.Time, taskID uint64, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.link(taskID).send.add(now, n)
+}
+
+func (m *bandwidthMeter) received(now time.Time, taskID uint64, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.link(taskID).recv.add(now, n)
+}
+
+func (m *bandwidthMeter) rates(now time.Time) map[uint64]meritop.BandwidthStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[uint64]meritop.BandwidthStats, len(m.peers))
+	for id, l := range m.peers {
+		stats[id] = meritop.BandwidthStats{
+			SendBytesPerSec: l.send.valueAt(now) / bandwidthWindow.Seconds(),
+			RecvBytesPerSec: l.recv.valueAt(now) / bandwidthWindow.Seconds(),
+		}
+	}
+	return stats
+}
+
+// tokenBucket limits a rate of bytes. It holds up to a second worth of
+// tokens, so bursts up to the rate go through at once.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns nil, i.e. no limit, for a rate not positive.
+func newTokenBucket(bytesPerSec int64) *tokenBucket {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: float64(bytesPerSec), tokens: float64(bytesPerSec)}
+}
+
+// wait takes n tokens, blocking until the bucket has refilled enough. Takers
+// that find it empty queue up by going into debt, so they are let through
+// in order at the rate.
+func (b *tokenBucket) wait(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	time.Sleep(d)
+}
+
+func (f *framework) SetBandwidthLimit(bytesPerSec int64) { f.sendLimit = newTokenBucket(bytesPerSec) }
+
+func (f *framework) SetReceiveBandwidthLimit(bytesPerSec int64) {
+	f.recvLimit = newTokenBucket(bytesPerSec)
+}
+
+// sending waits for the send limit, if any, to allow n bytes to go to taskID.
+func (f *framework) sending(taskID uint64, n int) {
+	f.sendLimit.wait(n)
+	f.bandwidth.sent(time.Now(), taskID, n)
+}
+
+func (f *framework) GetBandwidthStats() map[[2]uint64]meritop.BandwidthStats {
+	rates := f.bandwidth.rates(time.Now())
+	stats := make(map[[2]uint64]meritop.BandwidthStats, len(rates))
+	for id, r := range rates {
+		stats[[2]uint64{f.taskID, id}] = r
+	}
+	return stats
+}
@@ -0,0 +1,157 @@
+package framework
+
+import (
+	gocontext "context"
+	"fmt"
+	"path"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+type groupBarrier struct {
+	taskIDs []uint64
+	fn      func(ctx meritop.Context, epoch uint64)
+}
+
+func (f *framework) SetTaskGroupBarrier(group string, taskIDs []uint64, fn func(ctx meritop.Context, epoch uint64)) {
+	if f.barriers == nil {
+		f.barriers = make(map[string]*groupBarrier)
+	}
+	f.barriers[group] = &groupBarrier{taskIDs: taskIDs, fn: fn}
+}
+
+// barrierScope is an attempt of an epoch, in which barriers are reached.
+// Waiting at them ends with it.
+type barrierScope struct {
+	epoch   uint64
+	attempt uint64
+	ctx     gocontext.Context
+	cancel  gocontext.CancelFunc
+	keys    []string
+}
+
+// startBarrierScope starts the scope of the current attempt of the epoch.
+// It must be called from the event loop.
+func (f *framework) startBarrierScope() {
+	parent := f.ctx
+	if parent == nil {
+		parent = gocontext.Background()
+	}
+	ctx, cancel := gocontext.WithCancel(parent)
+	f.barrierMu.Lock()
+	defer f.barrierMu.Unlock()
+	f.barrierScope = &barrierScope{
+		epoch:   f.epoch,
+		attempt: atomic.LoadUint64(&f.epochAborts),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// endBarrierScope stops waiting at barriers of the scope. The keys reached
+// in it are deleted once the next scope ends too, so that slower tasks of the
+// group still find them in the meantime.
+func (f *framework) endBarrierScope() {
+	f.barrierMu.Lock()
+	s := f.barrierScope
+	f.barrierScope = nil
+	f.barrierMu.Unlock()
+	if s == nil {
+		return
+	}
+	s.cancel()
+	old := f.oldBarrierKeys
+	f.oldBarrierKeys = s.keys
+	if len(old) > 0 {
+		go f.deleteBarrierKeys(old)
+	}
+}
+
+func (f *framework) deleteBarrierKeys(keys []string) {
+	for _, key := range keys {
+		if err := f.metaStore.Delete(gocontext.TODO(), key); err != nil && err != meritop.ErrKeyNotFound {
+			f.log.Printf("task %d failed to delete barrier key %s: %v", f.taskID, key, err)
+		}
+	}
+}
+
+// reachBarrier records in the metadata store that this task reached the group
+// barrier in the current attempt of the epoch of ctx, and waits for the rest
+// of the group to do the same before calling the barrier function. Waiting
+// fails once the attempt is over.
+func (f *framework) reachBarrier(ctx *context, group string) error {
+	b, ok := f.barriers[group]
+	if !ok {
+		return fmt.Errorf("no barrier for group %q", group)
+	}
+	waiting := make(map[uint64]bool, len(b.taskIDs))
+	for _, id := range b.taskIDs {
+		waiting[id] = true
+	}
+	if !waiting[f.taskID] {
+		return fmt.Errorf("task %d isn't in group %q", f.taskID, group)
+	}
+
+	// Without a scope, i.e. before the task started, the wait is only
+	// bound by the context of the framework.
+	parent, attempt := f.ctx, uint64(0)
+	if parent == nil {
+		parent = gocontext.Background()
+	}
+	f.barrierMu.Lock()
+	s := f.barrierScope
+	f.barrierMu.Unlock()
+	if s != nil {
+		if s.epoch != ctx.epoch {
+			return fmt.Errorf("epoch %d is over", ctx.epoch)
+		}
+		parent, attempt = s.ctx, s.attempt
+	}
+	wctx, cancel := gocontext.WithCancel(parent)
+	defer cancel()
+	// Watch first, so that no task reaching it after the gets is missed.
+	dir := etcdutil.BarrierDir(f.jobPath(), group, ctx.epoch, attempt)
+	events, err := f.metaStore.Watch(wctx, dir)
+	if err != nil {
+		return err
+	}
+	key := etcdutil.BarrierPath(f.jobPath(), group, ctx.epoch, attempt, f.taskID)
+	if err := f.metaStore.Put(wctx, key, strconv.FormatUint(f.taskID, 10)); err != nil {
+		return err
+	}
+	if s != nil {
+		f.barrierMu.Lock()
+		s.keys = append(s.keys, key)
+		f.barrierMu.Unlock()
+	}
+	for id := range waiting {
+		_, err := f.metaStore.Get(wctx, etcdutil.BarrierPath(f.jobPath(), group, ctx.epoch, attempt, id))
+		switch err {
+		case nil:
+			delete(waiting, id)
+		case meritop.ErrKeyNotFound:
+		default:
+			return err
+		}
+	}
+	for len(waiting) > 0 {
+		ev, ok := <-events
+		if !ok {
+			return fmt.Errorf("task %d stopped waiting at barrier %q: %v", f.taskID, group, wctx.Err())
+		}
+		// Those of e.g. attempt 10 share the prefix of attempt 1.
+		if ev.Type != meritop.KVPut || path.Dir(ev.Key) != dir {
+			continue
+		}
+		if id, err := strconv.ParseUint(path.Base(ev.Key), 10, 64); err == nil {
+			delete(waiting, id)
+		}
+	}
+	if b.fn != nil {
+		b.fn(ctx, ctx.epoch)
+	}
+	return nil
+}
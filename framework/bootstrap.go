@@ -1,20 +1,42 @@
 package framework
 
 import (
+	gocontext "context"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/go-distributed/meritop"
 	"github.com/go-distributed/meritop/framework/frameworkhttp"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
+	"github.com/go-distributed/meritop/pkg/metastore"
+	"github.com/go-distributed/meritop/pkg/topology"
+	"github.com/go-distributed/meritop/pkg/topoutil"
 )
 
 type taskRole int
 
+// go-etcd's default dial timeout
+const defaultEtcdDialTimeout = time.Second
+
+const defaultMaxMessageSize = 100 << 20
+
+const defaultMaxPendingMetaFlags = 100
+
+const defaultRPCTimeout = 30 * time.Second
+
+// the gap between registrations of consecutive tasks in the worker init order.
+const initOrderStep = 10 * time.Millisecond
+
 const (
 	roleNone taskRole = iota
 	roleParent
@@ -31,30 +53,267 @@ func NewBootStrap(jobName string, etcdURLs []string, ln net.Listener, logger *lo
 	}
 }
 
+// The job name scopes all etcd keys, so it can't change once started.
+func (f *framework) SetJobName(name string) {
+	if f.started {
+		panic("SetJobName called after Start")
+	}
+	f.name = name
+}
+
+func (f *framework) SetCustomEpochKey(fn func(jobName string, epoch uint64) string) {
+	if f.started {
+		panic("SetCustomEpochKey called after Start")
+	}
+	f.customEpochKey = fn
+}
+
+func (f *framework) SetCustomTaskKey(fn func(jobName string, taskID uint64) string) {
+	if f.started {
+		panic("SetCustomTaskKey called after Start")
+	}
+	f.customTaskKey = fn
+}
+
 func (f *framework) SetTaskBuilder(taskBuilder meritop.TaskBuilder) { f.taskBuilder = taskBuilder }
 
-func (f *framework) SetTopology(topology meritop.Topology) { f.topology = topology }
+func (f *framework) SetTopology(topology meritop.Topology) error {
+	if !f.started {
+		f.storeTopology(topology)
+		return nil
+	}
+	if atomic.LoadInt32(&f.epochInProgress) == 1 {
+		return meritop.ErrEpochInProgress
+	}
+	f.UpdateTopology(topology)
+	return nil
+}
+
+// SetTopologyJSON sets the topology described by config, see
+// topology.ParseJSON for the format.
+func (f *framework) SetTopologyJSON(config string) error {
+	topo, err := topology.ParseJSON([]byte(config))
+	if err != nil {
+		return err
+	}
+	return f.SetTopology(topo)
+}
+
+func (f *framework) SetKeyPrefix(prefix string) { f.keyPrefix = prefix }
+
+func (f *framework) SetEtcdNamespace(ns string) error {
+	if err := etcdutil.CheckNamespace(ns); err != nil {
+		return err
+	}
+	f.namespace = ns
+	return nil
+}
+
+func (f *framework) SetEtcdDialTimeout(d time.Duration) { f.etcdDialTimeout = d }
+
+func (f *framework) SetEtcdRequestTimeout(d time.Duration) { f.etcdRequestTimeout = d }
+
+func (f *framework) SetEtcdCredentials(username, password string) {
+	f.etcdUsername, f.etcdPassword = username, password
+}
+
+func (f *framework) UseSharedEtcdPool(pool meritop.EtcdPool) { f.etcdPool = pool }
+
+func (f *framework) SetMetadataStore(store meritop.MetadataStore) { f.metaStore = store }
+
+func (f *framework) SetCoordinationBackend(backend meritop.CoordinationBackend) {
+	f.backend = backend
+}
+
+func (f *framework) SetWorkerPoolSize(n int) { f.workerPoolSize = n }
+
+func (f *framework) SetMaxMessageSize(bytes int64) { f.maxMessageSize = bytes }
+
+func (f *framework) SetMaxPendingMetaFlags(n int) { f.maxPendingMetaFlags = n }
+
+func (f *framework) SetMetaFlagConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	f.metaFlagConcurrency = n
+}
+
+func (f *framework) SetRPCTimeout(d time.Duration) { f.rpcTimeout = d }
+
+func (f *framework) SetMetricsSink(sink meritop.MetricsSink) { f.metricsSink = sink }
+
+func (f *framework) LoadConfigFromEnv(prefix string) { f.envConfigPrefix = prefix }
+
+func (f *framework) SetStrictEnvConfig(strict bool) { f.strictEnvConfig = strict }
+
+func (f *framework) SetEpochSkipPolicy(fn func(epoch uint64) bool) { f.epochSkipPolicy = fn }
+
+func (f *framework) SetNodeProducer(ch chan<- bool) { f.nodeProducer = ch }
+
+func (f *framework) WithContext(ctx gocontext.Context) meritop.Bootstrap {
+	f.ctx = ctx
+	return f
+}
+
+func (f *framework) SetAggregationQuorum(quorum float64) { f.aggregationQuorum = quorum }
+
+func (f *framework) SetStalenessWindow(w uint64) { f.stalenessWindow = w }
+
+func (f *framework) SetEpochValidator(fn func(epoch uint64, gradients map[uint64][]byte) error) {
+	f.epochValidator = fn
+}
+
+func (f *framework) SetMaxEpochRetries(n int) { f.maxEpochRetries = n }
+
+func (f *framework) SetCustomEpochTransition(fn func(ctx meritop.Context, fromEpoch, toEpoch uint64) error) {
+	f.epochTransition = fn
+}
+
+func (f *framework) SetMaxTransitionRetries(n int) { f.maxTransitionRetries = n }
+
+func (f *framework) SetChildResponseValidator(fn func(childID, epoch uint64, data []byte) error) {
+	f.childResponseValidator = fn
+}
+
+func (f *framework) SetMaxChildResponseRetries(n int) { f.maxResponseRetries = n }
+
+func (f *framework) SetEpochIDGenerator(fn func(prevEpoch uint64) uint64) { f.epochIDGenerator = fn }
+
+func (f *framework) SetEpochCompletionCallback(fn func(epoch uint64, gradientSum []byte)) {
+	f.epochCompletion = fn
+}
+
+func (f *framework) SetWorkerInitOrder(fn func(numTasks uint64) []uint64) { f.workerInitOrder = fn }
+
+func (f *framework) SetDataRequestPolicy(policy meritop.DataRequestPolicy) {
+	f.dataRequestPolicy = policy
+}
+
+func (f *framework) SetEpochCommitStrategy(strategy meritop.CommitStrategy) {
+	f.commitStrategy = strategy
+}
+
+func (f *framework) SetParameterUpdateFunction(fn func(param, gradient []byte) []byte) {
+	f.paramUpdate = fn
+}
+
+func (f *framework) SetWarmupEpochs(n uint64) { f.warmupEpochs = n }
+
+func (f *framework) SetMaxParallelEpochs(n int) { f.maxParallelEpochs = n }
+
+func (f *framework) SetNodeID(nodeID string) { f.nodeID = nodeID }
+
+func (f *framework) SetInitTimeout(d time.Duration) { f.initTimeout = d }
+
+func (f *framework) SetStartupWaitTimeout(d time.Duration) { f.startupWaitTimeout = d }
+
+func (f *framework) SetMaxTaskRestarts(n int) { f.maxTaskRestarts = n }
+
+func (f *framework) SetShutdownTimeout(d time.Duration) { f.shutdownTimeout = d }
+
+func (f *framework) SetOnShutdown(fn func(cause meritop.ShutdownCause)) { f.onShutdown = fn }
+
+func (f *framework) SetSharedMemoryOptimization(enabled bool) { f.sharedMemory = enabled }
+
+func (f *framework) SetMaxEpochsPerSecond(rate float64) { f.maxEpochsPerSecond = rate }
+
+func (f *framework) SetMessageInterceptor(fn func(from, to, epoch uint64, payload []byte) []byte) {
+	f.messageInterceptor = fn
+}
+
+func (f *framework) SetGlobalSeed(seed int64) { f.seed, f.seedSet = seed, true }
 
 func (f *framework) Start() {
 	var err error
+	f.started = true
+	f.startTime = time.Now()
 
 	if f.log == nil {
 		f.log = log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate)
 	}
 
-	f.etcdClient = etcd.NewClient(f.etcdURLs)
+	if f.envConfigPrefix != "" {
+		f.applyEnvConfig()
+	}
+	if f.nodeID == "" {
+		if f.nodeID, err = os.Hostname(); err != nil {
+			f.log.Printf("WARN: task has no node ID: %v", err)
+		}
+	}
+	if err := f.loadTopology().Validate(); err != nil {
+		f.log.Printf("invalid topology, refuse to start: %v", err)
+		return
+	}
+	if err := f.checkTaskCount(); err != nil {
+		f.log.Printf("refuse to start: %v", err)
+		return
+	}
+	if f.affinityErr != nil {
+		f.log.Printf("invalid affinity groups, refuse to start: %v", f.affinityErr)
+		return
+	}
+	if f.tlsRequired && f.tlsConfig == nil {
+		f.log.Printf("TLS required but not configured, refuse to start")
+		return
+	}
+	f.rpcClient = frameworkhttp.NewClient(f.tlsConfig)
+	if f.recvLimit != nil {
+		f.rpcClient.ThrottleReceive(f.recvLimit.wait)
+	}
+	if v, ok := f.taskBuilder.(meritop.ValidatableTaskBuilder); ok {
+		if err := v.Validate(); err != nil {
+			f.log.Printf("invalid task builder, refuse to start: %v", err)
+			return
+		}
+	}
+	if b, ok := f.taskBuilder.(meritop.IterativeTaskBuilder); ok {
+		f.epochDurations = make([]time.Duration, 0, b.NumberOfEpochs())
+	}
+
+	f.etcdClient = f.newEtcdClient(f.etcdRequestTimeout)
+	f.watchClient = f.etcdClient
+	if f.etcdRequestTimeout != 0 {
+		f.watchClient = f.newEtcdClient(0)
+	}
+	if err := f.checkEtcdConnection(); err != nil {
+		f.log.Printf("task refuses to start: %v", err)
+		return
+	}
+	customBackend := f.backend != nil
+	if !customBackend {
+		backend := metastore.NewEtcdBackend(f.etcdClient)
+		backend.SetWatchClient(f.watchClient)
+		f.backend = backend
+	}
+	if f.metaStore == nil {
+		f.metaStore = f.backend
+	}
 
 	if err = f.occupyTask(); err != nil {
-		f.log.Fatalf("occupyTask() failed: %v", err)
+		f.etcdFailed("occupyTask() failed: %v", err)
+	}
+	if customBackend {
+		if err := f.backend.Register(gocontext.TODO(), f.taskKey(f.taskID), f.ln.Addr().String()); err != nil {
+			f.log.Printf("task %d refuses to start: registering failed: %v", f.taskID, err)
+			return
+		}
+		f.registered = true
+	}
+	f.tagAffinityGroup()
+	localTasks.Store(f.taskKey(f.taskID), f)
+
+	if f.replayDir != "" || f.replaySnapshot {
+		f.moveToReplayEpoch()
 	}
 
 	f.epochChan = make(chan uint64, 1) // grab epoch from etcd
 	f.epochStop = make(chan bool, 1)   // stop etcd watch
 	// meta will have epoch prepended so we must get epoch before any watch on meta
-	f.epoch, err = etcdutil.GetAndWatchEpoch(f.etcdClient, f.name, f.epochChan, f.epochStop)
+	epoch, err := f.getAndWatchEpoch()
 	if err != nil {
-		f.log.Fatalf("WatchEpoch failed: %v", err)
+		f.etcdFailed("WatchEpoch failed: %v", err)
 	}
+	f.setEpochOfLoop(epoch)
 	if f.epoch == exitEpoch {
 		f.log.Printf("task %d found that job has finished\n", f.taskID)
 		f.epochStop <- true
@@ -66,46 +325,275 @@ func (f *framework) Start() {
 	// Both should be initialized at this point.
 	// Get the task implementation and topology for this node (indentified by taskID)
 	f.task = f.taskBuilder.GetTask(f.taskID)
-	f.topology.SetTaskID(f.taskID)
+	f.loadTopology().SetTaskID(f.taskID)
 
 	go f.startHTTP()
 
 	f.heartbeat()
 	f.setupChannels()
-	f.task.Init(f.taskID, f)
+	if f.supervisor != nil {
+		go f.reportToSupervisor(f.heartbeatStop)
+	}
+	if err := f.watchAborts(f.heartbeatStop); err != nil {
+		f.etcdFailed("watching epoch aborts failed: %v", err)
+	}
+	if err := f.watchCommands(f.heartbeatStop); err != nil {
+		f.etcdFailed("watching commands failed: %v", err)
+	}
+	if err := f.initTask(); err != nil {
+		f.log.Printf("task %d failed to init, shutting down job: %v", f.taskID, err)
+		f.ShutdownJobWithCause(meritop.TaskFailure)
+		f.notifyShutdown(meritop.TaskFailure)
+		f.releaseResource()
+		return
+	}
+	f.restoreFromCheckpoint()
+	if f.epoch == 0 && f.startupWaitTimeout > 0 {
+		if err := f.WaitForAllTasks(f.startupWaitTimeout); err != nil {
+			f.log.Printf("task %d starting epoch 0 without all tasks: %v", f.taskID, err)
+			atomic.AddUint64(&f.errorCount, 1)
+		}
+	}
 	f.run()
+	// A node that stopped on its own, e.g. failed, doesn't get to exit nicely.
+	if f.epoch == exitEpoch {
+		f.exitTask()
+	}
+	f.notifyShutdown(f.shutdownCause())
 	f.releaseResource()
 }
 
+// checkTaskCount checks that the task builder and the topology agree on the
+// number of tasks, if both know it.
+func (f *framework) checkTaskCount() error {
+	b, ok := f.taskBuilder.(meritop.SizedTaskBuilder)
+	if !ok || b.GetTaskCount() == 0 {
+		return nil
+	}
+	t, ok := f.loadTopology().(meritop.SizedTopology)
+	if !ok {
+		return nil
+	}
+	if b.GetTaskCount() != t.GetTaskCount() {
+		return fmt.Errorf("task builder has %d tasks, topology has %d", b.GetTaskCount(), t.GetTaskCount())
+	}
+	return nil
+}
+
+// newEtcdClient creates an etcd client honoring the configured dial timeout.
+// Requests time out after requestTimeout unless it is zero.
+func (f *framework) newEtcdClient(requestTimeout time.Duration) *etcd.Client {
+	client := etcd.NewClient(f.etcdURLs)
+	client.SetDialTimeout(f.dialTimeout())
+	if f.etcdUsername != "" {
+		client.SetCredentials(f.etcdUsername, f.etcdPassword)
+	}
+	if f.etcdPool != nil {
+		client.SetTransport(f.etcdPool.Transport(f.etcdURLs, f.dialTimeout(), requestTimeout))
+	} else if requestTimeout != 0 {
+		client.SetTransport(&http.Transport{
+			Dial:                  (&net.Dialer{Timeout: f.dialTimeout(), KeepAlive: time.Second}).Dial,
+			ResponseHeaderTimeout: requestTimeout,
+		})
+	}
+	return client
+}
+
+// checkEtcdConnection warns if none of the etcd members can be reached, which
+// otherwise shows up as a silent hang at startup. It returns
+// ErrAuthenticationFailed if etcd rejects the credentials.
+func (f *framework) checkEtcdConnection() error {
+	_, err := f.etcdClient.Get("/", false, false)
+	if isUnauthorized(err) {
+		return meritop.ErrAuthenticationFailed
+	}
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcd.ErrCodeEtcdNotReachable {
+		f.log.Printf("WARN: task couldn't connect to etcd %v within dial timeout %v: %v",
+			f.etcdURLs, f.dialTimeout(), err)
+	}
+	return nil
+}
+
+// isUnauthorized tells whether etcd rejected the credentials of a request.
+func isUnauthorized(err error) bool {
+	e, ok := err.(*etcd.EtcdError)
+	return ok && e.ErrorCode == etcd.ErrCodeUnhandledHTTPStatus &&
+		strings.Contains(e.Cause, http.StatusText(http.StatusUnauthorized))
+}
+
+func (f *framework) dialTimeout() time.Duration {
+	if f.etcdDialTimeout == 0 {
+		return defaultEtcdDialTimeout
+	}
+	return f.etcdDialTimeout
+}
+
+// initTask calls Init of the task. If Init doesn't return within the init
+// timeout, the task is told to exit and a new one is tried in its place.
+func (f *framework) initTask() error {
+	if f.initTimeout == 0 {
+		f.task.Init(f.taskID, f)
+		return nil
+	}
+	for restarts := 0; ; restarts++ {
+		done := make(chan struct{})
+		go func() {
+			f.task.Init(f.taskID, f)
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-time.After(f.initTimeout):
+		}
+		f.task.Exit()
+		f.log.Printf("task %d Init timed out after %v", f.taskID, f.initTimeout)
+		if restarts >= f.maxTaskRestarts {
+			return fmt.Errorf("Init timed out %d times", restarts+1)
+		}
+		// The timed out Init might still be running, so we don't reuse the task.
+		f.task = f.taskBuilder.GetTask(f.taskID)
+	}
+}
+
+// exitTask gives the task the last chance to save work once the job is shut
+// down, waiting at most the shutdown timeout for it.
+func (f *framework) exitTask() {
+	done := make(chan struct{})
+	go func() {
+		f.task.Exit()
+		close(done)
+	}()
+	if f.shutdownTimeout == 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(f.shutdownTimeout):
+		f.log.Printf("task %d Exit didn't return within %v", f.taskID, f.shutdownTimeout)
+	}
+}
+
 func (f *framework) setupChannels() {
 	f.httpStop = make(chan struct{})
 	f.metaChan = make(chan *metaChange, 100)
 	f.dataReqtoSendChan = make(chan *dataRequest, 100)
+	f.bulkReqToSendChan = make(chan []*dataRequest, 100)
 	f.dataReqChan = make(chan *dataRequest, 100)
 	f.dataRespToSendChan = make(chan *dataResponse, 100)
 	f.dataRespChan = make(chan *frameworkhttp.DataResponse, 100)
+	f.dataFailChan = make(chan *dataFailure, 100)
+	f.dataPushToSendChan = make(chan *dataPush, 100)
+	f.dataPushChan = make(chan *dataPush, 100)
+	if f.workerPoolSize <= 0 {
+		f.workerPoolSize = runtime.NumCPU()
+	}
+	f.serveSlots = make(chan struct{}, f.workerPoolSize)
+	if f.maxPendingMetaFlags <= 0 {
+		f.maxPendingMetaFlags = defaultMaxPendingMetaFlags
+	}
+	f.metaFlagSlots = make(chan struct{}, f.maxPendingMetaFlags)
+	f.childResponses = make(map[uint64]map[uint64][]byte)
+	f.epochRetryChan = make(chan uint64, 10)
+	f.commitChan = make(chan commitTimeout, 10)
+	f.abortChan = make(chan *epochAbort, 10)
+	f.commandChan = make(chan meritop.Command, 10)
+	f.supervisorCmdChan = make(chan meritop.SupervisorCommand, 10)
+	if f.dedupEnabled {
+		window := f.dedupWindow
+		if window <= 0 {
+			window = defaultDeduplicationWindow
+		}
+		f.dedup = newRequestDedup(window)
+	}
 }
 
 func (f *framework) run() {
 	f.log.Printf("framework of task %d starts to run", f.taskID)
 	defer f.log.Printf("framework of task %d stops running.", f.taskID)
+	// nil, i.e. never ready, without a context.
+	var ctxDone <-chan struct{}
+	if f.ctx != nil {
+		ctxDone = f.ctx.Done()
+	}
 	f.setEpochStarted()
 	for {
 		select {
+		case <-ctxDone:
+			f.log.Printf("task %d stops as its context is done: %v", f.taskID, f.ctx.Err())
+			f.releaseEpochResource()
+			return
 		case nextEpoch, ok := <-f.epochChan:
+			atomic.StoreInt32(&f.epochInProgress, 0)
 			f.releaseEpochResource()
 			if !ok { // single task exit
 				nextEpoch = exitEpoch
 				return
 			}
-			f.epoch = nextEpoch
+			f.recordEpochDuration(f.epoch)
+			f.auditEpoch(meritop.AuditEpochCompleted, f.epoch)
+			if nextEpoch != exitEpoch {
+				f.checkpointEpoch(f.epoch)
+			}
+			f.setEpochOfLoop(nextEpoch)
+			atomic.StoreUint64(&f.epochAborts, 0)
 			if f.epoch == exitEpoch {
 				return
 			}
+			f.pruneChildResponses()
+			f.applyTopologyUpdate()
+			if !f.paceEpoch(ctxDone) {
+				f.log.Printf("task %d stops as its context is done: %v", f.taskID, f.ctx.Err())
+				return
+			}
 			// start the next epoch's work
 			f.setEpochStarted()
+		case cmd := <-f.supervisorCmdChan:
+			switch cmd.Type {
+			case meritop.CommandPause:
+				if !f.waitResume(ctxDone) {
+					f.log.Printf("task %d stops as its context is done: %v", f.taskID, f.ctx.Err())
+					f.releaseEpochResource()
+					return
+				}
+			case meritop.CommandResume:
+			default:
+				f.handleSupervisorCommand(cmd)
+			}
+		case epoch := <-f.epochRetryChan:
+			if epoch != f.epoch {
+				break
+			}
+			f.releaseEpochResource()
+			f.restartEpoch()
+		case c := <-f.commitChan:
+			// An aborted or retried attempt doesn't time out the next one.
+			if c.attempt != atomic.LoadUint64(&f.attempt) {
+				break
+			}
+			f.commitEpoch(c.epoch)
+		case a := <-f.abortChan:
+			if a.epoch != f.epoch {
+				break
+			}
+			if a.attempt != atomic.LoadUint64(&f.epochAborts) {
+				f.log.Printf("task %d ignored abort of epoch %d in attempt %d: %s", f.taskID, a.epoch, a.attempt, a.reason)
+				break
+			}
+			atomic.AddUint64(&f.epochAborts, 1)
+			f.log.Printf("task %d: epoch %d aborted: %s", f.taskID, a.epoch, a.reason)
+			f.auditEpoch(meritop.AuditEpochAborted, a.epoch)
+			f.releaseEpochResource()
+			if h, ok := f.task.(meritop.EpochAbortHandler); ok {
+				h.EpochAborted(f.createContext(), a.epoch, a.reason)
+			}
+			f.restartEpoch()
+		case cmd := <-f.commandChan:
+			f.handleCommand(cmd)
 		case meta := <-f.metaChan:
 			if meta.epoch != f.epoch {
+				atomic.AddInt64(&f.pendingMeta, -1)
 				break
 			}
 			// We need to create a context before handling next event. The context saves
@@ -114,14 +602,11 @@ func (f *framework) run() {
 			// with previous information.
 			go f.handleMetaChange(f.createContext(), meta.who, meta.from, meta.meta)
 		case req := <-f.dataReqtoSendChan:
-			if req.epoch != f.epoch {
-				f.log.Printf("epoch mismatch: task %d, req-to-send epoch: %d, current epoch: %d",
-					f.taskID, req.epoch, f.epoch)
-				break
-			}
-			go f.sendRequest(req)
+			f.sendRequests(req)
+		case reqs := <-f.bulkReqToSendChan:
+			f.sendRequests(reqs...)
 		case req := <-f.dataReqChan:
-			if req.epoch != f.epoch {
+			if !f.isActiveEpoch(req.epoch) {
 				f.log.Printf("epoch mismatch: task %d, request epoch: %d, current epoch: %d",
 					f.taskID, req.epoch, f.epoch)
 				req.notifyEpochMismatch()
@@ -129,7 +614,7 @@ func (f *framework) run() {
 			}
 			go f.handleDataReq(req)
 		case resp := <-f.dataRespToSendChan:
-			if resp.epoch != f.epoch {
+			if !f.isActiveEpoch(resp.epoch) {
 				f.log.Printf("epoch mismatch: task %d, resp-to-send epoch: %d, current epoch: %d",
 					f.taskID, resp.epoch, f.epoch)
 				resp.notifyEpochMismatch()
@@ -137,25 +622,137 @@ func (f *framework) run() {
 			}
 			go f.sendResponse(resp)
 		case resp := <-f.dataRespChan:
-			if resp.Epoch != f.epoch {
+			if !f.withinStaleness(resp.Epoch) {
 				f.log.Printf("epoch mismatch: task %d, response epoch: %d, current epoch: %d",
 					f.taskID, resp.Epoch, f.epoch)
 				break
 			}
-			go f.handleDataResp(f.createContext(), resp)
+			if resp.Epoch == f.epoch && resp.Attempt != atomic.LoadUint64(&f.attempt) {
+				f.log.Printf("task %d dropped response %q from task %d to an aborted attempt of epoch %d",
+					f.taskID, resp.Req, resp.TaskID, resp.Epoch)
+				break
+			}
+			if !f.dedup.deliver(requestKey{resp.TaskID, resp.Epoch, resp.Req}) {
+				f.log.Printf("task %d dropped duplicate response %q from task %d", f.taskID, resp.Req, resp.TaskID)
+				break
+			}
+			if f.isActiveEpoch(resp.Epoch) && topoutil.IsChild(f.loadTopology(), resp.Epoch, resp.TaskID) {
+				f.childMu.Lock()
+				if f.childResponses[resp.Epoch] == nil {
+					f.childResponses[resp.Epoch] = make(map[uint64][]byte)
+				}
+				f.childResponses[resp.Epoch][resp.TaskID] = resp.Data
+				f.childMu.Unlock()
+				f.commitIfReady(resp.Epoch)
+			}
+			go f.handleDataResp(f.createContextAt(f.slotEpoch(resp.Epoch)), resp)
+		case fail := <-f.dataFailChan:
+			if !f.isActiveEpoch(fail.epoch) {
+				f.log.Printf("epoch mismatch: task %d, failure epoch: %d, current epoch: %d",
+					f.taskID, fail.epoch, f.epoch)
+				break
+			}
+			go f.handleDataFailure(f.createContextAt(fail.epoch), fail)
+		case push := <-f.dataPushToSendChan:
+			if !f.isActiveEpoch(push.epoch) {
+				f.log.Printf("epoch mismatch: task %d, push-to-send epoch: %d, current epoch: %d",
+					f.taskID, push.epoch, f.epoch)
+				break
+			}
+			go f.sendPush(push)
+		case push := <-f.dataPushChan:
+			if !f.withinStaleness(push.epoch) {
+				f.log.Printf("epoch mismatch: task %d, push epoch: %d, current epoch: %d",
+					f.taskID, push.epoch, f.epoch)
+				push.errChan <- frameworkhttp.ErrReqEpochMismatch
+				break
+			}
+			push.errChan <- nil
+			go f.handleDataPush(f.createContextAt(f.slotEpoch(push.epoch)), push)
 		}
 	}
 }
 
 func (f *framework) setEpochStarted() {
+	if f.epochSkipPolicy != nil && f.epochSkipPolicy(f.epoch) {
+		f.skipEpoch(f.epoch)
+		return
+	}
+	if f.injectedFault("SetEpoch", f.epoch) {
+		return
+	}
+	f.epochStartTime = time.Now()
+	f.lastEpochStart = f.epochStartTime
+	f.auditEpoch(meritop.AuditEpochStarted, f.epoch)
+	atomic.StoreInt32(&f.epochInProgress, 1)
+	f.startBarrierScope()
 	f.task.SetEpoch(f.createContext(), f.epoch)
 
 	// setup etcd watches
 	// - create self's parent and child meta flag
 	// - watch parents' child meta flag
 	// - watch children's parent meta flag
-	f.watchMeta(roleParent, f.topology.GetParents(f.epoch))
-	f.watchMeta(roleChild, f.topology.GetChildren(f.epoch))
+	topo := f.loadTopology()
+	f.watchMeta(roleParent, topo.GetParents(f.epoch))
+	f.watchMeta(roleChild, topo.GetChildren(f.epoch))
+	f.startCommit(f.epoch)
+}
+
+// paceEpoch blocks the event loop until the next epoch may start without
+// exceeding the max epochs per second. It returns false if the node should
+// stop instead.
+func (f *framework) paceEpoch(ctxDone <-chan struct{}) bool {
+	if f.maxEpochsPerSecond <= 0 || f.lastEpochStart.IsZero() {
+		return true
+	}
+	interval := time.Duration(float64(time.Second) / f.maxEpochsPerSecond)
+	wait := f.lastEpochStart.Add(interval).Sub(time.Now())
+	if wait <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(wait):
+		return true
+	case <-ctxDone:
+		return false
+	}
+}
+
+// restartEpoch starts the current epoch over, after it was aborted or failed
+// validation, forgetting what children sent in it. It must be called from
+// the event loop.
+func (f *framework) restartEpoch() {
+	atomic.AddUint64(&f.attempt, 1)
+	f.childMu.Lock()
+	delete(f.childResponses, f.epoch)
+	f.childMu.Unlock()
+	f.dedup.forgetEpoch(f.epoch)
+	f.responseRetryMu.Lock()
+	for k := range f.responseRetries {
+		if k.epoch == f.epoch {
+			delete(f.responseRetries, k)
+		}
+	}
+	f.responseRetryMu.Unlock()
+	if f.restartedEpoch != f.epoch {
+		f.restartedEpoch, f.epochAttempts = f.epoch, 0
+	}
+	f.epochAttempts++
+	if h, ok := f.task.(meritop.EpochRetryHandler); ok {
+		h.EpochRetry(f.createContext(), f.epoch, f.epochAttempts)
+	}
+	f.setEpochStarted()
+}
+
+// skipEpoch moves the job on to the next epoch. Every task asks for it, but
+// only the first one succeeds, so failed compares are expected.
+func (f *framework) skipEpoch(epoch uint64) {
+	f.log.Printf("task %d skips epoch %d", f.taskID, epoch)
+	f.casEpochAsync(epoch, f.nextEpoch(epoch), func(newEpoch uint64, err error) {
+		if err != nil && err != meritop.ErrCompareFailed {
+			f.log.Printf("task %d failed to skip epoch %d: %v", f.taskID, epoch, err)
+		}
+	})
 }
 
 func (f *framework) releaseEpochResource() {
@@ -163,12 +760,22 @@ func (f *framework) releaseEpochResource() {
 		c <- true
 	}
 	f.metaStops = nil
+	f.stopCommit()
+	f.endBarrierScope()
 }
 
 // release resources: heartbeat, epoch watch.
 func (f *framework) releaseResource() {
 	f.log.Printf("framework of task %d is releasing resources...\n", f.taskID)
 	f.epochStop <- true
+	localTasks.Delete(f.taskKey(f.taskID))
+	f.endBarrierScope()
+	f.deleteBarrierKeys(f.oldBarrierKeys)
+	if f.registered {
+		if err := f.backend.Deregister(gocontext.TODO(), f.taskKey(f.taskID)); err != nil {
+			f.log.Printf("task %d failed to deregister: %v", f.taskID, err)
+		}
+	}
 	close(f.heartbeatStop)
 	f.stopHTTP()
 }
@@ -176,12 +783,13 @@ func (f *framework) releaseResource() {
 // occupyTask will grab the first unassigned task and register itself on etcd.
 func (f *framework) occupyTask() error {
 	for {
-		freeTask, err := etcdutil.WaitFreeTask(f.etcdClient, f.name, f.log)
+		freeTask, err := etcdutil.WaitFreeTask(f.watchClient, f.jobPath(), f.random(), f.log)
 		if err != nil {
 			return err
 		}
 		f.log.Printf("standby got failure at task %d", freeTask)
-		ok := etcdutil.TryOccupyTask(f.etcdClient, f.name, freeTask, f.ln.Addr().String())
+		time.Sleep(f.initDelay(freeTask))
+		ok := etcdutil.TryOccupyTask(f.etcdClient, f.jobPath(), freeTask, f.taskKey(freeTask), f.ln.Addr().String(), f.heartbeatInterval())
 		if ok {
 			f.taskID = freeTask
 			return nil
@@ -190,63 +798,157 @@ func (f *framework) occupyTask() error {
 	}
 }
 
+// initDelay tells how long to wait before registering for the given task, so
+// that tasks register one after another in the worker init order.
+func (f *framework) initDelay(taskID uint64) time.Duration {
+	if f.workerInitOrder == nil {
+		return 0
+	}
+	if f.initOrder == nil {
+		ids, err := etcdutil.ListTaskIDs(f.etcdClient, f.jobPath())
+		if err != nil {
+			f.log.Printf("ListTaskIDs failed, registering without delay: %v", err)
+			return 0
+		}
+		f.initOrder = make(map[uint64]int)
+		for i, id := range f.workerInitOrder(uint64(len(ids))) {
+			f.initOrder[id] = i
+		}
+	}
+	return time.Duration(f.initOrder[taskID]) * initOrderStep
+}
+
+// SequentialInitOrder is a worker init order that has tasks register by
+// their ID, i.e. task i after i*10ms.
+func SequentialInitOrder(numTasks uint64) []uint64 {
+	order := make([]uint64, numTasks)
+	for i := range order {
+		order[i] = uint64(i)
+	}
+	return order
+}
+
 func (f *framework) watchMeta(who taskRole, taskIDs []uint64) {
 	stops := make([]chan bool, len(taskIDs))
 
+	// Each watch takes round trips to etcd, so with many tasks to watch up
+	// to metaFlagConcurrency are set up at once.
+	var slots chan struct{}
+	if f.metaFlagConcurrency > 1 {
+		slots = make(chan struct{}, f.metaFlagConcurrency)
+	}
+	var wg sync.WaitGroup
 	for i, taskID := range taskIDs {
 		stop := make(chan bool, 1)
 		stops[i] = stop
-
-		var watchPath string
-		switch who {
-		case roleParent:
-			// Watch parent's child-meta.
-			watchPath = etcdutil.ChildMetaPath(f.name, taskID)
-		case roleChild:
-			// Watch child's parent-meta.
-			watchPath = etcdutil.ParentMetaPath(f.name, taskID)
-		default:
-			f.log.Panic("unexpected role")
+		if slots == nil {
+			f.watchMetaOf(who, taskID, stop)
+			continue
 		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(taskID uint64) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			f.watchMetaOf(who, taskID, stop)
+		}(taskID)
+	}
+	wg.Wait()
+	f.metaStops = append(f.metaStops, stops...)
+}
 
-		// When a node working for a task crashed, a new node will take over
-		// the task and continue what's left. It assumes that progress is stalled
-		// until the new node comes (i.e. epoch won't change).
-
-		responseHandler := func(resp *etcd.Response, taskID uint64) {
-			if resp.Action != "set" && resp.Action != "get" {
-				return
-			}
-			// epoch is prepended to meta. When a new one starts and replaces
-			// the old one, it doesn't need to handle previous things, whose
-			// epoch is smaller than current one.
-			values := strings.SplitN(resp.Node.Value, "-", 2)
-			ep, err := strconv.ParseUint(values[0], 10, 64)
-			if err != nil {
-				f.log.Panicf("WARN: not a unit64 prepended to meta: %s", values[0])
-			}
-			f.metaChan <- &metaChange{
-				from:  taskID,
-				who:   who,
-				epoch: ep,
-				meta:  values[1],
-			}
-		}
+// watchMetaOf passes the meta flags of the given task on to the event loop
+// until stop is signaled.
+func (f *framework) watchMetaOf(who taskRole, taskID uint64, stop chan bool) {
+	var watchPath string
+	switch who {
+	case roleParent:
+		// Watch parent's child-meta.
+		watchPath = etcdutil.ChildMetaPath(f.jobPath(), taskID)
+	case roleChild:
+		// Watch child's parent-meta.
+		watchPath = etcdutil.ParentMetaPath(f.jobPath(), taskID)
+	default:
+		f.log.Panic("unexpected role")
+	}
 
-		// Need to pass in taskID to make it work. Didn't know why.
-		err := etcdutil.WatchMeta(f.etcdClient, taskID, watchPath, stop, responseHandler)
+	// When a node working for a task crashed, a new node will take over
+	// the task and continue what's left. It assumes that progress is stalled
+	// until the new node comes (i.e. epoch won't change).
+	err := f.watchMetaKey(watchPath, stop, func(value string) {
+		// epoch is prepended to meta. When a new one starts and replaces
+		// the old one, it doesn't need to handle previous things, whose
+		// epoch is smaller than current one.
+		values := strings.SplitN(value, "-", 2)
+		ep, err := strconv.ParseUint(values[0], 10, 64)
 		if err != nil {
-			f.log.Panicf("WatchMeta failed. path: %s, err: %v", watchPath, err)
+			f.log.Panicf("WARN: not a unit64 prepended to meta: %s", values[0])
+		}
+		atomic.AddInt64(&f.pendingMeta, 1)
+		f.metaChan <- &metaChange{
+			from:  taskID,
+			who:   who,
+			epoch: ep,
+			meta:  values[1],
 		}
+	})
+	if err != nil {
+		f.log.Panicf("WatchMeta failed. path: %s, err: %v", watchPath, err)
 	}
-	f.metaStops = append(f.metaStops, stops...)
+}
+
+// watchMetaKey handles the current meta under key, if any, and every later
+// one until stop is signaled.
+func (f *framework) watchMetaKey(key string, stop chan bool, handle func(value string)) error {
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	// Watch before get, so nothing is missed in between.
+	events, err := f.metaStore.Watch(ctx, key)
+	if err != nil {
+		cancel()
+		return err
+	}
+	value, err := f.metaStore.Get(ctx, key)
+	if err != nil && err != meritop.ErrKeyNotFound {
+		cancel()
+		return err
+	}
+	if value != "" {
+		handle(value)
+	}
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		// A change made between watch and get shows up in both. Don't handle it twice.
+		first := true
+		for ev := range events {
+			if ev.Type != meritop.KVPut || ev.Key != key {
+				continue
+			}
+			if first && value != "" && ev.Value == value {
+				first = false
+				continue
+			}
+			first = false
+			handle(ev.Value)
+		}
+	}()
+	return nil
 }
 
 func (f *framework) handleMetaChange(ctx meritop.Context, who taskRole, taskID uint64, meta string) {
+	defer atomic.AddInt64(&f.pendingMeta, -1)
 	switch who {
 	case roleParent:
-		f.task.ParentMetaReady(ctx, taskID, meta)
+		if !f.injectedFault("ParentMetaReady", ctx.GetEpoch()) {
+			f.task.ParentMetaReady(ctx, taskID, meta)
+		}
 	case roleChild:
-		f.task.ChildMetaReady(ctx, taskID, meta)
+		if !f.injectedFault("ChildMetaReady", ctx.GetEpoch()) {
+			f.task.ChildMetaReady(ctx, taskID, meta)
+		}
 	}
 }
@@ -0,0 +1,170 @@
+package framework
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-distributed/meritop"
+)
+
+// CheckpointPath returns where the checkpoint of a task at the given epoch is
+// stored under dir: {dir}/{taskID}_epoch{epoch}.bin
+func CheckpointPath(dir string, taskID, epoch uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%d_epoch%d.bin", taskID, epoch))
+}
+
+// NewDirCheckpointStore returns a checkpoint store keeping checkpoints as
+// files in dir, laid out as CheckpointPath tells.
+func NewDirCheckpointStore(dir string) meritop.CheckpointStore {
+	return dirCheckpointStore(dir)
+}
+
+type dirCheckpointStore string
+
+func (d dirCheckpointStore) Save(taskID, epoch uint64, data []byte) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(CheckpointPath(string(d), taskID, epoch), data, 0644)
+}
+
+func (d dirCheckpointStore) Load(taskID, epoch uint64) (uint64, []byte, error) {
+	files, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil, meritop.ErrNoCheckpoint
+		}
+		return 0, nil, err
+	}
+	prefix := fmt.Sprintf("%d_epoch", taskID)
+	found := false
+	var last uint64
+	for _, fi := range files {
+		name := fi.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bin") {
+			continue
+		}
+		e, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".bin"), 10, 64)
+		if err != nil || e > epoch {
+			continue
+		}
+		if !found || e > last {
+			found, last = true, e
+		}
+	}
+	if !found {
+		return 0, nil, meritop.ErrNoCheckpoint
+	}
+	data, err := ioutil.ReadFile(CheckpointPath(string(d), taskID, last))
+	return last, data, err
+}
+
+func (f *framework) SetCheckpointStore(store meritop.CheckpointStore) { f.checkpointStore = store }
+
+func (f *framework) SetCheckpointInterval(n uint64) { f.checkpointInterval = n }
+
+func (f *framework) GetCheckpointInterval() uint64 { return f.checkpointInterval }
+
+// saveCheckpoint stores the checkpoint of the task at the given epoch.
+func (f *framework) saveCheckpoint(store meritop.CheckpointStore, epoch uint64) error {
+	c, ok := f.task.(meritop.Checkpointable)
+	if !ok {
+		return fmt.Errorf("task is not checkpointable")
+	}
+	data, err := c.Checkpoint(epoch)
+	if err != nil {
+		return err
+	}
+	return store.Save(f.taskID, epoch, data)
+}
+
+// checkpointEpoch saves the checkpoint of a checkpointable task once it is
+// done with an epoch that is a multiple of the checkpoint interval.
+func (f *framework) checkpointEpoch(epoch uint64) {
+	if f.checkpointStore == nil || f.checkpointInterval == 0 || epoch%f.checkpointInterval != 0 {
+		return
+	}
+	if _, ok := f.task.(meritop.Checkpointable); !ok {
+		return
+	}
+	if err := f.saveCheckpoint(f.checkpointStore, epoch); err != nil {
+		f.log.Printf("task %d failed to checkpoint epoch %d: %v", f.taskID, epoch, err)
+		atomic.AddUint64(&f.errorCount, 1)
+	}
+}
+
+func (f *framework) ReplayFromCheckpoint(dir string, epoch uint64) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("checkpoint path %s is not a directory", dir)
+	}
+	f.replayDir = dir
+	f.replayEpoch = epoch
+	return nil
+}
+
+// moveToReplayEpoch moves a freshly laid out job from epoch 0 to the epoch
+// to replay from. Only the first task succeeds; the others will find the job
+// there already.
+func (f *framework) moveToReplayEpoch() {
+	err := f.casEpoch(0, f.replayEpoch)
+	if err == nil {
+		f.log.Printf("task %d moved job to epoch %d for replay", f.taskID, f.replayEpoch)
+		return
+	}
+	if err != meritop.ErrCompareFailed {
+		f.log.Fatalf("task %d Epoch CompareAndSwap(0, %d) failed: %v", f.taskID, f.replayEpoch, err)
+	}
+}
+
+// restoreFromCheckpoint hands the task its checkpoint if the job is at the
+// replayed epoch, or else its last one in the checkpoint store, if the task
+// knows how to restore itself.
+func (f *framework) restoreFromCheckpoint() {
+	c, ok := f.task.(meritop.Checkpointable)
+	if !ok {
+		return
+	}
+	if f.replayDir == "" {
+		f.restoreFromStore(c)
+		return
+	}
+	if f.epoch != f.replayEpoch {
+		return
+	}
+	data, err := ioutil.ReadFile(CheckpointPath(f.replayDir, f.taskID, f.epoch))
+	if err != nil {
+		f.log.Fatalf("task %d reading checkpoint failed: %v", f.taskID, err)
+	}
+	if err := c.Restore(f.epoch, data); err != nil {
+		f.log.Fatalf("task %d Restore(%d) failed: %v", f.taskID, f.epoch, err)
+	}
+	f.log.Printf("task %d restored from checkpoint at epoch %d", f.taskID, f.epoch)
+}
+
+func (f *framework) restoreFromStore(c meritop.Checkpointable) {
+	if f.checkpointStore == nil {
+		return
+	}
+	epoch, data, err := f.checkpointStore.Load(f.taskID, f.epoch)
+	if err == meritop.ErrNoCheckpoint {
+		return
+	}
+	if err != nil {
+		f.log.Printf("task %d loading checkpoint failed: %v", f.taskID, err)
+		atomic.AddUint64(&f.errorCount, 1)
+		return
+	}
+	if err := c.Restore(epoch, data); err != nil {
+		f.log.Fatalf("task %d Restore(%d) failed: %v", f.taskID, epoch, err)
+	}
+	f.log.Printf("task %d restored from checkpoint at epoch %d", f.taskID, epoch)
+}
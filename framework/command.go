@@ -0,0 +1,62 @@
+package framework
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+func (f *framework) SendCommandToTask(taskID uint64, cmd meritop.Command) error {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	return f.metaStore.Put(gocontext.TODO(), etcdutil.TaskCommandPath(f.jobPath(), taskID), string(b))
+}
+
+// watchCommands passes commands sent to this task from now on to the event
+// loop until stop.
+func (f *framework) watchCommands(stop chan struct{}) error {
+	key := etcdutil.TaskCommandPath(f.jobPath(), f.taskID)
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	events, err := f.metaStore.Watch(ctx, key)
+	if err != nil {
+		cancel()
+		return err
+	}
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		for ev := range events {
+			if ev.Type != meritop.KVPut || ev.Key != key {
+				continue
+			}
+			var cmd meritop.Command
+			if err := json.Unmarshal([]byte(ev.Value), &cmd); err != nil {
+				f.log.Printf("WARN: malformed command: %s", ev.Value)
+				continue
+			}
+			f.commandChan <- cmd
+		}
+	}()
+	return nil
+}
+
+// handleCommand hands a command to the task. It must be called from the
+// event loop.
+func (f *framework) handleCommand(cmd meritop.Command) {
+	h, ok := f.task.(meritop.CommandHandler)
+	if !ok {
+		f.log.Printf("task %d ignored command %s", f.taskID, cmd.Type)
+		return
+	}
+	if err := h.HandleCommand(f.createContext(), cmd); err != nil {
+		f.log.Printf("task %d command %s failed: %v", f.taskID, cmd.Type, err)
+		atomic.AddUint64(&f.errorCount, 1)
+	}
+}
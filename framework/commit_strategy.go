@@ -0,0 +1,105 @@
+package framework
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+// ManualCommit leaves it to the task to call Context.IncEpoch. It's the
+// default.
+var ManualCommit meritop.CommitStrategy = manualCommit{}
+
+type manualCommit struct{}
+
+func (manualCommit) Ready(responded, numChildren int) bool { return false }
+
+func (manualCommit) Timeout() time.Duration { return 0 }
+
+// AutoCommitAfterQuorum commits the epoch once the fraction q of children
+// responded, e.g. 0.8 to move on without the slowest fifth of them.
+func AutoCommitAfterQuorum(q float64) meritop.CommitStrategy { return quorumCommit(q) }
+
+type quorumCommit float64
+
+func (q quorumCommit) Ready(responded, numChildren int) bool {
+	return responded >= int(math.Ceil(float64(q)*float64(numChildren)))
+}
+
+func (quorumCommit) Timeout() time.Duration { return 0 }
+
+// AutoCommitAfterTimeout commits the epoch d after it started, however many
+// children responded by then.
+func AutoCommitAfterTimeout(d time.Duration) meritop.CommitStrategy { return timeoutCommit(d) }
+
+type timeoutCommit time.Duration
+
+func (timeoutCommit) Ready(responded, numChildren int) bool { return false }
+
+func (d timeoutCommit) Timeout() time.Duration { return time.Duration(d) }
+
+// commitTimeout tells the event loop that the commit of the given attempt of
+// an epoch timed out.
+type commitTimeout struct {
+	epoch, attempt uint64
+}
+
+// startCommit arms the commit strategy for the epoch just started. It must
+// be called from the event loop.
+func (f *framework) startCommit(epoch uint64) {
+	if f.commitStrategy == nil || len(f.loadTopology().GetParents(epoch)) != 0 {
+		return
+	}
+	f.committedEpoch = exitEpoch
+	if d := f.commitStrategy.Timeout(); d > 0 {
+		f.stopCommit()
+		c := commitTimeout{epoch, atomic.LoadUint64(&f.attempt)}
+		f.commitTimer = time.AfterFunc(d, func() {
+			select {
+			case f.commitChan <- c:
+			default: // the event loop is gone or far behind
+			}
+		})
+	}
+	f.commitIfReady(epoch)
+}
+
+// stopCommit stops the commit timeout of the epoch, if any, once it's
+// aborted, retried or over. It must be called from the event loop.
+func (f *framework) stopCommit() {
+	if f.commitTimer != nil {
+		f.commitTimer.Stop()
+		f.commitTimer = nil
+	}
+}
+
+// commitIfReady commits the epoch if the commit strategy says enough children
+// responded. It must be called from the event loop.
+func (f *framework) commitIfReady(epoch uint64) {
+	if f.commitStrategy == nil || len(f.loadTopology().GetParents(epoch)) != 0 {
+		return
+	}
+	f.childMu.Lock()
+	responded := len(f.childResponses[epoch])
+	f.childMu.Unlock()
+	if f.commitStrategy.Ready(responded, len(f.loadTopology().GetChildren(epoch))) {
+		f.commitEpoch(epoch)
+	}
+}
+
+// commitEpoch moves the job on from the current epoch, once at most. It must
+// be called from the event loop.
+func (f *framework) commitEpoch(epoch uint64) {
+	if epoch != f.epoch || f.committedEpoch == epoch {
+		return
+	}
+	f.committedEpoch = epoch
+	f.log.Printf("task %d commits epoch %d", f.taskID, epoch)
+	f.incEpochAsync(epoch, func(newEpoch uint64, err error) {
+		if err != nil && err != meritop.ErrCompareFailed {
+			f.log.Printf("task %d failed to commit epoch %d: %v", f.taskID, epoch, err)
+		}
+	})
+}
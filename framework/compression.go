@@ -0,0 +1,65 @@
+package framework
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+// Data responses and pushes start with one of these bytes, telling how the
+// rest of the payload is encoded.
+const (
+	payloadRaw  byte = 0
+	payloadGzip byte = 1
+)
+
+const defaultCompressionThreshold = 4096
+
+// encodePayload compresses data if compression is enabled and data is at
+// least the compression threshold, as compressing smaller data might well
+// make it larger.
+func (f *framework) encodePayload(data []byte) []byte {
+	if !f.compression || len(data) < f.compressionThresholdOrDefault() {
+		return append([]byte{payloadRaw}, data...)
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(payloadGzip)
+	w := gzip.NewWriter(&buf)
+	// Writing to a bytes.Buffer doesn't fail.
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// decodePayload returns the data encoded by encodePayload, whether
+// compressed or not.
+func decodePayload(p []byte) ([]byte, error) {
+	if len(p) == 0 {
+		return nil, fmt.Errorf("empty payload")
+	}
+	switch p[0] {
+	case payloadRaw:
+		return p[1:], nil
+	case payloadGzip:
+		r, err := gzip.NewReader(bytes.NewReader(p[1:]))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unknown payload encoding %d", p[0])
+	}
+}
+
+func (f *framework) SetCompression(enabled bool) { f.compression = enabled }
+
+func (f *framework) SetCompressionThreshold(bytes int) { f.compressionThreshold = bytes }
+
+func (f *framework) compressionThresholdOrDefault() int {
+	if f.compressionThreshold <= 0 {
+		return defaultCompressionThreshold
+	}
+	return f.compressionThreshold
+}
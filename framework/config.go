@@ -0,0 +1,42 @@
+package framework
+
+import (
+	"os"
+	"strings"
+
+	"github.com/go-distributed/meritop"
+)
+
+// configFromEnv collects the environment variables named {prefix}{KEY},
+// given as "name=value", by their lowercased KEY.
+func configFromEnv(prefix string, environ []string) map[string]string {
+	config := make(map[string]string)
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, prefix) {
+			continue
+		}
+		kv = strings.TrimPrefix(kv, prefix)
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			continue
+		}
+		config[strings.ToLower(kv[:i])] = kv[i+1:]
+	}
+	return config
+}
+
+func (f *framework) applyEnvConfig() {
+	config := configFromEnv(f.envConfigPrefix, os.Environ())
+	b, ok := f.taskBuilder.(meritop.ConfigurableTaskBuilder)
+	if !ok {
+		f.log.Printf("task builder doesn't take configuration, ignoring %d environment variables with prefix %s",
+			len(config), f.envConfigPrefix)
+		return
+	}
+	unknown := b.SetConfig(config)
+	if f.strictEnvConfig {
+		for _, key := range unknown {
+			f.log.Printf("WARN: unknown config key %q loaded from environment", key)
+		}
+	}
+}
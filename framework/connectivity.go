@@ -0,0 +1,77 @@
+package framework
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+)
+
+// how long to wait before pinging a task again that couldn't be reached,
+// e.g. because it hasn't registered yet.
+var pingRetryInterval = 100 * time.Millisecond
+
+func (f *framework) EnsureTopologyConnected(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var (
+		mu          sync.Mutex
+		unreachable []uint64
+		wg          sync.WaitGroup
+	)
+	for _, id := range f.loadTopology().GetAncestors(f.taskID, f.loadEpoch()) {
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+			if err := f.pingUntil(id, deadline); err != nil {
+				f.log.Printf("task %d can't reach task %d: %v", f.taskID, id, err)
+				mu.Lock()
+				unreachable = append(unreachable, id)
+				mu.Unlock()
+			}
+		}(id)
+	}
+	wg.Wait()
+	if len(unreachable) > 0 {
+		sort.Sort(uint64Slice(unreachable))
+		return &meritop.ConnectivityError{Unreachable: unreachable}
+	}
+	return nil
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// pingUntil pings the task until it answers or the deadline passes.
+func (f *framework) pingUntil(taskID uint64, deadline time.Time) error {
+	for {
+		err := f.ping(taskID, deadline.Sub(time.Now()))
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(pingRetryInterval).After(deadline) {
+			return err
+		}
+		time.Sleep(pingRetryInterval)
+	}
+}
+
+func (f *framework) ping(taskID uint64, timeout time.Duration) error {
+	addr, err := f.GetPeerAddress(taskID)
+	if err != nil {
+		return err
+	}
+	id, err := frameworkhttp.Ping(f.rpcClient, addr, timeout)
+	if err != nil {
+		return err
+	}
+	if id != taskID {
+		return fmt.Errorf("task %d answered at its address", id)
+	}
+	return nil
+}
@@ -1,14 +1,28 @@
 package framework
 
+import (
+	"math/rand"
+	"time"
+)
+
 type context struct {
-	epoch uint64
-	f     *framework
+	epoch          uint64
+	quorumMet      bool
+	epochStartTime time.Time
+	f              *framework
 }
 
 func (f *framework) createContext() *context {
+	return f.createContextAt(f.epoch)
+}
+
+// createContextAt creates a context for work of the given active epoch.
+func (f *framework) createContextAt(epoch uint64) *context {
 	return &context{
-		epoch: f.epoch,
-		f:     f,
+		epoch:          epoch,
+		quorumMet:      f.quorumMet(epoch),
+		epochStartTime: f.epochStartTime,
+		f:              f,
 	}
 }
 
@@ -24,6 +38,71 @@ func (c *context) IncEpoch() {
 	c.f.incEpoch(c.epoch)
 }
 
+func (c *context) GetEpoch() uint64 { return c.epoch }
+
+func (c *context) IncEpochAsync(callback func(newEpoch uint64, err error)) {
+	c.f.incEpochAsync(c.epoch, callback)
+}
+
 func (c *context) DataRequest(toID uint64, req string) {
-	c.f.dataRequest(toID, req, c.epoch)
+	if c.f.dataRequestPolicy == nil {
+		c.f.dataRequest(toID, req, c.epoch)
+		return
+	}
+	c.f.dataRequestPolicy.Execute(c, toID, req, func(toID uint64, req string) {
+		c.f.dataRequest(toID, req, c.epoch)
+	})
+}
+
+func (c *context) BulkDataRequest(peers []uint64, req string) {
+	if c.f.dataRequestPolicy == nil {
+		c.f.bulkDataRequest(peers, req, c.epoch)
+		return
+	}
+	for _, id := range peers {
+		c.DataRequest(id, req)
+	}
+}
+
+func (c *context) FlushDataRequests() {
+	if c.f.dataRequestPolicy != nil {
+		c.f.dataRequestPolicy.Flush(c)
+	}
+}
+
+func (c *context) AbortEpoch(reason string) {
+	c.f.abortEpoch(c.epoch, reason)
+}
+
+func (c *context) PushDataToParent(data []byte) {
+	c.f.pushDataToParent(data, c.epoch)
+}
+
+func (c *context) IsQuorumMet() bool { return c.quorumMet }
+
+func (c *context) GetCurrentEpochStartTime() time.Time { return c.epochStartTime }
+
+func (c *context) SetChildWeight(childID uint64, weight float64) {
+	c.f.setChildWeight(c.epoch, childID, weight)
+}
+
+func (c *context) GetChildWeight(childID uint64) float64 {
+	return c.f.childWeight(c.epoch, childID)
+}
+
+func (c *context) GetRand() *rand.Rand { return c.f.random() }
+
+func (c *context) ReachBarrier(group string) error { return c.f.reachBarrier(c, group) }
+
+func (c *context) ApplyGradient(param, gradient []byte) []byte {
+	if c.f.paramUpdate == nil {
+		return param
+	}
+	return c.f.paramUpdate(param, gradient)
+}
+
+func (c *context) IsWarmup() bool { return c.epoch < c.f.warmupEpochs }
+
+func (c *context) EmitMetric(name string, value float64, labels map[string]string) {
+	c.f.emitUserMetric(name, value, labels)
 }
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package framework
+
+import "time"
+
+// processCPUTime isn't supported on this platform.
+func processCPUTime() (time.Duration, bool) { return 0, false }
@@ -0,0 +1,32 @@
+package framework
+
+import (
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
+)
+
+// RotateEtcdCredentials tries the new credentials on a client of its own
+// before handing them to the clients in use. go-etcd authenticates every
+// request anew, so requests in flight, e.g. watches, carry on with the old
+// credentials while the next ones go out with the new.
+func (f *framework) RotateEtcdCredentials(creds meritop.Credentials) error {
+	probe := etcd.NewClient(f.etcdURLs)
+	probe.SetDialTimeout(f.dialTimeout())
+	probe.SetCredentials(creds.Username, creds.Password)
+	if _, err := probe.Get("/", false, false); err != nil {
+		if isUnauthorized(err) {
+			return meritop.ErrAuthenticationFailed
+		}
+		return err
+	}
+
+	f.credMu.Lock()
+	defer f.credMu.Unlock()
+	f.etcdUsername, f.etcdPassword = creds.Username, creds.Password
+	f.etcdClient.SetCredentials(creds.Username, creds.Password)
+	if f.watchClient != f.etcdClient {
+		f.watchClient.SetCredentials(creds.Username, creds.Password)
+	}
+	f.log.Printf("task %d rotated etcd credentials to user %s", f.taskID, creds.Username)
+	return nil
+}
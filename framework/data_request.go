@@ -1,40 +1,171 @@
 package framework
 
 import (
+	gocontext "context"
+	"crypto/tls"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-distributed/meritop"
 	"github.com/go-distributed/meritop/framework/frameworkhttp"
-	"github.com/go-distributed/meritop/pkg/etcdutil"
 	"github.com/go-distributed/meritop/pkg/topoutil"
 )
 
 func (f *framework) sendRequest(dr *dataRequest) {
-	addr, err := etcdutil.GetAddress(f.etcdClient, f.name, dr.taskID)
+	atomic.AddInt64(&f.pendingRequests, 1)
+	defer atomic.AddInt64(&f.pendingRequests, -1)
+	if peer := f.localPeer(dr.taskID); peer != nil {
+		f.sendLocalRequest(peer, dr)
+		return
+	}
+	addr, err := f.GetPeerAddress(dr.taskID)
 	if err != nil {
 		// TODO: We should handle network faults later by retrying
 		f.log.Fatalf("getAddress(%d) failed: %v", dr.taskID, err)
 		return
 	}
-	d, err := frameworkhttp.RequestData(addr, dr.req, f.taskID, dr.taskID, dr.epoch, f.log)
+	f.traceBuf.record(meritop.TraceRequestIssued, dr.taskID, dr.epoch, dr.req, nil)
+	f.sending(dr.taskID, len(dr.req))
+	start := time.Now()
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), f.rpcTimeoutOrDefault())
+	defer cancel()
+	// The payload has one byte telling its encoding in front of the data.
+	d, err := frameworkhttp.RequestData(ctx, f.rpcClient, addr, dr.req, f.taskID, dr.taskID, dr.epoch, f.maxMessageSizeOrDefault()+1, f.log)
 	if err != nil {
-		if err == frameworkhttp.ErrReqEpochMismatch {
-			f.log.Printf("task %d got epoch mismatch error from server", f.taskID)
-			return
-		}
-		f.log.Printf("task %d RequestData failed: %v", f.taskID, err)
+		f.requestFailed(dr, rpcError(ctx, dr.taskID, start, err))
+		return
+	}
+	f.responseReceived(dr, d, time.Since(start))
+}
+
+// responseReceived passes the response to a request on to the event loop.
+func (f *framework) responseReceived(dr *dataRequest, d *frameworkhttp.DataResponse, latency time.Duration) {
+	f.bandwidth.received(time.Now(), dr.taskID, len(d.Data))
+	var err error
+	if d.Data, err = decodePayload(d.Data); err != nil {
+		f.requestFailed(dr, err)
 		return
 	}
+	if d.Data = f.intercept(dr.taskID, dr.epoch, d.Data); d.Data == nil {
+		f.log.Printf("task %d: response to %q from task %d dropped by interceptor", f.taskID, dr.req, dr.taskID)
+		f.dedup.abort(requestKey{dr.taskID, dr.epoch, dr.req})
+		return
+	}
+	if !f.validateChildResponse(dr, d.Data) {
+		return
+	}
+	d.Attempt = dr.attempt
+	f.auditData(meritop.AuditDataReceived, dr.epoch, dr.taskID, d.Data)
+	f.latencies.record(dr.taskID, latency)
+	f.traceBuf.record(meritop.TraceResponseReceived, dr.taskID, dr.epoch, dr.req, nil)
 	f.dataRespChan <- d
 }
 
+// intercept passes data received from the given task to the message
+// interceptor, if any, and returns what it made of it: nil to drop it.
+func (f *framework) intercept(from, epoch uint64, data []byte) []byte {
+	if f.messageInterceptor == nil {
+		return data
+	}
+	return f.messageInterceptor(from, f.taskID, epoch, data)
+}
+
+// validateChildResponse checks the data a child responded with, if there is
+// a child response validator. A rejected request is sent again up to
+// maxResponseRetries times, and then fails.
+func (f *framework) validateChildResponse(dr *dataRequest, data []byte) bool {
+	if f.childResponseValidator == nil || !topoutil.IsChild(f.loadTopology(), dr.epoch, dr.taskID) {
+		return true
+	}
+	k := requestKey{dr.taskID, dr.epoch, dr.req}
+	err := f.childResponseValidator(dr.taskID, dr.epoch, data)
+	f.responseRetryMu.Lock()
+	retries := f.responseRetries[k]
+	if err == nil || retries >= f.maxResponseRetries {
+		delete(f.responseRetries, k)
+	} else {
+		if f.responseRetries == nil {
+			f.responseRetries = make(map[requestKey]int)
+		}
+		f.responseRetries[k]++
+	}
+	f.responseRetryMu.Unlock()
+	if err == nil {
+		return true
+	}
+
+	f.log.Printf("task %d: response to %q from child %d rejected: %v", f.taskID, dr.req, dr.taskID, err)
+	atomic.AddUint64(&f.errorCount, 1)
+	f.dedup.abort(k)
+	if retries < f.maxResponseRetries {
+		f.dataRequest(dr.taskID, dr.req, dr.epoch)
+	} else {
+		f.dataFailChan <- &dataFailure{taskID: dr.taskID, epoch: dr.epoch, req: dr.req, err: err}
+	}
+	return false
+}
+
+// rpcError tells a request that failed as its ctx timed out by an
+// *RPCTimeoutError.
+func rpcError(ctx gocontext.Context, peerID uint64, start time.Time, err error) error {
+	if ctx.Err() == gocontext.DeadlineExceeded {
+		return &meritop.RPCTimeoutError{PeerID: peerID, Elapsed: time.Since(start)}
+	}
+	return err
+}
+
+func (f *framework) requestFailed(dr *dataRequest, err error) {
+	f.requestsFailed([]*dataRequest{dr}, err)
+}
+
+// requestsFailed handles requests to the same task that failed at once, e.g.
+// a batch. The failure is logged and counted once, but the task is told
+// about each request, so that it can retry them.
+func (f *framework) requestsFailed(drs []*dataRequest, err error) {
+	for _, dr := range drs {
+		f.dedup.abort(requestKey{dr.taskID, dr.epoch, dr.req})
+		f.traceBuf.record(meritop.TraceError, dr.taskID, dr.epoch, dr.req, err)
+	}
+	dr := drs[0]
+	if err == frameworkhttp.ErrReqEpochMismatch {
+		f.log.Printf("task %d got epoch mismatch error from server", f.taskID)
+		return
+	}
+	if err == frameworkhttp.ErrMessageTooLarge {
+		f.log.Printf("task %d: response from task %d exceeds max message size %d",
+			f.taskID, dr.taskID, f.maxMessageSizeOrDefault())
+		atomic.AddUint64(&f.errorCount, 1)
+		f.notifyFailed(drs, err)
+		return
+	}
+	if _, ok := err.(*meritop.RPCTimeoutError); ok {
+		f.log.Printf("task %d request %q: %v", f.taskID, dr.req, err)
+		atomic.AddUint64(&f.errorCount, 1)
+		f.notifyFailed(drs, err)
+		return
+	}
+	f.log.Printf("task %d RequestData failed: %v", f.taskID, err)
+	atomic.AddUint64(&f.errorCount, 1)
+}
+
+func (f *framework) notifyFailed(drs []*dataRequest, err error) {
+	for _, dr := range drs {
+		f.dataFailChan <- &dataFailure{taskID: dr.taskID, epoch: dr.epoch, req: dr.req, err: err}
+	}
+}
+
 func (f *framework) GetTaskData(taskID, epoch uint64, req string) ([]byte, error) {
+	atomic.AddInt64(&f.pendingIncoming, 1)
+	defer atomic.AddInt64(&f.pendingIncoming, -1)
 	dataChan := make(chan []byte, 1)
+	errChan := make(chan error, 1)
 	f.dataReqChan <- &dataRequest{
 		taskID:   taskID,
 		epoch:    epoch,
 		req:      req,
 		dataChan: dataChan,
+		errChan:  errChan,
 	}
 
 	select {
@@ -44,6 +175,8 @@ func (f *framework) GetTaskData(taskID, epoch uint64, req string) ([]byte, error
 			return nil, frameworkhttp.ErrReqEpochMismatch
 		}
 		return d, nil
+	case err := <-errChan:
+		return nil, err
 	case <-f.httpStop:
 		// If a node stopped running and there is remaining requests, we need to
 		// respond error message back. It is used to let client routines stop blocking --
@@ -55,6 +188,62 @@ func (f *framework) GetTaskData(taskID, epoch uint64, req string) ([]byte, error
 	}
 }
 
+func (f *framework) sendPush(dp *dataPush) {
+	addr, err := f.GetPeerAddress(dp.taskID)
+	if err != nil {
+		// TODO: We should handle network faults later by retrying
+		f.log.Fatalf("getAddress(%d) failed: %v", dp.taskID, err)
+		return
+	}
+	payload := f.encodePayload(dp.data)
+	f.sending(dp.taskID, len(payload))
+	if err := frameworkhttp.PushData(f.rpcClient, addr, f.taskID, dp.epoch, payload); err != nil {
+		if err == frameworkhttp.ErrReqEpochMismatch {
+			f.log.Printf("task %d got epoch mismatch error from server", f.taskID)
+			return
+		}
+		if err == frameworkhttp.ErrMessageTooLarge {
+			f.log.Printf("task %d: data pushed to task %d exceeds its max message size",
+				f.taskID, dp.taskID)
+			atomic.AddUint64(&f.errorCount, 1)
+			return
+		}
+		f.log.Printf("task %d PushData failed: %v", f.taskID, err)
+		atomic.AddUint64(&f.errorCount, 1)
+	}
+}
+
+// PushTaskData hands data pushed by another task to the event loop, which
+// checks the epoch before passing it on to the task.
+func (f *framework) PushTaskData(taskID, epoch uint64, payload []byte) error {
+	f.recvLimit.wait(len(payload))
+	f.bandwidth.received(time.Now(), taskID, len(payload))
+	data, err := decodePayload(payload)
+	if err != nil {
+		f.log.Printf("task %d got bad push from task %d: %v", f.taskID, taskID, err)
+		atomic.AddUint64(&f.errorCount, 1)
+		return err
+	}
+	if data = f.intercept(taskID, epoch, data); data == nil {
+		f.log.Printf("task %d: push from task %d dropped by interceptor", f.taskID, taskID)
+		return nil
+	}
+	f.auditData(meritop.AuditPushReceived, epoch, taskID, data)
+	errChan := make(chan error, 1)
+	f.dataPushChan <- &dataPush{
+		taskID:  taskID,
+		epoch:   epoch,
+		data:    data,
+		errChan: errChan,
+	}
+	select {
+	case err := <-errChan:
+		return err
+	case <-f.httpStop:
+		return frameworkhttp.ErrServerClosed
+	}
+}
+
 // Framework http server for data request.
 // Each request will be in the format: "/datareq?taskID=XXX&req=XXX".
 // "taskID" indicates the requesting task. "req" is the meta data for this request.
@@ -62,8 +251,19 @@ func (f *framework) GetTaskData(taskID, epoch uint64, req string) ([]byte, error
 func (f *framework) startHTTP() {
 	f.log.Printf("task %d serving http on %s\n", f.taskID, f.ln.Addr())
 	// TODO: http server graceful shutdown
-	handler := frameworkhttp.NewDataRequestHandler(f.log, f)
-	err := http.Serve(f.ln, handler)
+	mux := http.NewServeMux()
+	mux.Handle(frameworkhttp.DataRequestPrefix, frameworkhttp.NewDataRequestHandler(f.log, f))
+	mux.Handle(frameworkhttp.DataBatchPrefix, frameworkhttp.NewDataBatchHandler(f.log, f))
+	mux.Handle(frameworkhttp.DataPushPrefix, frameworkhttp.NewDataPushHandler(f.log, f,
+		// Payloads carry an encoding byte in front of the data.
+		f.maxMessageSizeOrDefault()+1))
+	mux.Handle(frameworkhttp.PingPrefix, frameworkhttp.NewPingHandler(f.GetTaskID))
+	mux.Handle(frameworkhttp.HealthPrefix, frameworkhttp.NewHealthHandler(f.checkEtcdHealth))
+	ln := f.ln
+	if f.tlsConfig != nil {
+		ln = tls.NewListener(f.ln, f.tlsConfig)
+	}
+	err := http.Serve(ln, mux)
 	select {
 	case <-f.httpStop:
 		f.log.Printf("task %d http stops serving", f.taskID)
@@ -87,32 +287,73 @@ func (f *framework) sendResponse(dr *dataResponse) {
 
 func (f *framework) handleDataReq(dr *dataRequest) {
 	var data []byte
+	// Requests beyond the worker pool size wait here for a free slot.
+	f.serveSlots <- struct{}{}
+	topo := f.loadTopology()
 	switch {
-	case topoutil.IsParent(f.topology, dr.epoch, dr.taskID):
+	case topoutil.IsParent(topo, dr.epoch, dr.taskID):
 		data = f.task.ServeAsChild(dr.taskID, dr.req)
-	case topoutil.IsChild(f.topology, dr.epoch, dr.taskID):
+	case topoutil.IsChild(topo, dr.epoch, dr.taskID):
 		data = f.task.ServeAsParent(dr.taskID, dr.req)
 	default:
 		f.log.Panic("unexpected")
 	}
+	<-f.serveSlots
+	if int64(len(data)) > f.maxMessageSizeOrDefault() {
+		f.log.Printf("task %d: response of %d bytes to task %d exceeds max message size %d",
+			f.taskID, len(data), dr.taskID, f.maxMessageSizeOrDefault())
+		atomic.AddUint64(&f.errorCount, 1)
+		dr.errChan <- frameworkhttp.ErrMessageTooLarge
+		return
+	}
+	f.auditData(meritop.AuditDataServed, dr.epoch, dr.taskID, data)
+	payload := f.encodePayload(data)
+	f.sending(dr.taskID, len(payload))
 	// Getting the data from task could take a long time. We need to let
 	// the response-to-send go through event loop to check epoch.
 	f.dataRespToSendChan <- &dataResponse{
 		taskID:   dr.taskID,
 		epoch:    dr.epoch,
 		req:      dr.req,
-		data:     data,
+		data:     payload,
 		dataChan: dr.dataChan,
 	}
 }
 
 func (f *framework) handleDataResp(ctx meritop.Context, resp *frameworkhttp.DataResponse) {
+	f.traceBuf.record(meritop.TraceCallbackCalled, resp.TaskID, resp.Epoch, resp.Req, nil)
+	topo := f.loadTopology()
 	switch {
-	case topoutil.IsParent(f.topology, resp.Epoch, resp.TaskID):
-		f.task.ParentDataReady(ctx, resp.TaskID, resp.Req, resp.Data)
-	case topoutil.IsChild(f.topology, resp.Epoch, resp.TaskID):
-		f.task.ChildDataReady(ctx, resp.TaskID, resp.Req, resp.Data)
+	case topoutil.IsParent(topo, resp.Epoch, resp.TaskID):
+		if !f.injectedFault("ParentDataReady", ctx.GetEpoch()) {
+			f.task.ParentDataReady(ctx, resp.TaskID, resp.Req, resp.Data)
+		}
+	case topoutil.IsChild(topo, resp.Epoch, resp.TaskID):
+		if !f.injectedFault("ChildDataReady", ctx.GetEpoch()) {
+			f.task.ChildDataReady(ctx, resp.TaskID, resp.Req, resp.Data)
+		}
 	default:
 		f.log.Panic("unexpected")
 	}
 }
+
+func (f *framework) handleDataFailure(ctx meritop.Context, fail *dataFailure) {
+	h, ok := f.task.(meritop.DataRequestFailureHandler)
+	if !ok {
+		return
+	}
+	h.DataRequestFailed(ctx, fail.taskID, fail.req, fail.err)
+}
+
+func (f *framework) handleDataPush(ctx meritop.Context, dp *dataPush) {
+	if !topoutil.IsChild(f.loadTopology(), dp.epoch, dp.taskID) {
+		f.log.Printf("task %d got data pushed from non-child %d", f.taskID, dp.taskID)
+		return
+	}
+	r, ok := f.task.(meritop.PushReceiver)
+	if !ok {
+		f.log.Printf("task %d got data pushed but doesn't receive pushes", f.taskID)
+		return
+	}
+	r.ParentPushReceived(ctx, dp.taskID, dp.data)
+}
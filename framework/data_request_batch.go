@@ -0,0 +1,116 @@
+package framework
+
+import (
+	gocontext "context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+)
+
+func (f *framework) SetDataRequestBatchSize(n int) { f.dataRequestBatchSize = n }
+
+// requests to the same task at the same epoch can go in one batch.
+type batchKey struct {
+	taskID, epoch uint64
+}
+
+// sendRequests sends the requests, and with a batch size above one, the
+// requests queued right behind them as well. Those to the same task at the
+// same epoch go in batches of up to the batch size. It must be called from
+// the event loop.
+func (f *framework) sendRequests(reqs ...*dataRequest) {
+	if f.dataRequestBatchSize > 1 {
+	drain:
+		for {
+			select {
+			case req := <-f.dataReqtoSendChan:
+				reqs = append(reqs, req)
+			default:
+				break drain
+			}
+		}
+	}
+
+	var keys []batchKey
+	batches := make(map[batchKey][]*dataRequest)
+	for _, req := range reqs {
+		if !f.isActiveEpoch(req.epoch) {
+			f.log.Printf("epoch mismatch: task %d, req-to-send epoch: %d, current epoch: %d",
+				f.taskID, req.epoch, f.epoch)
+			continue
+		}
+		if req.epoch == f.epoch && req.attempt != atomic.LoadUint64(&f.attempt) {
+			f.log.Printf("task %d dropped request %q of an aborted attempt of epoch %d", f.taskID, req.req, req.epoch)
+			continue
+		}
+		if !f.dedup.start(requestKey{req.taskID, req.epoch, req.req}) {
+			f.log.Printf("task %d dropped duplicate request %q to task %d", f.taskID, req.req, req.taskID)
+			continue
+		}
+		k := batchKey{req.taskID, req.epoch}
+		if _, ok := batches[k]; !ok {
+			keys = append(keys, k)
+		}
+		batches[k] = append(batches[k], req)
+		if len(batches[k]) >= f.dataRequestBatchSize {
+			f.sendBatch(batches[k])
+			batches[k] = nil
+		}
+	}
+	for _, k := range keys {
+		f.sendBatch(batches[k])
+	}
+}
+
+func (f *framework) sendBatch(batch []*dataRequest) {
+	switch len(batch) {
+	case 0:
+	case 1:
+		go f.sendRequest(batch[0])
+	default:
+		if f.localPeer(batch[0].taskID) != nil {
+			// Nothing to save by batching without round trips.
+			for _, dr := range batch {
+				go f.sendRequest(dr)
+			}
+			return
+		}
+		go f.sendBatchRequest(batch)
+	}
+}
+
+// sendBatchRequest sends requests to the same task at the same epoch in one
+// round trip.
+func (f *framework) sendBatchRequest(batch []*dataRequest) {
+	atomic.AddInt64(&f.pendingRequests, int64(len(batch)))
+	defer atomic.AddInt64(&f.pendingRequests, -int64(len(batch)))
+	to, epoch := batch[0].taskID, batch[0].epoch
+	addr, err := f.GetPeerAddress(to)
+	if err != nil {
+		f.log.Fatalf("getAddress(%d) failed: %v", to, err)
+		return
+	}
+	reqs := make([]string, len(batch))
+	for i, dr := range batch {
+		reqs[i] = dr.req
+		f.traceBuf.record(meritop.TraceRequestIssued, to, epoch, dr.req, nil)
+	}
+	for _, req := range reqs {
+		f.sending(to, len(req))
+	}
+	start := time.Now()
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), f.rpcTimeoutOrDefault())
+	defer cancel()
+	// The payload has one byte telling its encoding in front of the data.
+	resps, err := frameworkhttp.BatchRequestData(ctx, f.rpcClient, addr, reqs, f.taskID, to, epoch, f.maxMessageSizeOrDefault()+1, f.log)
+	if err != nil {
+		f.requestsFailed(batch, rpcError(ctx, to, start, err))
+		return
+	}
+	latency := time.Since(start)
+	for i, dr := range batch {
+		f.responseReceived(dr, resps[i], latency)
+	}
+}
@@ -0,0 +1,146 @@
+package framework
+
+import (
+	"sync"
+
+	"github.com/go-distributed/meritop"
+)
+
+// FetchImmediately sends every data request right away. It's the default.
+var FetchImmediately meritop.DataRequestPolicy = fetchImmediately{}
+
+type fetchImmediately struct{}
+
+func (fetchImmediately) Execute(ctx meritop.Context, peerID uint64, meta string, fetch func(uint64, string)) {
+	fetch(peerID, meta)
+}
+
+func (fetchImmediately) Flush(ctx meritop.Context) {}
+
+// heldRequest is a data request held back by a policy.
+type heldRequest struct {
+	peerID uint64
+	meta   string
+	fetch  func(uint64, string)
+}
+
+// heldEpochs is how many of the latest epochs policies keep requests of.
+// Those of earlier epochs are dropped, as their responses would be of no use.
+const heldEpochs = 16
+
+// requestHolder keeps the requests held back by epoch, so that epochs kept
+// open side by side don't drop each other's.
+type requestHolder struct {
+	mu     sync.Mutex
+	latest uint64
+	held   map[uint64][]heldRequest
+}
+
+// hold adds the request and returns how many are held in the epoch.
+func (h *requestHolder) hold(epoch uint64, r heldRequest) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.held == nil {
+		h.held = make(map[uint64][]heldRequest)
+	}
+	h.held[epoch] = append(h.held[epoch], r)
+	if epoch > h.latest {
+		h.latest = epoch
+		for e := range h.held {
+			if e+heldEpochs <= epoch {
+				delete(h.held, e)
+			}
+		}
+	}
+	return len(h.held[epoch])
+}
+
+// take removes and returns the requests held in the epoch.
+func (h *requestHolder) take(epoch uint64) []heldRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	held := h.held[epoch]
+	delete(h.held, epoch)
+	return held
+}
+
+func sendAll(held []heldRequest) {
+	for _, r := range held {
+		r.fetch(r.peerID, r.meta)
+	}
+}
+
+// fetchOnce sends a request only the first time it's made in an epoch.
+type fetchOnce struct {
+	mu      sync.Mutex
+	latest  uint64
+	fetched map[requestKey]bool
+}
+
+// NewFetchOnce returns a policy that skips requests already sent to the same
+// peer with the same meta in the epoch.
+func NewFetchOnce() meritop.DataRequestPolicy {
+	return &fetchOnce{fetched: make(map[requestKey]bool)}
+}
+
+func (p *fetchOnce) Execute(ctx meritop.Context, peerID uint64, meta string, fetch func(uint64, string)) {
+	epoch := ctx.GetEpoch()
+	key := requestKey{peerID, epoch, meta}
+	p.mu.Lock()
+	if epoch > p.latest {
+		p.latest = epoch
+		for k := range p.fetched {
+			if k.epoch+heldEpochs <= epoch {
+				delete(p.fetched, k)
+			}
+		}
+	}
+	done := p.fetched[key]
+	p.fetched[key] = true
+	p.mu.Unlock()
+	if !done {
+		fetch(peerID, meta)
+	}
+}
+
+func (p *fetchOnce) Flush(ctx meritop.Context) {}
+
+// fetchLazy holds back every request until flushed.
+type fetchLazy struct {
+	holder requestHolder
+}
+
+// NewFetchLazy returns a policy that sends requests only once the task
+// flushes them, i.e. when it actually needs the data.
+func NewFetchLazy() meritop.DataRequestPolicy { return &fetchLazy{} }
+
+func (p *fetchLazy) Execute(ctx meritop.Context, peerID uint64, meta string, fetch func(uint64, string)) {
+	p.holder.hold(ctx.GetEpoch(), heldRequest{peerID, meta, fetch})
+}
+
+func (p *fetchLazy) Flush(ctx meritop.Context) {
+	sendAll(p.holder.take(ctx.GetEpoch()))
+}
+
+// fetchBatch holds back requests until size of them accumulate.
+type fetchBatch struct {
+	size   int
+	holder requestHolder
+}
+
+// NewFetchBatch returns a policy that sends requests in batches of the given
+// size. A partial batch is sent when the task flushes.
+func NewFetchBatch(size int) meritop.DataRequestPolicy {
+	return &fetchBatch{size: size}
+}
+
+func (p *fetchBatch) Execute(ctx meritop.Context, peerID uint64, meta string, fetch func(uint64, string)) {
+	epoch := ctx.GetEpoch()
+	if p.holder.hold(epoch, heldRequest{peerID, meta, fetch}) >= p.size {
+		sendAll(p.holder.take(epoch))
+	}
+}
+
+func (p *fetchBatch) Flush(ctx meritop.Context) {
+	sendAll(p.holder.take(ctx.GetEpoch()))
+}
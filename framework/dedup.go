@@ -0,0 +1,107 @@
+package framework
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultDeduplicationWindow = time.Minute
+
+type requestKey struct {
+	taskID uint64
+	epoch  uint64
+	req    string
+}
+
+// requestDedup drops data requests that are already in flight and responses
+// that were already delivered within the window.
+type requestDedup struct {
+	mu       sync.Mutex
+	window   time.Duration
+	inFlight map[requestKey]bool
+	done     map[requestKey]time.Time
+}
+
+func newRequestDedup(window time.Duration) *requestDedup {
+	return &requestDedup{
+		window:   window,
+		inFlight: make(map[requestKey]bool),
+		done:     make(map[requestKey]time.Time),
+	}
+}
+
+// start returns false if the same request is in flight or was recently
+// answered, otherwise it marks the request in flight.
+func (d *requestDedup) start(k requestKey) bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire()
+	if _, ok := d.done[k]; ok || d.inFlight[k] {
+		return false
+	}
+	d.inFlight[k] = true
+	return true
+}
+
+// abort forgets a request that failed, so that it can be sent again.
+func (d *requestDedup) abort(k requestKey) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	delete(d.inFlight, k)
+	d.mu.Unlock()
+}
+
+// deliver returns false if the response to the request was already
+// delivered within the window, otherwise it marks it delivered.
+func (d *requestDedup) deliver(k requestKey) bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire()
+	if _, ok := d.done[k]; ok {
+		return false
+	}
+	delete(d.inFlight, k)
+	d.done[k] = time.Now()
+	return true
+}
+
+// forgetEpoch forgets all requests of the epoch, so that they can be sent
+// again when it's started over.
+func (d *requestDedup) forgetEpoch(epoch uint64) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k := range d.inFlight {
+		if k.epoch == epoch {
+			delete(d.inFlight, k)
+		}
+	}
+	for k := range d.done {
+		if k.epoch == epoch {
+			delete(d.done, k)
+		}
+	}
+}
+
+func (d *requestDedup) expire() {
+	now := time.Now()
+	for k, t := range d.done {
+		if now.Sub(t) > d.window {
+			delete(d.done, k)
+		}
+	}
+}
+
+func (f *framework) SetDataRequestDeduplication(enabled bool) { f.dedupEnabled = enabled }
+
+func (f *framework) SetDeduplicationWindow(d time.Duration) { f.dedupWindow = d }
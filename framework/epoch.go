@@ -0,0 +1,109 @@
+package framework
+
+import (
+	gocontext "context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+// casEpoch moves the job from the given epoch to next. It returns
+// meritop.ErrCompareFailed if the job isn't at the given epoch.
+func (f *framework) casEpoch(epoch, next uint64) error {
+	return f.backend.AtomicCompareAndSwap(gocontext.TODO(), f.epochKey(),
+		strconv.FormatUint(epoch, 10), strconv.FormatUint(next, 10))
+}
+
+// setEpoch moves the job to the given epoch, whatever epoch it's at.
+func (f *framework) setEpoch(epoch uint64) error {
+	return f.backend.Put(gocontext.TODO(), f.epochKey(), strconv.FormatUint(epoch, 10))
+}
+
+// getAndWatchEpoch returns the epoch of the job and passes later ones to
+// epochChan until epochStop.
+func (f *framework) getAndWatchEpoch() (uint64, error) {
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	// Watch first, so that no change after the get is missed.
+	events, err := f.backend.Watch(ctx, f.epochKey())
+	if err != nil {
+		cancel()
+		return 0, err
+	}
+	value, err := f.backend.Get(ctx, f.epochKey())
+	if err != nil {
+		cancel()
+		return 0, err
+	}
+	epoch, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		cancel()
+		return 0, err
+	}
+	go func() {
+		<-f.epochStop
+		cancel()
+	}()
+	go func() {
+		last := epoch
+		for ev := range events {
+			if ev.Type != meritop.KVPut || ev.Key != f.epochKey() {
+				continue
+			}
+			next, err := strconv.ParseUint(ev.Value, 10, 64)
+			if err != nil {
+				f.log.Fatalf("can't parse epoch %q: %v", ev.Value, err)
+			}
+			// The change might have been seen by the get already.
+			if next == last {
+				continue
+			}
+			last = next
+			f.epochChan <- next
+		}
+	}()
+	return epoch, nil
+}
+
+// WaitForEpoch watches the epoch of the job rather than polling it.
+func (f *framework) WaitForEpoch(epoch uint64, timeout time.Duration) error {
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), timeout)
+	defer cancel()
+	// Watch first, so that no change after the get is missed.
+	events, err := f.backend.Watch(ctx, f.epochKey())
+	if err != nil {
+		return err
+	}
+	value, err := f.backend.Get(ctx, f.epochKey())
+	if err != nil {
+		return err
+	}
+	current, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	for current < epoch {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				if ctx.Err() == nil {
+					return fmt.Errorf("epoch watch stopped at epoch %d", current)
+				}
+				return fmt.Errorf("%w waiting for epoch %d, job at epoch %d", meritop.ErrTimeout, epoch, current)
+			}
+			if ev.Type != meritop.KVPut || ev.Key != f.epochKey() {
+				continue
+			}
+			if current, err = strconv.ParseUint(ev.Value, 10, 64); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("%w waiting for epoch %d, job at epoch %d", meritop.ErrTimeout, epoch, current)
+		}
+	}
+	if current == exitEpoch {
+		return meritop.ErrJobShutDown
+	}
+	return nil
+}
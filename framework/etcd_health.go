@@ -0,0 +1,94 @@
+package framework
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+// GetEtcdHealth asks the first etcd member among etcdURLs that answers about
+// itself and the cluster. It doesn't go through the event loop, so it can be
+// called at any time.
+func (f *framework) GetEtcdHealth() (meritop.EtcdHealth, error) {
+	client := &http.Client{Timeout: f.dialTimeout()}
+	var err error
+	for _, u := range f.etcdURLs {
+		var h meritop.EtcdHealth
+		if h, err = f.etcdMemberHealth(client, strings.TrimSuffix(u, "/")); err == nil {
+			return h, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("no etcd URLs")
+	}
+	return meritop.EtcdHealth{}, err
+}
+
+func (f *framework) etcdMemberHealth(client *http.Client, url string) (meritop.EtcdHealth, error) {
+	var h meritop.EtcdHealth
+	var self struct {
+		LeaderInfo struct {
+			Leader string `json:"leader"`
+		} `json:"leaderInfo"`
+	}
+	start := time.Now()
+	if err := f.getEtcdJSON(client, url+"/v2/stats/self", &self); err != nil {
+		return h, err
+	}
+	h.RTT = time.Since(start)
+	var members struct {
+		Members []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"members"`
+	}
+	if err := f.getEtcdJSON(client, url+"/v2/members", &members); err != nil {
+		return h, err
+	}
+	for _, m := range members.Members {
+		h.Members = append(h.Members, m.Name)
+		if m.ID == self.LeaderInfo.Leader {
+			h.Leader = m.Name
+		}
+	}
+	h.IsHealthy = h.Leader != ""
+	return h, nil
+}
+
+func (f *framework) getEtcdJSON(client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	f.credMu.Lock()
+	if f.etcdUsername != "" {
+		req.SetBasicAuth(f.etcdUsername, f.etcdPassword)
+	}
+	f.credMu.Unlock()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s: response code = %d, expect = %d", url, resp.StatusCode, 200)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// checkEtcdHealth fails unless etcd has a leader, for the health probe.
+func (f *framework) checkEtcdHealth() error {
+	h, err := f.GetEtcdHealth()
+	if err != nil {
+		return err
+	}
+	if !h.IsHealthy {
+		return errors.New("etcd has no leader")
+	}
+	return nil
+}
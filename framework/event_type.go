@@ -8,10 +8,15 @@ type metaChange struct {
 }
 
 type dataRequest struct {
-	taskID   uint64
-	epoch    uint64
+	taskID uint64
+	epoch  uint64
+	// the attempt of the epoch the request was made in, see
+	// framework.attempt.
+	attempt  uint64
 	req      string
 	dataChan chan []byte
+	// set when the request can't be served, e.g. the response is too large.
+	errChan chan error
 }
 
 func (dr *dataRequest) notifyEpochMismatch() {
@@ -29,3 +34,19 @@ type dataResponse struct {
 func (dr *dataResponse) notifyEpochMismatch() {
 	close(dr.dataChan)
 }
+
+// dataFailure tells the requesting task that its request won't be answered.
+type dataFailure struct {
+	taskID uint64
+	epoch  uint64
+	req    string
+	err    error
+}
+
+type dataPush struct {
+	taskID uint64
+	epoch  uint64
+	data   []byte
+	// set on the receiving side to tell the pushing one how it went.
+	errChan chan error
+}
@@ -0,0 +1,62 @@
+package framework
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Faults are injected process wide, so that a fault fires once even though
+// the failed node is replaced by a new one, as in the integration tests.
+var (
+	faultInjectionEnabled int32
+	faultMu               sync.Mutex
+	faults                = make(map[fault]bool)
+)
+
+type fault struct {
+	taskID uint64
+	method string
+	epoch  uint64
+}
+
+// EnableFaultInjectionMode makes the faults given to InjectFaultAt fire.
+// It's meant for tests only.
+func EnableFaultInjectionMode() { atomic.StoreInt32(&faultInjectionEnabled, 1) }
+
+// DisableFaultInjectionMode turns fault injection off and forgets the faults
+// that haven't fired yet.
+func DisableFaultInjectionMode() {
+	atomic.StoreInt32(&faultInjectionEnabled, 0)
+	faultMu.Lock()
+	faults = make(map[fault]bool)
+	faultMu.Unlock()
+}
+
+// InjectFaultAt fails the node running the given task the first time the
+// framework is about to call the given task method, e.g. "SetEpoch" or
+// "ChildDataReady", at the given epoch. The method isn't called; the node
+// stops as if it crashed, see Bootstrap.SetNodeProducer.
+func InjectFaultAt(taskID uint64, method string, epoch uint64) {
+	faultMu.Lock()
+	defer faultMu.Unlock()
+	faults[fault{taskID, method, epoch}] = true
+}
+
+// injectedFault tells if the task should fail at the method in the epoch. If
+// so, the node is stopped and the fault is used up.
+func (f *framework) injectedFault(method string, epoch uint64) bool {
+	if atomic.LoadInt32(&faultInjectionEnabled) == 0 {
+		return false
+	}
+	faultMu.Lock()
+	ft := fault{f.taskID, method, epoch}
+	fire := faults[ft]
+	delete(faults, ft)
+	faultMu.Unlock()
+	if !fire {
+		return false
+	}
+	f.log.Printf("task %d fails at %s in epoch %d by fault injection", f.taskID, method, epoch)
+	f.stop()
+	return true
+}
@@ -1,34 +1,310 @@
 package framework
 
 import (
+	gocontext "context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
+	"math/rand"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/go-distributed/meritop"
 	"github.com/go-distributed/meritop/framework/frameworkhttp"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
+	"github.com/go-distributed/meritop/pkg/topoutil"
 )
 
 const exitEpoch = math.MaxUint64
 
 type framework struct {
 	// These should be passed by outside world
-	name     string
-	etcdURLs []string
-	log      *log.Logger
+	name      string
+	nodeID    string
+	etcdURLs  []string
+	log       *log.Logger
+	keyPrefix string
+	namespace string
+
+	etcdDialTimeout    time.Duration
+	etcdRequestTimeout time.Duration
+	// TTL of the keys kept alive by heartbeats, see heartbeatInterval.
+	etcdLeaseTTL time.Duration
+	// affinity group of every task in one. The framework refuses to start
+	// with affinityErr, e.g. if a task was put in two groups.
+	affinityGroups map[uint64]string
+	affinityErr    error
+	// group barriers by group name, see SetTaskGroupBarrier. barrierScope
+	// is the attempt of the epoch barriers are reached in, guarded by
+	// barrierMu, and oldBarrierKeys the keys reached in the one before.
+	barriers       map[string]*groupBarrier
+	barrierMu      sync.Mutex
+	barrierScope   *barrierScope
+	oldBarrierKeys []string
+
+	// etcd user, if authentication is needed. credMu serializes rotations.
+	credMu       sync.Mutex
+	etcdUsername string
+	etcdPassword string
+	// if set, connections of the etcd clients are shared with others through
+	// the pool.
+	etcdPool meritop.EtcdPool
+
+	// Init of the task is given up after initTimeout (zero means never) and
+	// retried at most maxTaskRestarts times before the job is shut down.
+	initTimeout     time.Duration
+	maxTaskRestarts int
+	// how long Exit of the task is waited for on shutdown. Zero means no limit.
+	shutdownTimeout time.Duration
+	// Start waits up to this long for every task before epoch 0.
+	startupWaitTimeout time.Duration
+	// told why the task stops, see notifyShutdown.
+	onShutdown   func(cause meritop.ShutdownCause)
+	shutdownOnce sync.Once
+
+	// the random number generator, seeded with seed if seedSet, see random.
+	seed     int64
+	seedSet  bool
+	randOnce sync.Once
+	rand     *rand.Rand
+
+	// at most workerPoolSize ServeAs* calls run at a time, each holding a slot.
+	workerPoolSize int
+	serveSlots     chan struct{}
+	// at most maxPendingMetaFlags meta flags are written at a time, each
+	// holding a slot until etcd acknowledged it.
+	maxPendingMetaFlags int
+	metaFlagSlots       chan struct{}
+	// meta flags of up to metaFlagConcurrency tasks are watched for at once
+	// when an epoch starts. 1 if unset.
+	metaFlagConcurrency int
+
+	// called with the status of every live task once per heartbeat interval.
+	heartbeatHandler func(taskID uint64, status meritop.HeartbeatStatus)
+	// data requests sent by this task still waiting for a response, data
+	// requests to this task not served yet, and meta flags of parents and
+	// children not handled by the task yet.
+	pendingRequests int64
+	pendingIncoming int64
+	pendingMeta     int64
+
+	// up to dataRequestBatchSize data requests to the same task are sent
+	// in one round trip if they are queued together.
+	dataRequestBatchSize int
+	// data requests not answered within rpcTimeout fail; see
+	// rpcTimeoutOrDefault.
+	rpcTimeout time.Duration
+
+	// larger responses and pushes are rejected; see maxMessageSizeOrDefault.
+	maxMessageSize int64
+
+	// data responses and pushes of at least compressionThreshold bytes are
+	// compressed if compression is on.
+	compression          bool
+	compressionThreshold int
+	// data requests to tasks of this process skip the network, see
+	// localPeer.
+	sharedMemory bool
+	// sees, and might change or drop, every response and push received.
+	messageInterceptor func(from, to, epoch uint64, payload []byte) []byte
+
+	// task builder configuration is loaded from environment variables with
+	// this prefix, if set.
+	envConfigPrefix string
+	strictEnvConfig bool
+
+	// data request deduplication. dedup is nil unless enabled.
+	dedupEnabled bool
+	dedupWindow  time.Duration
+	dedup        *requestDedup
+
+	// epochs it returns true for are skipped without calling Task.SetEpoch.
+	epochSkipPolicy func(epoch uint64) bool
+
+	// told whenever this node stops on a (testable) failure, so that a new
+	// node can be brought up.
+	nodeProducer chan<- bool
+
+	// the node stops once it's done, if not nil.
+	ctx gocontext.Context
+
+	// fraction of children that must respond in an epoch for the quorum to
+	// be met, 1 if unset. childResponses has the data of those who did by
+	// epoch, one slot for each active epoch, guarded by childMu. So do the
+	// weights set by Context.SetChildWeight.
+	aggregationQuorum float64
+	childMu           sync.Mutex
+	childResponses    map[uint64]map[uint64][]byte
+	childWeights      map[uint64]map[uint64]float64
+
+	// number of epochs, up to the current one, which are open: their data
+	// requests and responses are still served, each in its own slot. 1 if
+	// unset.
+	maxParallelEpochs int
+
+	// checks the data children sent before the epoch is incremented. A
+	// rejected epoch is started over up to maxEpochRetries times.
+	epochValidator  func(epoch uint64, gradients map[uint64][]byte) error
+	maxEpochRetries int
+	retriedEpoch    uint64
+	epochRetries    int
+	epochRetryChan  chan uint64
+	// how many times the epoch was started over, aborts included, as told
+	// to EpochRetryHandler. Only used by the event loop.
+	restartedEpoch uint64
+	epochAttempts  uint64
+
+	// runs once the epoch is validated, before it's incremented. Failures
+	// are retried up to maxTransitionRetries times.
+	epochTransition      func(ctx meritop.Context, fromEpoch, toEpoch uint64) error
+	maxTransitionRetries int
+	// checks every response of a child. Rejected requests are sent again
+	// up to maxResponseRetries times, counted in responseRetries.
+	childResponseValidator func(childID, epoch uint64, data []byte) error
+	maxResponseRetries     int
+	responseRetryMu        sync.Mutex
+	responseRetries        map[requestKey]int
+	// told about every epoch this task increments, see completeEpoch.
+	epochCompletion func(epoch uint64, gradientSum []byte)
+	// computes the ID of the epoch after the given one, see nextEpoch.
+	epochIDGenerator func(prevEpoch uint64) uint64
+
+	// data responses and pushes up to this many epochs old are still
+	// delivered to the task.
+	stalenessWindow uint64
+
+	// nil means JSON.
+	serializer meritop.Serializer
+
+	// latencies of recent data requests by the task they were sent to.
+	latencies latencyRecorder
+	// rates of data sent to and received from other tasks.
+	bandwidth bandwidthMeter
+	// limits of the bytes per second of data sent and received, if set.
+	sendLimit, recvLimit *tokenBucket
+	// where epoch and data events are logged, if set.
+	auditStore meritop.AuditStore
+
+	// returns the order in which tasks register in etcd. initOrder maps a
+	// task to its position in it.
+	workerInitOrder func(numTasks uint64) []uint64
+	initOrder       map[uint64]int
+
+	// status is reported to the supervisor, if any, whose commands are passed
+	// to the event loop.
+	supervisor        meritop.SupervisorClient
+	supervisorCmdChan chan meritop.SupervisorCommand
+
+	// nil means every data request is sent right away.
+	dataRequestPolicy meritop.DataRequestPolicy
+
+	// nil means the task commits epochs itself. Epochs whose commit timed
+	// out are passed to the event loop, which remembers the last epoch it
+	// committed. commitTimer times out the current attempt of the epoch.
+	commitStrategy meritop.CommitStrategy
+	commitChan     chan commitTimeout
+	commitTimer    *time.Timer
+	committedEpoch uint64
+
+	// see SetParameterUpdateFunction, nil if unset.
+	paramUpdate func(param, gradient []byte) []byte
+	// epochs below warmupEpochs are warm-up epochs.
+	warmupEpochs uint64
+
+	// replace the built-in etcd keys of the epoch and task addresses, if set.
+	customEpochKey func(jobName string, epoch uint64) string
+	customTaskKey  func(jobName string, taskID uint64) string
+
+	// aborts of epochs, made by any task, as told by the metadata store.
+	// epochAborts counts those of the current epoch, and is the attempt
+	// aborts are made in.
+	abortChan   chan *epochAbort
+	epochAborts uint64
+	// bumped whenever an epoch is started over. Requests carry it, so that
+	// responses to those of an earlier attempt are dropped.
+	attempt uint64
+	// commands sent to this task, see SendCommandToTask.
+	commandChan chan meritop.Command
+
+	// the topology given last to UpdateTopology until the next epoch starts,
+	// guarded by nextTopologyMu.
+	nextTopologyMu         sync.Mutex
+	nextTopology           meritop.Topology
+	topologyUpdateCallback func(old, new meritop.Topology)
+	// 1 from SetEpoch until IncEpoch is called or the epoch ends, see
+	// SetTopology.
+	epochInProgress int32
+
+	// nil if user metrics are dropped.
+	metricsSink meritop.MetricsSink
+
+	// nil unless request tracing is enabled.
+	traceBuf *traceBuffer
+
+	// checkpoints to resume the job from. Empty replayDir means no replay,
+	// unless a snapshot was imported to the checkpoint store.
+	replayDir      string
+	replayEpoch    uint64
+	replaySnapshot bool
+	// checkpoints of checkpointable tasks are saved to checkpointStore
+	// after every epoch that is a multiple of checkpointInterval, if set.
+	checkpointStore    meritop.CheckpointStore
+	checkpointInterval uint64
+
+	// set once Start is called, at startTime, after which configuration
+	// can't change.
+	started   bool
+	startTime time.Time
+	// when SetEpoch was called for the current epoch.
+	epochStartTime time.Time
+	// when SetEpoch was called last, which the next epoch is paced after if
+	// maxEpochsPerSecond is set.
+	lastEpochStart     time.Time
+	maxEpochsPerSecond float64
+	// how long each epoch took by epoch, guarded by durationsMu.
+	durationsMu    sync.Mutex
+	epochDurations []time.Duration
 
 	// user defined interfaces
 	taskBuilder meritop.TaskBuilder
-	topology    meritop.Topology
+	// the topology is swapped by the event loop between epochs while data
+	// requests are served, guarded by topologyMu, see loadTopology.
+	topologyMu sync.RWMutex
+	topology   meritop.Topology
 
-	task       meritop.Task
-	taskID     uint64
-	epoch      uint64
-	etcdClient *etcd.Client
+	task   meritop.Task
+	taskID uint64
+	// epoch is only used by the event loop, which publishes it to
+	// publishedEpoch for those outside, see loadEpoch.
+	epoch          uint64
+	publishedEpoch uint64
+	etcdClient     *etcd.Client
+	// watchClient is used for watches, which are long polls and thus must
+	// not be bound by the request timeout. It might be etcdClient itself.
+	watchClient *etcd.Client
+	// number of errors this task ran into so far. It is reported via heartbeat.
+	errorCount uint64
 	ln         net.Listener
+	// RPCs between tasks go over TLS with tlsConfig, as soon as
+	// SetTLSConfig was called. rpcClient talks to the other tasks.
+	tlsConfig   *tls.Config
+	tlsRequired bool
+	rpcClient   *frameworkhttp.Client
+
+	// where meta flags are kept. etcd unless set otherwise.
+	metaStore meritop.MetadataStore
+	// where the epoch, job status and task addresses are kept. It's etcd
+	// unless set, and the metadata store unless that is set.
+	backend meritop.CoordinationBackend
+	// whether the task address is registered with a backend that was set.
+	// With etcd, occupying the task registers it already.
+	registered bool
 
 	// etcd stops
 	metaStops []chan bool
@@ -41,26 +317,29 @@ type framework struct {
 	epochChan          chan uint64
 	metaChan           chan *metaChange
 	dataReqtoSendChan  chan *dataRequest
+	bulkReqToSendChan  chan []*dataRequest
 	dataReqChan        chan *dataRequest
 	dataRespToSendChan chan *dataResponse
 	dataRespChan       chan *frameworkhttp.DataResponse
+	dataFailChan       chan *dataFailure
+	dataPushToSendChan chan *dataPush
+	dataPushChan       chan *dataPush
 }
 
 func (f *framework) flagMetaToParent(meta string, epoch uint64) {
-	value := fmt.Sprintf("%d-%s", epoch, meta)
-	_, err := f.etcdClient.Set(etcdutil.ParentMetaPath(f.name, f.GetTaskID()), value, 0)
-	if err != nil {
-		f.log.Fatalf("etcdClient.Set failed; key: %s, value: %s, error: %v",
-			etcdutil.ParentMetaPath(f.name, f.GetTaskID()), value, err)
-	}
+	f.flagMeta(etcdutil.ParentMetaPath(f.jobPath(), f.GetTaskID()), meta, epoch)
 }
 
 func (f *framework) flagMetaToChild(meta string, epoch uint64) {
+	f.flagMeta(etcdutil.ChildMetaPath(f.jobPath(), f.GetTaskID()), meta, epoch)
+}
+
+func (f *framework) flagMeta(key, meta string, epoch uint64) {
 	value := fmt.Sprintf("%d-%s", epoch, meta)
-	_, err := f.etcdClient.Set(etcdutil.ChildMetaPath(f.name, f.GetTaskID()), value, 0)
-	if err != nil {
-		f.log.Fatalf("etcdClient.Set failed; key: %s, value: %s, error: %v",
-			etcdutil.ChildMetaPath(f.name, f.GetTaskID()), value, err)
+	f.metaFlagSlots <- struct{}{}
+	defer func() { <-f.metaFlagSlots }()
+	if err := f.metaStore.Put(gocontext.TODO(), key, value); err != nil {
+		f.log.Fatalf("metaStore.Put failed; key: %s, value: %s, error: %v", key, value, err)
 	}
 }
 
@@ -68,11 +347,68 @@ func (f *framework) flagMetaToChild(meta string, epoch uint64) {
 // update the etcd epoch to next uint64. All nodes should watch
 // for epoch and update their local epoch correspondingly.
 func (f *framework) incEpoch(epoch uint64) {
-	err := etcdutil.CASEpoch(f.etcdClient, f.name, epoch, epoch+1)
-	if err != nil {
-		f.log.Fatalf("task %d Epoch CompareAndSwap(%d, %d) failed: %v",
-			f.taskID, f.epoch+1, epoch, err)
+	if err := f.validateEpoch(epoch); err != nil {
+		return
+	}
+	atomic.StoreInt32(&f.epochInProgress, 0)
+	f.completeEpoch(epoch)
+	next := f.nextEpoch(epoch)
+	if err := f.transitionEpoch(epoch, next); err != nil {
+		return
+	}
+	errc := make(chan error, 1)
+	f.casEpochAsync(epoch, next, func(newEpoch uint64, err error) { errc <- err })
+	if err := <-errc; err != nil {
+		f.etcdFailed("task %d Epoch CompareAndSwap(%d, %d) failed: %v",
+			f.taskID, epoch, next, err)
+	}
+}
+
+// incEpochAsync validates the epoch and updates the etcd epoch in the
+// background. callback is called once the update is done, or with the
+// validation error if the epoch is rejected.
+func (f *framework) incEpochAsync(epoch uint64, callback func(newEpoch uint64, err error)) {
+	go func() {
+		if err := f.validateEpoch(epoch); err != nil {
+			callback(epoch, err)
+			return
+		}
+		atomic.StoreInt32(&f.epochInProgress, 0)
+		f.completeEpoch(epoch)
+		next := f.nextEpoch(epoch)
+		if err := f.transitionEpoch(epoch, next); err != nil {
+			callback(epoch, err)
+			return
+		}
+		err := f.casEpoch(epoch, next)
+		callback(next, err)
+	}()
+}
+
+// casEpochAsync updates the epoch in the background and calls callback once
+// the update is done.
+func (f *framework) casEpochAsync(epoch, next uint64, callback func(newEpoch uint64, err error)) {
+	go func() {
+		err := f.casEpoch(epoch, next)
+		callback(next, err)
+	}()
+}
+
+// nextEpoch returns the ID of the epoch after the given one: the next
+// integer, unless the epoch ID generator says otherwise. IDs not larger than
+// the given one, or taken to tell tasks to exit, are not used.
+func (f *framework) nextEpoch(epoch uint64) uint64 {
+	if f.epochIDGenerator == nil {
+		return epoch + 1
 	}
+	next := f.epochIDGenerator(epoch)
+	if next <= epoch || next == exitEpoch {
+		f.log.Printf("task %d: generated epoch ID %d after %d isn't usable, taking %d instead",
+			f.taskID, next, epoch, epoch+1)
+		atomic.AddUint64(&f.errorCount, 1)
+		return epoch + 1
+	}
+	return next
 }
 
 func (f *framework) dataRequest(toID uint64, req string, epoch uint64) {
@@ -81,27 +417,380 @@ func (f *framework) dataRequest(toID uint64, req string, epoch uint64) {
 	// the epoch won't change at the time task sending this request.
 	// Epoch may change, however, before the request is actually being sent.
 	f.dataReqtoSendChan <- &dataRequest{
-		taskID: toID,
-		epoch:  epoch,
-		req:    req,
+		taskID:  toID,
+		epoch:   epoch,
+		attempt: atomic.LoadUint64(&f.attempt),
+		req:     req,
+	}
+}
+
+// bulkDataRequest hands the requests to all peers to the event loop at
+// once, which sends them all before it gets to anything else.
+func (f *framework) bulkDataRequest(peers []uint64, req string, epoch uint64) {
+	if len(peers) == 0 {
+		return
+	}
+	reqs := make([]*dataRequest, len(peers))
+	attempt := atomic.LoadUint64(&f.attempt)
+	for i, id := range peers {
+		reqs[i] = &dataRequest{taskID: id, epoch: epoch, attempt: attempt, req: req}
+	}
+	f.bulkReqToSendChan <- reqs
+}
+
+func (f *framework) pushDataToParent(data []byte, epoch uint64) {
+	if int64(len(data)) > f.maxMessageSizeOrDefault() {
+		f.log.Printf("task %d: pushing %d bytes exceeds max message size %d, dropped",
+			f.taskID, len(data), f.maxMessageSizeOrDefault())
+		atomic.AddUint64(&f.errorCount, 1)
+		return
+	}
+	for _, parentID := range f.loadTopology().GetParents(epoch) {
+		f.dataPushToSendChan <- &dataPush{
+			taskID: parentID,
+			epoch:  epoch,
+			data:   data,
+		}
+	}
+}
+
+func (f *framework) emitUserMetric(name string, value float64, labels map[string]string) {
+	if f.metricsSink == nil {
+		return
+	}
+	f.metricsSink.EmitMetric(meritop.UserMetricPrefix+name, value, labels)
+}
+
+// withinStaleness tells if data of the given epoch can still be delivered
+// in the current one.
+func (f *framework) withinStaleness(epoch uint64) bool {
+	return epoch <= f.epoch && f.epoch-epoch <= f.stalenessWindow || f.isActiveEpoch(epoch)
+}
+
+// isActiveEpoch tells if the given epoch is one of the last
+// maxParallelEpochs, which are open. It must be called from the event loop.
+func (f *framework) isActiveEpoch(epoch uint64) bool {
+	return f.isActiveAt(epoch, f.epoch)
+}
+
+// isActiveAt is isActiveEpoch at the given current epoch.
+func (f *framework) isActiveAt(epoch, current uint64) bool {
+	n := uint64(1)
+	if f.maxParallelEpochs > 1 {
+		n = uint64(f.maxParallelEpochs)
+	}
+	return epoch <= current && current-epoch < n
+}
+
+// setEpochOfLoop moves the event loop to the given epoch.
+func (f *framework) setEpochOfLoop(epoch uint64) {
+	f.epoch = epoch
+	atomic.StoreUint64(&f.publishedEpoch, epoch)
+}
+
+// loadEpoch returns the epoch the event loop is at, for those outside it.
+func (f *framework) loadEpoch() uint64 {
+	return atomic.LoadUint64(&f.publishedEpoch)
+}
+
+// loadTopology returns the topology in use.
+func (f *framework) loadTopology() meritop.Topology {
+	f.topologyMu.RLock()
+	defer f.topologyMu.RUnlock()
+	return f.topology
+}
+
+func (f *framework) storeTopology(topology meritop.Topology) {
+	f.topologyMu.Lock()
+	defer f.topologyMu.Unlock()
+	f.topology = topology
+}
+
+// slotEpoch returns the epoch whose slot data of the given epoch goes to:
+// its own if it's active, the current one if it's merely within staleness.
+func (f *framework) slotEpoch(epoch uint64) uint64 {
+	if f.isActiveEpoch(epoch) {
+		return epoch
 	}
+	return f.epoch
 }
 
-func (f *framework) GetTopology() meritop.Topology { return f.topology }
+// pruneChildResponses drops the slots of epochs no longer active.
+func (f *framework) pruneChildResponses() {
+	f.childMu.Lock()
+	defer f.childMu.Unlock()
+	for epoch := range f.childResponses {
+		if !f.isActiveEpoch(epoch) {
+			delete(f.childResponses, epoch)
+		}
+	}
+	for epoch := range f.childWeights {
+		if !f.isActiveEpoch(epoch) {
+			delete(f.childWeights, epoch)
+		}
+	}
+}
+
+func (f *framework) setChildWeight(epoch, childID uint64, weight float64) {
+	f.childMu.Lock()
+	defer f.childMu.Unlock()
+	if f.childWeights == nil {
+		f.childWeights = make(map[uint64]map[uint64]float64)
+	}
+	if f.childWeights[epoch] == nil {
+		f.childWeights[epoch] = make(map[uint64]float64)
+	}
+	f.childWeights[epoch][childID] = weight
+}
+
+func (f *framework) childWeight(epoch, childID uint64) float64 {
+	f.childMu.Lock()
+	defer f.childMu.Unlock()
+	if w, ok := f.childWeights[epoch][childID]; ok {
+		return w
+	}
+	return 1
+}
+
+// quorumMet tells if enough children responded to data requests in the
+// given epoch. It must be called from the event loop.
+func (f *framework) quorumMet(epoch uint64) bool {
+	quorum := f.aggregationQuorum
+	if quorum <= 0 {
+		quorum = 1
+	}
+	needed := int(math.Ceil(quorum * float64(len(f.loadTopology().GetChildren(epoch)))))
+	f.childMu.Lock()
+	defer f.childMu.Unlock()
+	return len(f.childResponses[epoch]) >= needed
+}
+
+func (f *framework) GetIncompleteEpochTasks(epoch uint64) ([]uint64, error) {
+	if current := f.loadEpoch(); !f.isActiveAt(epoch, current) {
+		return nil, fmt.Errorf("epoch %d isn't open, current epoch is %d", epoch, current)
+	}
+	children := f.loadTopology().GetChildren(epoch)
+	f.childMu.Lock()
+	defer f.childMu.Unlock()
+	incomplete := make([]uint64, 0)
+	for _, id := range children {
+		if _, ok := f.childResponses[epoch][id]; !ok {
+			incomplete = append(incomplete, id)
+		}
+	}
+	return incomplete, nil
+}
+
+// recordEpochDuration records how long the given epoch took, from SetEpoch
+// until now that the job moves on. It must be called from the event loop.
+func (f *framework) recordEpochDuration(epoch uint64) {
+	if f.epochStartTime.IsZero() {
+		return
+	}
+	d := time.Since(f.epochStartTime)
+	// Skipped epochs don't start, so they aren't recorded.
+	f.epochStartTime = time.Time{}
+	f.durationsMu.Lock()
+	defer f.durationsMu.Unlock()
+	// Generated epoch IDs can be far apart, e.g. timestamps.
+	if f.epochIDGenerator != nil {
+		f.epochDurations = append(f.epochDurations, d)
+		return
+	}
+	// A task taking over from a failed one hasn't seen the epochs before.
+	for uint64(len(f.epochDurations)) <= epoch {
+		f.epochDurations = append(f.epochDurations, 0)
+	}
+	f.epochDurations[epoch] = d
+}
+
+func (f *framework) GetEpochDurations() []time.Duration {
+	f.durationsMu.Lock()
+	defer f.durationsMu.Unlock()
+	return append([]time.Duration(nil), f.epochDurations...)
+}
+
+// completeEpoch calls the epoch completion callback, if any, with what the
+// task aggregated in the given epoch.
+func (f *framework) completeEpoch(epoch uint64) {
+	if f.epochCompletion == nil {
+		return
+	}
+	var sum []byte
+	if a, ok := f.task.(meritop.Aggregator); ok {
+		sum = a.Aggregate(epoch)
+	}
+	f.epochCompletion(epoch, sum)
+}
+
+// transitionEpoch runs the custom epoch transition, if any, from the given
+// epoch to the next. If it still fails after maxTransitionRetries retries,
+// the job is shut down.
+func (f *framework) transitionEpoch(epoch, next uint64) error {
+	if f.epochTransition == nil {
+		return nil
+	}
+	ctx := f.createContextAt(epoch)
+	var err error
+	for i := 0; i <= f.maxTransitionRetries; i++ {
+		if err = f.epochTransition(ctx, epoch, next); err == nil {
+			return nil
+		}
+		f.log.Printf("task %d: transition from epoch %d failed: %v", f.taskID, epoch, err)
+		atomic.AddUint64(&f.errorCount, 1)
+	}
+	f.log.Printf("task %d: transition from epoch %d failed %d times, shutting down job",
+		f.taskID, epoch, f.maxTransitionRetries+1)
+	f.ShutdownJobWithCause(meritop.TaskFailure)
+	return err
+}
+
+// epochBoundary is how LogEpochBoundary annotations are logged.
+type epochBoundary struct {
+	Event  string `json:"event"`
+	TaskID uint64 `json:"task"`
+	Epoch  uint64 `json:"epoch"`
+	Msg    string `json:"msg"`
+}
+
+func (f *framework) LogEpochBoundary(epoch uint64, msg string) {
+	b, err := json.Marshal(epochBoundary{Event: "epoch_boundary", TaskID: f.taskID, Epoch: epoch, Msg: msg})
+	if err != nil {
+		f.log.Printf("task %d: can't log epoch boundary %q: %v", f.taskID, msg, err)
+		return
+	}
+	f.log.Printf("%s", b)
+}
+
+// validateEpoch runs the epoch validator, if any, on the data children sent
+// in the given epoch. If it fails, the epoch is started over, or the job is
+// shut down once it failed more than maxEpochRetries times.
+func (f *framework) validateEpoch(epoch uint64) error {
+	if f.epochValidator == nil {
+		return nil
+	}
+	f.childMu.Lock()
+	gradients := make(map[uint64][]byte, len(f.childResponses[epoch]))
+	for id, data := range f.childResponses[epoch] {
+		gradients[id] = data
+	}
+	f.childMu.Unlock()
+	err := f.epochValidator(epoch, gradients)
+	if err == nil {
+		return nil
+	}
+
+	f.childMu.Lock()
+	if f.retriedEpoch != epoch {
+		f.retriedEpoch, f.epochRetries = epoch, 0
+	}
+	f.epochRetries++
+	retries := f.epochRetries
+	f.childMu.Unlock()
+	if retries > f.maxEpochRetries {
+		f.log.Printf("task %d: epoch %d failed validation %d times, shutting down job: %v",
+			f.taskID, epoch, retries, err)
+		f.ShutdownJobWithCause(meritop.TaskFailure)
+		return err
+	}
+	f.log.Printf("task %d: epoch %d failed validation, starting it over: %v", f.taskID, epoch, err)
+	f.epochRetryChan <- epoch
+	return err
+}
+
+func (f *framework) GetTopology() meritop.Topology { return f.loadTopology() }
+
+func (f *framework) GetTopologyDepth() uint64 { return f.loadTopology().GetDepth(f.loadEpoch()) }
+
+// GetTopologyAsAdjacencyMatrix returns the topology at the current epoch over
+// all tasks of the job, see topoutil.AdjacencyMatrix.
+func (f *framework) GetTopologyAsAdjacencyMatrix() ([][]bool, error) {
+	taskIDs, err := etcdutil.ListTaskIDs(f.etcdClient, f.jobPath())
+	if err != nil {
+		return nil, err
+	}
+	return topoutil.AdjacencyMatrix(f.loadTopology(), uint64(len(taskIDs)), f.loadEpoch()), nil
+}
+
+// GetTaskGraph returns the topology at the current epoch over all tasks of
+// the job, see topoutil.TaskGraph.
+func (f *framework) GetTaskGraph() (*meritop.TaskGraph, error) {
+	taskIDs, err := etcdutil.ListTaskIDs(f.etcdClient, f.jobPath())
+	if err != nil {
+		return nil, err
+	}
+	return topoutil.TaskGraph(f.loadTopology(), taskIDs, f.loadEpoch()), nil
+}
+
+func (f *framework) GetEtcdNamespace() string { return f.namespace }
+
+func (f *framework) GetJobStartTime() time.Time { return f.startTime }
+
+func (f *framework) GetJobElapsedTime() time.Duration { return time.Since(f.startTime) }
+
+func (f *framework) rpcTimeoutOrDefault() time.Duration {
+	if f.rpcTimeout <= 0 {
+		return defaultRPCTimeout
+	}
+	return f.rpcTimeout
+}
+
+func (f *framework) maxMessageSizeOrDefault() int64 {
+	if f.maxMessageSize <= 0 {
+		return defaultMaxMessageSize
+	}
+	return f.maxMessageSize
+}
+
+// jobPath returns the etcd directory that all keys of this job live under.
+func (f *framework) jobPath() string {
+	prefix := f.keyPrefix
+	if prefix == "" {
+		prefix = etcdutil.DefaultKeyPrefix
+	}
+	return etcdutil.JobPath(f.namespace, prefix, f.name)
+}
+
+// epochKey is where the epoch of the job is kept in etcd.
+func (f *framework) epochKey() string {
+	if f.customEpochKey != nil {
+		return f.customEpochKey(f.name, 0)
+	}
+	return etcdutil.EpochPath(f.jobPath())
+}
+
+// taskKey is where the address of the given task is registered in etcd.
+func (f *framework) taskKey(taskID uint64) string {
+	if f.customTaskKey != nil {
+		return f.customTaskKey(f.name, taskID)
+	}
+	return etcdutil.TaskMasterPath(f.jobPath(), taskID)
+}
 
 // this will shutdown local node instead of global job.
 func (f *framework) stop() {
 	close(f.epochChan)
+	if f.nodeProducer != nil {
+		f.nodeProducer <- true
+	}
 }
 
 // When node call this on framework, it simply set epoch to exitEpoch,
 // All nodes will be notified of the epoch change and exit themselves.
-func (f *framework) ShutdownJob() {
-	if err := etcdutil.CASEpoch(f.etcdClient, f.name, f.epoch, exitEpoch); err != nil {
-		panic("TODO: we should do a set instead of CAS here.")
+func (f *framework) ShutdownJob() { f.ShutdownJobWithCause(meritop.NormalCompletion) }
+
+// The cause is kept as the job status, which is set before the epoch so that
+// every task finds it once it sees the exit epoch. Failures are logged, as
+// the job can't be told to shut down then.
+func (f *framework) ShutdownJobWithCause(cause meritop.ShutdownCause) {
+	status := strconv.Itoa(int(cause))
+	if err := f.backend.Put(gocontext.TODO(), etcdutil.JobStatusPath(f.jobPath()), status); err != nil {
+		f.log.Printf("task %d failed to set job status %d: %v", f.taskID, cause, err)
+		atomic.AddUint64(&f.errorCount, 1)
 	}
-	if err := etcdutil.SetJobStatus(f.etcdClient, f.name, 0); err != nil {
-		panic("SetJobStatus")
+	if err := f.setEpoch(exitEpoch); err != nil {
+		f.log.Printf("task %d failed to shut down job: %v", f.taskID, err)
+		atomic.AddUint64(&f.errorCount, 1)
 	}
 }
 
@@ -109,4 +798,12 @@ func (f *framework) GetLogger() *log.Logger { return f.log }
 
 func (f *framework) GetTaskID() uint64 { return f.taskID }
 
+func (f *framework) GetNodeID() string { return f.nodeID }
+
+func (f *framework) GetJobName() string { return f.name }
+
 func (f *framework) GetEpoch() uint64 { return f.epoch }
+
+func (f *framework) GetPeerAddress(taskID uint64) (string, error) {
+	return f.backend.Get(gocontext.TODO(), f.taskKey(taskID))
+}
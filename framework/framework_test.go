@@ -1,11 +1,30 @@
 package framework
 
 import (
+	"bytes"
+	gocontext "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/go-distributed/meritop"
@@ -13,6 +32,7 @@ import (
 	"github.com/go-distributed/meritop/example"
 	"github.com/go-distributed/meritop/framework/frameworkhttp"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
+	"github.com/go-distributed/meritop/pkg/metastore"
 )
 
 // TestRequestDataEpochMismatch creates a scenario where data request happened
@@ -42,17 +62,422 @@ func TestRequestDataEpochMismatch(t *testing.T) {
 	defer fw.ShutdownJob()
 	wg.Wait()
 
-	addr, err := etcdutil.GetAddress(fw.etcdClient, job, fw.GetTaskID())
+	addr, err := etcdutil.GetAddress(fw.etcdClient, fw.taskKey(fw.GetTaskID()))
 	if err != nil {
 		t.Fatalf("GetAddress failed: %v", err)
 	}
-	_, err = frameworkhttp.RequestData(addr, "req", 0, fw.GetTaskID(), 10, fw.GetLogger())
+	_, err = frameworkhttp.RequestData(gocontext.Background(), nil, addr, "req", 0, fw.GetTaskID(), 10, 0, fw.GetLogger())
 	// if err.Error() != "epoch mismatch" {
 	if err != frameworkhttp.ErrReqEpochMismatch {
 		t.Fatalf("error want = (epoch mismatch), but get = (%s)", err.Error())
 	}
 }
 
+// TestGetTopologyHealth starts one out of two tasks. The running one should be
+// reported as such while the other one has no node serving it.
+func TestGetTopologyHealth(t *testing.T) {
+	job := "TestGetTopologyHealth"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 2)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	var wg sync.WaitGroup
+	fw.SetTaskBuilder(&testableTaskBuilder{
+		setupLatch: &wg,
+	})
+	fw.SetTopology(example.NewTreeTopology(2, 2))
+	fw.SetNodeID("rack-1-host-2")
+	wg.Add(1)
+	go fw.Start()
+	defer fw.ShutdownJob()
+	wg.Wait()
+
+	report, err := fw.GetTopologyHealth()
+	if err != nil {
+		t.Fatalf("GetTopologyHealth failed: %v", err)
+	}
+	if len(report) != 2 {
+		t.Fatalf("len(report) = %d, want 2", len(report))
+	}
+	// Usage shows up once the first heartbeat with status is sent.
+	var usage map[uint64]uint64
+	for i := 0; i < 10 && len(usage) == 0; i++ {
+		if usage, err = fw.GetMemoryUsage(); err != nil {
+			t.Fatalf("GetMemoryUsage failed: %v", err)
+		}
+		time.Sleep(fw.heartbeatInterval() / 10)
+	}
+	if len(usage) != 1 || usage[fw.GetTaskID()] == 0 {
+		t.Errorf("memory usage = %v, want only task %d with non-zero usage", usage, fw.GetTaskID())
+	}
+	depths, err := fw.GetQueueDepths()
+	if err != nil {
+		t.Fatalf("GetQueueDepths failed: %v", err)
+	}
+	if d, ok := depths[fw.GetTaskID()]; len(depths) != 1 || !ok {
+		t.Errorf("queue depths = %v, want only task %d", depths, fw.GetTaskID())
+	} else if d.PendingIncoming != 0 || d.PendingOutgoing != 0 {
+		t.Errorf("queue depth = %+v, want no pending data requests", d)
+	}
+	for _, h := range report {
+		want := meritop.NodeDead
+		if h.TaskID == fw.GetTaskID() {
+			want = meritop.NodeRunning
+		}
+		if h.Status != want {
+			t.Errorf("task %d: status = %s, want %s", h.TaskID, h.Status, want)
+		}
+	}
+
+	// By now the heartbeat carries the node ID as well.
+	if report, err = fw.GetTopologyHealth(); err != nil {
+		t.Fatalf("GetTopologyHealth failed: %v", err)
+	}
+	for _, h := range report {
+		if h.TaskID == fw.GetTaskID() && h.NodeID != "rack-1-host-2" {
+			t.Errorf("task %d: node ID = %q, want rack-1-host-2", h.TaskID, h.NodeID)
+		}
+	}
+
+	fw.latencies.record(1-fw.GetTaskID(), 5*time.Millisecond)
+	dot, err := fw.DumpTopologyDOT()
+	if err != nil {
+		t.Fatalf("DumpTopologyDOT failed: %v", err)
+	}
+	for _, want := range []string{
+		`digraph "TestGetTopologyHealth" {`,
+		fmt.Sprintf(`%d [label="task %d\nrunning`, fw.GetTaskID(), fw.GetTaskID()),
+		fmt.Sprintf(`%d [label="task %d\ndead\nepoch 0", color=red];`, 1-fw.GetTaskID(), 1-fw.GetTaskID()),
+		`0 -> 1 [label="5ms"];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output %q doesn't contain %q", dot, want)
+		}
+	}
+}
+
+// TestEnsureTopologyConnected pings task 0 from task 1, where only one of them
+// is up.
+func TestEnsureTopologyConnected(t *testing.T) {
+	job := "TestEnsureTopologyConnected"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 2)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	var wg sync.WaitGroup
+	fw.SetTaskBuilder(&testableTaskBuilder{setupLatch: &wg})
+	fw.SetTopology(example.NewTreeTopology(2, 2))
+	wg.Add(1)
+	go fw.Start()
+	defer fw.ShutdownJob()
+	wg.Wait()
+
+	topo := example.NewTreeTopology(2, 2)
+	topo.SetTaskID(1)
+	client := etcd.NewClient(etcdURLs)
+	probe := &framework{
+		name:       job,
+		taskID:     1,
+		topology:   topo,
+		etcdClient: client,
+		backend:    metastore.NewEtcdBackend(client),
+		log:        log.New(ioutil.Discard, "", 0),
+	}
+	err := probe.EnsureTopologyConnected(500 * time.Millisecond)
+	if fw.GetTaskID() == 0 {
+		if err != nil {
+			t.Errorf("EnsureTopologyConnected = %v, want nil", err)
+		}
+		return
+	}
+	cerr, ok := err.(*meritop.ConnectivityError)
+	if !ok || !reflect.DeepEqual(cerr.Unreachable, []uint64{0}) {
+		t.Errorf("EnsureTopologyConnected = %v, want task 0 unreachable", err)
+	}
+}
+
+// blockingStore holds every Put until it is released.
+type blockingStore struct {
+	meritop.MetadataStore
+	puts    chan string
+	release chan struct{}
+}
+
+func (s *blockingStore) Put(ctx gocontext.Context, key, value string) error {
+	s.puts <- value
+	<-s.release
+	return s.MetadataStore.Put(ctx, key, value)
+}
+
+// blockingGetStore blocks Get until released.
+type blockingGetStore struct {
+	meritop.MetadataStore
+	gets    chan string
+	release chan struct{}
+}
+
+func (s *blockingGetStore) Get(ctx gocontext.Context, key string) (string, error) {
+	s.gets <- key
+	<-s.release
+	return s.MetadataStore.Get(ctx, key)
+}
+
+func TestMetaFlagConcurrency(t *testing.T) {
+	store := &blockingGetStore{
+		MetadataStore: metastore.NewInMemoryMetadataStore(),
+		gets:          make(chan string, 10),
+		release:       make(chan struct{}),
+	}
+	fw := &framework{
+		name:      "TestMetaFlagConcurrency",
+		metaStore: store,
+		log:       log.New(ioutil.Discard, "", 0),
+	}
+	fw.SetMetaFlagConcurrency(3)
+	fw.setupChannels()
+
+	done := make(chan struct{})
+	go func() {
+		fw.watchMeta(roleChild, []uint64{1, 2, 3, 4, 5})
+		close(done)
+	}()
+	for i := 0; i < 3; i++ {
+		<-store.gets
+	}
+	select {
+	case <-store.gets:
+		t.Fatalf("fourth watch set up while three are pending")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(store.release)
+	<-done
+	if len(fw.metaStops) != 5 {
+		t.Errorf("watches = %d, want 5", len(fw.metaStops))
+	}
+	fw.releaseEpochResource()
+}
+
+func TestNegativeMetaFlagConcurrency(t *testing.T) {
+	fw := &framework{
+		name:      "TestNegativeMetaFlagConcurrency",
+		metaStore: metastore.NewInMemoryMetadataStore(),
+		log:       log.New(ioutil.Discard, "", 0),
+	}
+	fw.SetMetaFlagConcurrency(-1)
+	fw.setupChannels()
+
+	fw.watchMeta(roleChild, []uint64{1, 2})
+	if len(fw.metaStops) != 2 {
+		t.Errorf("watches = %d, want 2", len(fw.metaStops))
+	}
+	fw.releaseEpochResource()
+}
+
+func TestMaxPendingMetaFlags(t *testing.T) {
+	store := &blockingStore{
+		MetadataStore: metastore.NewInMemoryMetadataStore(),
+		puts:          make(chan string, 10),
+		release:       make(chan struct{}),
+	}
+	fw := &framework{
+		name:      "TestMaxPendingMetaFlags",
+		metaStore: store,
+		log:       log.New(ioutil.Discard, "", 0),
+	}
+	fw.SetMaxPendingMetaFlags(2)
+	fw.setupChannels()
+
+	done := make(chan struct{}, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			fw.flagMetaToChild("meta", 0)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		<-store.puts
+	}
+	select {
+	case <-store.puts:
+		t.Fatalf("third flag written while two are pending")
+	case <-time.After(100 * time.Millisecond):
+	}
+	store.release <- struct{}{}
+	<-store.puts
+	close(store.release)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+}
+
+func TestWaitForAllTasks(t *testing.T) {
+	job := "TestWaitForAllTasks"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 2)
+	controller.Start()
+	defer controller.Stop()
+
+	probe := &framework{
+		name:       job,
+		etcdClient: etcd.NewClient(etcdURLs),
+		log:        log.New(ioutil.Discard, "", 0),
+	}
+	start := func() *framework {
+		fw := &framework{
+			name:     job,
+			etcdURLs: etcdURLs,
+			ln:       createListener(t),
+		}
+		var wg sync.WaitGroup
+		fw.SetTaskBuilder(&testableTaskBuilder{setupLatch: &wg})
+		fw.SetTopology(example.NewTreeTopology(2, 2))
+		wg.Add(1)
+		go fw.Start()
+		wg.Wait()
+		return fw
+	}
+
+	fw := start()
+	defer fw.ShutdownJob()
+	err := probe.WaitForAllTasks(300 * time.Millisecond)
+	merr, ok := err.(*meritop.MissingTasksError)
+	want := []uint64{1 - fw.GetTaskID()}
+	if !ok || !reflect.DeepEqual(merr.Missing, want) {
+		t.Errorf("WaitForAllTasks = %v, want tasks %v missing", err, want)
+	}
+
+	defer start().ShutdownJob()
+	if err := probe.WaitForAllTasks(time.Second); err != nil {
+		t.Errorf("WaitForAllTasks = %v, want nil", err)
+	}
+}
+
+func TestStartupWaitTimeout(t *testing.T) {
+	job := "TestStartupWaitTimeout"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 2)
+	controller.Start()
+	defer controller.Stop()
+
+	start := func() *framework {
+		fw := &framework{
+			name:     job,
+			etcdURLs: etcdURLs,
+			ln:       createListener(t),
+		}
+		var wg sync.WaitGroup
+		fw.SetTaskBuilder(&testableTaskBuilder{setupLatch: &wg})
+		fw.SetTopology(example.NewTreeTopology(2, 2))
+		fw.SetStartupWaitTimeout(10 * time.Second)
+		wg.Add(1)
+		go fw.Start()
+		wg.Wait()
+		return fw
+	}
+
+	fw := start()
+	defer fw.ShutdownJob()
+	time.Sleep(300 * time.Millisecond)
+	if atomic.LoadInt32(&fw.epochInProgress) != 0 {
+		t.Fatal("epoch 0 started before every task had a heartbeat")
+	}
+	defer start().ShutdownJob()
+	for i := 0; atomic.LoadInt32(&fw.epochInProgress) == 0; i++ {
+		if i == 100 {
+			t.Fatal("epoch 0 didn't start once every task had a heartbeat")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSharedEtcdPool(t *testing.T) {
+	job := "TestSharedEtcdPool"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 2)
+	controller.Start()
+	defer controller.Stop()
+
+	pool := etcdutil.NewPool()
+	defer pool.Close()
+	var wg sync.WaitGroup
+	fws := make([]*framework, 2)
+	for i := range fws {
+		fws[i] = &framework{
+			name:     job,
+			etcdURLs: etcdURLs,
+			ln:       createListener(t),
+		}
+		fws[i].SetTaskBuilder(&testableTaskBuilder{setupLatch: &wg})
+		fws[i].SetTopology(example.NewTreeTopology(2, 2))
+		fws[i].UseSharedEtcdPool(pool)
+		fws[i].SetEtcdRequestTimeout(5 * time.Second)
+		wg.Add(1)
+		go fws[i].Start()
+	}
+	wg.Wait()
+	defer fws[0].ShutdownJob()
+	if fws[0].GetTaskID() == fws[1].GetTaskID() {
+		t.Errorf("both frameworks took task %d", fws[0].GetTaskID())
+	}
+}
+
+func TestHeartbeatHandler(t *testing.T) {
+	job := "TestHeartbeatHandler"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	statusChan := make(chan meritop.HeartbeatStatus, 10)
+	fw.SetHeartbeatHandler(func(taskID uint64, s meritop.HeartbeatStatus) {
+		if taskID == 0 {
+			statusChan <- s
+		}
+	})
+	fw.SetTaskBuilder(&testableTaskBuilder{})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	go fw.Start()
+	defer fw.ShutdownJob()
+
+	select {
+	case s := <-statusChan:
+		if s.MemoryUsage == 0 {
+			t.Errorf("MemoryUsage = 0, want > 0")
+		}
+	case <-time.After(5 * fw.heartbeatInterval()):
+		t.Fatal("heartbeat handler wasn't called")
+	}
+}
+
 // TestFrameworkFlagMetaReady and TestFrameworkDataRequest test basic workflows of
 // framework impl. It uses a scenario with two nodes: 0 as parent, 1 as child.
 // The basic idea is that when parent tries to talk to child and vice versa,
@@ -60,6 +485,15 @@ func TestRequestDataEpochMismatch(t *testing.T) {
 // Here we have implemented a helper user task to capture those data, test if
 // it's passed from framework correctly and unmodified.
 func TestFrameworkFlagMetaReady(t *testing.T) {
+	testFrameworkFlagMetaReady(t, nil)
+}
+
+// Both tasks run in this process, so they can share an in-memory store.
+func TestFrameworkFlagMetaReadyInMemoryStore(t *testing.T) {
+	testFrameworkFlagMetaReady(t, metastore.NewInMemoryMetadataStore())
+}
+
+func testFrameworkFlagMetaReady(t *testing.T, store meritop.MetadataStore) {
 	appName := "framework_test_flagmetaready"
 	// launch testing etcd server
 	m := etcdutil.MustNewMember(t, appName)
@@ -80,14 +514,16 @@ func TestFrameworkFlagMetaReady(t *testing.T) {
 	// simulate two tasks on two nodes -- 0 and 1
 	// 0 is parent, 1 is child
 	f0 := &framework{
-		name:     appName,
-		etcdURLs: []string{url},
-		ln:       createListener(t),
+		name:      appName,
+		etcdURLs:  []string{url},
+		ln:        createListener(t),
+		metaStore: store,
 	}
 	f1 := &framework{
-		name:     appName,
-		etcdURLs: []string{url},
-		ln:       createListener(t),
+		name:      appName,
+		etcdURLs:  []string{url},
+		ln:        createListener(t),
+		metaStore: store,
 	}
 
 	var wg sync.WaitGroup
@@ -240,74 +676,2703 @@ func TestFrameworkDataRequest(t *testing.T) {
 	}
 }
 
-type tDataBundle struct {
-	id   uint64
-	meta string
-	req  string
-	resp []byte
-}
-
-type testableTaskBuilder struct {
-	dataMap    map[string][]byte
-	cDataChan  chan *tDataBundle
-	pDataChan  chan *tDataBundle
-	setupLatch *sync.WaitGroup
-}
+func TestSharedMemoryOptimization(t *testing.T) {
+	job := "TestSharedMemoryOptimization"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	client := etcd.NewClient(etcdURLs)
+	controller := controller.New(job, client, 2)
+	controller.Start()
+	defer controller.Stop()
 
-func (b *testableTaskBuilder) GetTask(taskID uint64) meritop.Task {
-	switch taskID {
-	case 0:
-		return &testableTask{dataMap: b.dataMap, dataChan: b.cDataChan,
-			setupLatch: b.setupLatch}
-	case 1:
-		return &testableTask{dataMap: b.dataMap, dataChan: b.pDataChan,
-			setupLatch: b.setupLatch}
-	default:
-		panic("unimplemented")
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	var wg sync.WaitGroup
+	taskBuilder := &testableTaskBuilder{
+		dataMap:    map[string][]byte{"gradient": {4, 5, 6}},
+		cDataChan:  cDataChan,
+		pDataChan:  pDataChan,
+		setupLatch: &wg,
 	}
-}
-
-type testableTask struct {
-	id         uint64
-	framework  meritop.Framework
-	setupLatch *sync.WaitGroup
-	// dataMap will be used to serve data according to request
-	dataMap map[string][]byte
-
-	// This channel is used to convey data passed from framework back to the main
-	// thread, for checking. Thus it's initialized and passed in from outside.
-	//
-	// The basic idea is that there are only two nodes -- one parent and one child.
-	// When this channel is for parent, it passes information from child.
-	dataChan chan *tDataBundle
-}
+	f0 := &framework{name: job, etcdURLs: etcdURLs, ln: createListener(t)}
+	f1 := &framework{name: job, etcdURLs: etcdURLs, ln: createListener(t)}
+	for _, f := range []*framework{f0, f1} {
+		f.SetTaskBuilder(taskBuilder)
+		f.SetTopology(example.NewTreeTopology(2, 2))
+		f.SetSharedMemoryOptimization(true)
+	}
+	wg.Add(2)
+	go f0.Start()
+	go f1.Start()
+	wg.Wait()
+	if f0.GetTaskID() != 0 {
+		f0, f1 = f1, f0
+	}
+	defer f0.ShutdownJob()
 
-func (t *testableTask) Init(taskID uint64, framework meritop.Framework) {
-	t.id = taskID
-	t.framework = framework
-	if t.setupLatch != nil {
-		t.setupLatch.Done()
+	// Requests to task 1 must not go over the network.
+	if _, err := client.Set(f0.taskKey(1), "127.0.0.1:1", 0); err != nil {
+		t.Fatalf("etcd.Set failed: %v", err)
+	}
+	f0.dataRequest(1, "gradient", 0)
+	for _, c := range []chan *tDataBundle{pDataChan, cDataChan} {
+		select {
+		case data := <-c:
+			if data.resp != nil && !bytes.Equal(data.resp, []byte{4, 5, 6}) {
+				t.Errorf("response = %v, want [4 5 6]", data.resp)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("request didn't reach task 1 or the response didn't come back")
+		}
 	}
-}
-func (t *testableTask) Exit()                                      {}
-func (t *testableTask) SetEpoch(ctx meritop.Context, epoch uint64) {}
 
-func (t *testableTask) ParentMetaReady(ctx meritop.Context, fromID uint64, meta string) {
-	if t.dataChan != nil {
-		t.dataChan <- &tDataBundle{fromID, meta, "", nil}
+	store := f0.GetSharedMemoryStore()
+	store.Put("weights", []byte{1})
+	if d, ok := f1.GetSharedMemoryStore().Get("weights"); !ok || !bytes.Equal(d, []byte{1}) {
+		t.Errorf("Get(weights) = %v, %v, want [1], true", d, ok)
 	}
 }
 
-func (t *testableTask) ChildMetaReady(ctx meritop.Context, fromID uint64, meta string) {
-	t.ParentMetaReady(ctx, fromID, meta)
-}
+func TestFrameworkDataPush(t *testing.T) {
+	appName := "framework_test_datapush"
+	m := etcdutil.StartNewEtcdServer(t, appName)
+	defer m.Terminate(t)
+	url := m.URL()
 
-func (t *testableTask) ServeAsParent(fromID uint64, req string) []byte {
-	if t.dataChan != nil {
-		t.dataChan <- &tDataBundle{fromID, "", req, nil}
+	ctl := controller.New(appName, etcd.NewClient([]string{url}), 2)
+	if err := ctl.InitEtcdLayout(); err != nil {
+		t.Fatalf("initEtcdLayout failed: %v", err)
 	}
-	return t.dataMap[req]
-}
+	defer ctl.DestroyEtcdLayout()
+
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	f0 := &framework{
+		name:     appName,
+		etcdURLs: []string{url},
+		ln:       createListener(t),
+	}
+	f1 := &framework{
+		name:     appName,
+		etcdURLs: []string{url},
+		ln:       createListener(t),
+	}
+	var wg sync.WaitGroup
+	taskBuilder := &testableTaskBuilder{
+		cDataChan:  cDataChan,
+		pDataChan:  pDataChan,
+		setupLatch: &wg,
+	}
+	f0.SetTaskBuilder(taskBuilder)
+	f0.SetTopology(example.NewTreeTopology(2, 2))
+	f1.SetTaskBuilder(taskBuilder)
+	f1.SetTopology(example.NewTreeTopology(2, 2))
+
+	taskBuilder.setupLatch.Add(2)
+	go f0.Start()
+	go f1.Start()
+	taskBuilder.setupLatch.Wait()
+	if f0.GetTaskID() != 0 {
+		f0, f1 = f1, f0
+	}
+	defer f0.ShutdownJob()
+
+	tests := [][]byte{
+		[]byte("gradient"),
+		{1, 2, 3},
+	}
+	for i, tt := range tests {
+		// 1: F#PushDataToParent -> 0: T#ParentPushReceived
+		f1.pushDataToParent(tt, 0)
+		data := <-cDataChan
+		expected := &tDataBundle{1, "", "", tt}
+		if !reflect.DeepEqual(data, expected) {
+			t.Errorf("#%d: data bundle want = %v, get = %v", i, expected, data)
+		}
+	}
+}
+
+func TestFrameworkMaxMessageSize(t *testing.T) {
+	appName := "framework_test_maxmessagesize"
+	m := etcdutil.StartNewEtcdServer(t, appName)
+	defer m.Terminate(t)
+	url := m.URL()
+
+	ctl := controller.New(appName, etcd.NewClient([]string{url}), 2)
+	if err := ctl.InitEtcdLayout(); err != nil {
+		t.Fatalf("initEtcdLayout failed: %v", err)
+	}
+	defer ctl.DestroyEtcdLayout()
+
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	f0 := &framework{
+		name:     appName,
+		etcdURLs: []string{url},
+		ln:       createListener(t),
+	}
+	f1 := &framework{
+		name:     appName,
+		etcdURLs: []string{url},
+		ln:       createListener(t),
+	}
+	var wg sync.WaitGroup
+	taskBuilder := &testableTaskBuilder{
+		dataMap:    map[string][]byte{"big": []byte("too large")},
+		cDataChan:  cDataChan,
+		pDataChan:  pDataChan,
+		setupLatch: &wg,
+	}
+	f0.SetTaskBuilder(taskBuilder)
+	f0.SetTopology(example.NewTreeTopology(2, 2))
+	f0.SetMaxMessageSize(4)
+	f1.SetTaskBuilder(taskBuilder)
+	f1.SetTopology(example.NewTreeTopology(2, 2))
+	f1.SetMaxMessageSize(4)
+
+	taskBuilder.setupLatch.Add(2)
+	go f0.Start()
+	go f1.Start()
+	taskBuilder.setupLatch.Wait()
+	if f0.GetTaskID() != 0 {
+		f0, f1 = f1, f0
+	}
+	defer f0.ShutdownJob()
+
+	// 0: F#DataRequest -> 1: T#ServeAsChild -> 0: T#DataRequestFailed
+	f0.dataRequest(1, "big", 0)
+	<-pDataChan
+	data := <-cDataChan
+	expected := &tDataBundle{1, "failed", "big", nil}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+}
+
+func TestSimpleTaskBuilderValidate(t *testing.T) {
+	valid := SimpleTaskBuilder{
+		GDataChan:          make(chan int32),
+		FinishChan:         make(chan struct{}),
+		NumberOfIterations: 1,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+
+	noIterations, noData, noFinish := valid, valid, valid
+	noIterations.NumberOfIterations = 0
+	noData.GDataChan = nil
+	noFinish.FinishChan = nil
+	for i, tb := range []SimpleTaskBuilder{noIterations, noData, noFinish} {
+		if err := tb.Validate(); err == nil {
+			t.Errorf("#%d: Validate() = nil, want error", i)
+		}
+	}
+}
+
+type metric struct {
+	name   string
+	value  float64
+	labels map[string]string
+}
+
+type testableMetricsSink struct {
+	metrics []metric
+}
+
+func (s *testableMetricsSink) EmitMetric(name string, value float64, labels map[string]string) {
+	s.metrics = append(s.metrics, metric{name, value, labels})
+}
+
+func TestContextEmitMetric(t *testing.T) {
+	sink := &testableMetricsSink{}
+	fw := &framework{}
+	fw.SetMetricsSink(sink)
+	labels := map[string]string{"task": "0"}
+	ctx := &context{f: fw}
+	ctx.EmitMetric("loss", 0.5, labels)
+
+	expected := []metric{{"meritop_user_loss", 0.5, labels}}
+	if !reflect.DeepEqual(sink.metrics, expected) {
+		t.Errorf("metrics = %v, want %v", sink.metrics, expected)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	environ := []string{
+		"MERITOP_FAILLEVEL=100",
+		"MERITOP_SetEpoch=fail",
+		"MERITOP_=ignored",
+		"PATH=/bin",
+	}
+	config := configFromEnv("MERITOP_", environ)
+	expected := map[string]string{"faillevel": "100", "setepoch": "fail"}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("config = %v, want %v", config, expected)
+	}
+
+	tb := &SimpleTaskBuilder{}
+	config["bogus"] = "1"
+	if unknown := tb.SetConfig(config); !reflect.DeepEqual(unknown, []string{"bogus"}) {
+		t.Errorf("unknown = %v, want [bogus]", unknown)
+	}
+	if tb.MasterConfig["SetEpoch"] != "fail" || tb.SlaveConfig["faillevel"] != "100" {
+		t.Errorf("master config = %v, slave config = %v", tb.MasterConfig, tb.SlaveConfig)
+	}
+}
+
+func TestRequestDedup(t *testing.T) {
+	d := newRequestDedup(time.Hour)
+	k := requestKey{1, 0, "req"}
+	if !d.start(k) {
+		t.Fatal("start() = false for a new request")
+	}
+	if d.start(k) {
+		t.Error("start() = true for a request in flight")
+	}
+	if !d.deliver(k) {
+		t.Fatal("deliver() = false for the first response")
+	}
+	if d.deliver(k) || d.start(k) {
+		t.Error("duplicate delivered or sent again within the window")
+	}
+
+	// A failed request can be sent again.
+	k2 := requestKey{2, 0, "req"}
+	d.start(k2)
+	d.abort(k2)
+	if !d.start(k2) {
+		t.Error("start() = false after abort")
+	}
+
+	// Requests of an epoch started over can be sent again.
+	d.forgetEpoch(k.epoch)
+	if !d.start(k) {
+		t.Error("start() = false after the epoch was forgotten")
+	}
+	if !d.deliver(k) {
+		t.Error("deliver() = false for a request sent again")
+	}
+
+	d = newRequestDedup(0)
+	d.deliver(k)
+	time.Sleep(time.Millisecond)
+	if !d.start(k) {
+		t.Error("start() = false after the window passed")
+	}
+}
+
+func TestAbortEpoch(t *testing.T) {
+	job := "TestAbortEpoch"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	epochChan := make(chan uint64, 10)
+	abortChan := make(chan string, 10)
+	retryChan := make(chan uint64, 10)
+	fw.SetTaskBuilder(&testableTaskBuilder{epochChan: epochChan, abortChan: abortChan, retryChan: retryChan})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	go fw.Start()
+	defer fw.ShutdownJob()
+
+	wait := func(what string) {
+		select {
+		case epoch := <-epochChan:
+			if epoch != 0 {
+				t.Errorf("%s: epoch set = %d, want 0", what, epoch)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: SetEpoch wasn't called", what)
+		}
+	}
+	wait("start")
+	// Aborts of other epochs are ignored.
+	fw.abortEpoch(5, "stale")
+	fw.abortEpoch(0, "bad data")
+	select {
+	case reason := <-abortChan:
+		if reason != "bad data" {
+			t.Errorf("abort reason = %q, want %q", reason, "bad data")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("EpochAborted wasn't called")
+	}
+	if attempt := <-retryChan; attempt != 1 {
+		t.Errorf("attempt = %d, want 1", attempt)
+	}
+	wait("restart")
+	// A late abort of the first attempt doesn't start the epoch over again.
+	late := "0-0-bad data late"
+	if err := fw.metaStore.Put(gocontext.TODO(), etcdutil.AbortPath(fw.jobPath()), late); err != nil {
+		t.Fatalf("Put(%q) failed: %v", late, err)
+	}
+	select {
+	case reason := <-abortChan:
+		t.Errorf("EpochAborted(%q) called for a late abort", reason)
+	case <-time.After(200 * time.Millisecond):
+	}
+	fw.abortEpoch(0, "bad data again")
+	<-abortChan
+	if attempt := <-retryChan; attempt != 2 {
+		t.Errorf("attempt = %d, want 2", attempt)
+	}
+	wait("second restart")
+}
+
+func TestSendCommandToTask(t *testing.T) {
+	job := "TestSendCommandToTask"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	epochChan := make(chan uint64, 10)
+	cmdChan := make(chan meritop.Command, 10)
+	fw.SetTaskBuilder(&testableTaskBuilder{epochChan: epochChan, cmdChan: cmdChan})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	go fw.Start()
+	defer fw.ShutdownJob()
+
+	select {
+	case <-epochChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetEpoch wasn't called")
+	}
+	want := meritop.Command{Type: "checkpoint", Args: map[string]string{"dir": "/tmp"}}
+	if err := fw.SendCommandToTask(0, want); err != nil {
+		t.Fatalf("SendCommandToTask failed: %v", err)
+	}
+	select {
+	case cmd := <-cmdChan:
+		if !reflect.DeepEqual(cmd, want) {
+			t.Errorf("command = %+v, want %+v", cmd, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleCommand wasn't called")
+	}
+}
+
+func TestEpochSkipPolicy(t *testing.T) {
+	job := "TestEpochSkipPolicy"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	epochChan := make(chan uint64, 10)
+	fw.SetTaskBuilder(&testableTaskBuilder{epochChan: epochChan})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	// skip epochs 0 to 2
+	fw.SetEpochSkipPolicy(func(epoch uint64) bool { return epoch < 3 })
+	go fw.Start()
+	defer fw.ShutdownJob()
+
+	select {
+	case epoch := <-epochChan:
+		if epoch != 3 {
+			t.Errorf("first epoch set = %d, want 3", epoch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetEpoch wasn't called")
+	}
+}
+
+func TestFrameworkWithContext(t *testing.T) {
+	job := "TestFrameworkWithContext"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	var wg sync.WaitGroup
+	fw.WithContext(ctx).SetTaskBuilder(&testableTaskBuilder{setupLatch: &wg})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	wg.Add(1)
+	stopped := make(chan struct{})
+	beforeStart := time.Now()
+	go func() {
+		fw.Start()
+		close(stopped)
+	}()
+	wg.Wait()
+	if fw.GetJobStartTime().Before(beforeStart) {
+		t.Errorf("job start time %v is before Start was called at %v", fw.GetJobStartTime(), beforeStart)
+	}
+	if elapsed := fw.GetJobElapsedTime(); elapsed <= 0 || elapsed > time.Since(beforeStart) {
+		t.Errorf("job elapsed time = %v, want in (0, %v]", elapsed, time.Since(beforeStart))
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("framework didn't stop after its context was cancelled")
+	}
+}
+
+func TestQuorumMet(t *testing.T) {
+	topo := example.NewTreeTopology(3, 13)
+	topo.SetTaskID(0)
+	fw := &framework{topology: topo}
+
+	tests := []struct {
+		quorum    float64
+		responses int
+		met       bool
+	}{
+		{0, 2, false}, // defaults to all children
+		{0, 3, true},
+		{1, 3, true},
+		{0.5, 1, false},
+		{0.5, 2, true},
+	}
+	for i, tt := range tests {
+		fw.SetAggregationQuorum(tt.quorum)
+		fw.childResponses = map[uint64]map[uint64][]byte{0: {}}
+		for id := 1; id <= tt.responses; id++ {
+			fw.childResponses[0][uint64(id)] = nil
+		}
+		if met := fw.createContext().IsQuorumMet(); met != tt.met {
+			t.Errorf("#%d: IsQuorumMet() = %v, want %v", i, met, tt.met)
+		}
+	}
+}
+
+func TestContextEpochStartTime(t *testing.T) {
+	start := time.Now()
+	fw := &framework{topology: example.NewTreeTopology(2, 1), epochStartTime: start}
+	ctx := fw.createContext()
+	// The next epoch starting doesn't change contexts of this one.
+	fw.epochStartTime = start.Add(time.Second)
+	if got := ctx.GetCurrentEpochStartTime(); !got.Equal(start) {
+		t.Errorf("GetCurrentEpochStartTime() = %v, want %v", got, start)
+	}
+}
+
+func TestPayloadCompression(t *testing.T) {
+	small := []byte("small")
+	large := bytes.Repeat([]byte("large"), 1000)
+	tests := []struct {
+		compression bool
+		threshold   int
+		data        []byte
+		compressed  bool
+	}{
+		{false, 0, large, false},
+		{true, 0, small, false},
+		{true, 0, large, true},
+		{true, len(large) + 1, large, false},
+		{true, 1, small, true},
+	}
+	for i, tt := range tests {
+		fw := &framework{}
+		fw.SetCompression(tt.compression)
+		fw.SetCompressionThreshold(tt.threshold)
+		p := fw.encodePayload(tt.data)
+		if compressed := p[0] == payloadGzip; compressed != tt.compressed {
+			t.Errorf("#%d: compressed = %v, want %v", i, compressed, tt.compressed)
+		}
+		data, err := decodePayload(p)
+		if err != nil {
+			t.Fatalf("#%d: decodePayload failed: %v", i, err)
+		}
+		if !bytes.Equal(data, tt.data) {
+			t.Errorf("#%d: decoded %d bytes, want the %d encoded", i, len(data), len(tt.data))
+		}
+	}
+	if _, err := decodePayload([]byte{7, 1, 2}); err == nil {
+		t.Errorf("decodePayload of unknown encoding = nil error")
+	}
+}
+
+func TestWithinStaleness(t *testing.T) {
+	fw := &framework{epoch: 5}
+	tests := []struct {
+		window uint64
+		epoch  uint64
+		within bool
+	}{
+		{0, 5, true},
+		{0, 4, false},
+		{2, 3, true},
+		{2, 2, false},
+		{2, 6, false},
+		{10, 0, true},
+	}
+	for i, tt := range tests {
+		fw.SetStalenessWindow(tt.window)
+		if within := fw.withinStaleness(tt.epoch); within != tt.within {
+			t.Errorf("#%d: withinStaleness(%d) = %v, want %v", i, tt.epoch, within, tt.within)
+		}
+	}
+}
+
+func TestParallelEpochs(t *testing.T) {
+	fw := &framework{
+		epoch:          5,
+		childResponses: map[uint64]map[uint64][]byte{2: {}, 3: {}, 4: {}, 5: {}},
+	}
+	if fw.isActiveEpoch(4) {
+		t.Errorf("epoch 4 is active by default")
+	}
+	fw.SetMaxParallelEpochs(3)
+	for epoch, want := range []bool{false, false, false, true, true, true, false} {
+		if active := fw.isActiveEpoch(uint64(epoch)); active != want {
+			t.Errorf("isActiveEpoch(%d) = %v, want %v", epoch, active, want)
+		}
+	}
+	if e := fw.slotEpoch(4); e != 4 {
+		t.Errorf("slotEpoch(4) = %d, want 4", e)
+	}
+	fw.SetStalenessWindow(3)
+	if e := fw.slotEpoch(2); e != 5 {
+		t.Errorf("slotEpoch(2) = %d, want 5", e)
+	}
+	fw.pruneChildResponses()
+	if len(fw.childResponses) != 3 || fw.childResponses[2] != nil {
+		t.Errorf("slots after pruning = %v, want epochs 3 to 5", fw.childResponses)
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCheckpointRoundTrip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fw := &framework{taskID: 0, log: log.New(ioutil.Discard, "", 0)}
+	fw.SetCheckpointStore(NewDirCheckpointStore(dir))
+	fw.SetCheckpointInterval(2)
+	master := &dummyMaster{framework: fw}
+	fw.task = master
+	for epoch := uint64(0); epoch < 6; epoch++ {
+		master.param = &dummyData{Value: int32(10 * epoch)}
+		fw.checkpointEpoch(epoch)
+	}
+
+	tests := []struct {
+		epoch uint64
+		value int32
+	}{
+		{5, 40},
+		{3, 20},
+		{0, 0},
+	}
+	for i, tt := range tests {
+		restored := &dummyMaster{framework: fw}
+		fw2 := &framework{
+			taskID:          0,
+			epoch:           tt.epoch,
+			task:            restored,
+			checkpointStore: NewDirCheckpointStore(dir),
+			log:             log.New(ioutil.Discard, "", 0),
+		}
+		fw2.restoreFromCheckpoint()
+		if restored.param == nil || restored.param.Value != tt.value {
+			t.Errorf("#%d: restored param = %v, want value %d", i, restored.param, tt.value)
+		}
+	}
+	if _, _, err := NewDirCheckpointStore(dir).Load(1, 5); err != meritop.ErrNoCheckpoint {
+		t.Errorf("Load of task without checkpoints = %v, want %v", err, meritop.ErrNoCheckpoint)
+	}
+}
+
+func TestExportImportCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExportImportCheckpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewDirCheckpointStore(filepath.Join(dir, "old"))
+	store.Save(0, 2, []byte("task 0 at 2"))
+	store.Save(0, 4, []byte("task 0 at 4"))
+	store.Save(1, 4, []byte("task 1 at 4"))
+	store.Save(2, 6, []byte("task 2 at 6"))
+	fw := &framework{checkpointStore: store}
+	fw.setEpochOfLoop(5)
+	fw.SetTopology(example.NewTreeTopology(2, 3))
+	var buf bytes.Buffer
+	if err := fw.ExportCheckpoint(&buf); err != nil {
+		t.Fatalf("ExportCheckpoint failed: %v", err)
+	}
+
+	// The new job has a single task instead of three.
+	newStore := NewDirCheckpointStore(filepath.Join(dir, "new"))
+	fw2 := &framework{checkpointStore: newStore}
+	fw2.SetTopology(example.NewTreeTopology(2, 1))
+	if err := fw2.ImportCheckpoint(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("ImportCheckpoint failed: %v", err)
+	}
+	if !fw2.replaySnapshot || fw2.replayEpoch != 4 {
+		t.Errorf("job resumes at epoch %d (%v), want 4", fw2.replayEpoch, fw2.replaySnapshot)
+	}
+	if n := fw2.topology.(meritop.SizedTopology).GetTaskCount(); n != 1 {
+		t.Errorf("imported topology has %d tasks, want 1", n)
+	}
+	if e, data, err := newStore.Load(0, 4); err != nil || e != 4 || string(data) != "task 0 at 4" {
+		t.Errorf("Load(0, 4) = %d, %q, %v, want checkpoint of task 0 at 4", e, data, err)
+	}
+	if _, _, err := newStore.Load(1, 4); err != meritop.ErrNoCheckpoint {
+		t.Errorf("Load of a task beyond the new job = %v, want %v", err, meritop.ErrNoCheckpoint)
+	}
+
+	if err := fw2.ImportCheckpoint(strings.NewReader("not a snapshot")); err == nil {
+		t.Errorf("ImportCheckpoint of garbage = nil, want error")
+	}
+	// A truncated snapshot claiming a huge topology fails without
+	// allocating it.
+	truncated := snapshotMagic + "\x00\x00\x00\x01" + strings.Repeat("\x00", 8) + "\xff\xff\xff\xff{}"
+	if err := fw2.ImportCheckpoint(strings.NewReader(truncated)); err != io.ErrUnexpectedEOF {
+		t.Errorf("ImportCheckpoint of truncated snapshot = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestAuditLog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := etcdutil.StartNewEtcdServer(t, "TestAuditLog")
+	defer m.Terminate(t)
+	client := etcd.NewClient([]string{m.URL()})
+
+	sum := sha256.Sum256([]byte("gradient"))
+	want := []meritop.AuditRecord{
+		{Epoch: 1, TaskID: 2, Event: meritop.AuditEpochStarted},
+		{Epoch: 1, TaskID: 2, Event: meritop.AuditDataReceived, PeerID: 5, GradientHash: hex.EncodeToString(sum[:])},
+	}
+	stores := []struct {
+		name string
+		meritop.AuditStore
+		read func() []string
+	}{
+		{"file", NewFileAuditStore(filepath.Join(dir, "audit.log")), func() []string {
+			b, _ := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+			return strings.Split(strings.TrimSpace(string(b)), "\n")
+		}},
+		{"etcd", NewEtcdAuditStore(client, "/audit"), func() []string {
+			resp, err := client.Get("/audit", true, false)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			var lines []string
+			for _, n := range resp.Node.Nodes {
+				lines = append(lines, n.Value)
+			}
+			return lines
+		}},
+	}
+	for _, st := range stores {
+		fw := &framework{taskID: 2, log: log.New(ioutil.Discard, "", 0)}
+		fw.EnableEpochAuditLog(st.AuditStore)
+		fw.auditEpoch(meritop.AuditEpochStarted, 1)
+		fw.auditData(meritop.AuditDataReceived, 1, 5, []byte("gradient"))
+
+		lines := st.read()
+		if len(lines) != len(want) {
+			t.Fatalf("%s: records = %q, want %d", st.name, lines, len(want))
+		}
+		for i, line := range lines {
+			var get meritop.AuditRecord
+			if err := json.Unmarshal([]byte(line), &get); err != nil {
+				t.Fatalf("%s: bad record %q: %v", st.name, line, err)
+			}
+			if get.Time.IsZero() {
+				t.Errorf("%s #%d: record has no time", st.name, i)
+			}
+			get.Time = time.Time{}
+			if get != want[i] {
+				t.Errorf("%s #%d: record = %+v, want %+v", st.name, i, get, want[i])
+			}
+		}
+	}
+}
+
+// dataGetterFunc serves data requests by calling itself.
+type dataGetterFunc func(taskID, epoch uint64, req string) ([]byte, error)
+
+func (fn dataGetterFunc) GetTaskData(taskID, epoch uint64, req string) ([]byte, error) {
+	return fn(taskID, epoch, req)
+}
+
+func TestDataRequestBatching(t *testing.T) {
+	job := "TestDataRequestBatching"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	client := etcd.NewClient([]string{m.URL()})
+
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+	getter := dataGetterFunc(func(taskID, epoch uint64, req string) ([]byte, error) {
+		return append([]byte{payloadRaw}, req...), nil
+	})
+	batchHandler := frameworkhttp.NewDataBatchHandler(log.New(ioutil.Discard, "", 0), getter)
+	mux := http.NewServeMux()
+	mux.Handle(frameworkhttp.DataRequestPrefix, frameworkhttp.NewDataRequestHandler(log.New(ioutil.Discard, "", 0), getter))
+	mux.HandleFunc(frameworkhttp.DataBatchPrefix, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var reqs []string
+		json.Unmarshal(body, &reqs)
+		mu.Lock()
+		batches = append(batches, reqs)
+		mu.Unlock()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		batchHandler.ServeHTTP(w, r)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	fw := &framework{
+		name:       job,
+		etcdClient: client,
+		backend:    metastore.NewEtcdBackend(client),
+		log:        log.New(ioutil.Discard, "", 0),
+	}
+	if _, err := client.Set(fw.taskKey(1), strings.TrimPrefix(ts.URL, "http://"), 0); err != nil {
+		t.Fatal(err)
+	}
+	fw.SetDataRequestBatchSize(3)
+	fw.setupChannels()
+	reqs := []string{"a", "b", "c", "d"}
+	for _, req := range reqs[1:] {
+		fw.dataRequest(1, req, 0)
+	}
+	fw.sendRequests(&dataRequest{taskID: 1, epoch: 0, req: reqs[0]})
+
+	got := make(map[string]string)
+	for range reqs {
+		select {
+		case resp := <-fw.dataRespChan:
+			got[resp.Req] = string(resp.Data)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("responses received = %v, want %d", got, len(reqs))
+		}
+	}
+	for _, req := range reqs {
+		if got[req] != req {
+			t.Errorf("response to %q = %q, want %q", req, got[req], req)
+		}
+	}
+	// The last request didn't fit in the batch and went alone.
+	if want := [][]string{{"a", "b", "c"}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+}
+
+func TestBatchAndPushHandlersRejectBadQuery(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	handlers := map[string]http.Handler{
+		frameworkhttp.DataBatchPrefix: frameworkhttp.NewDataBatchHandler(logger, nil),
+		frameworkhttp.DataPushPrefix:  frameworkhttp.NewDataPushHandler(logger, nil, 0),
+	}
+	for prefix, h := range handlers {
+		for _, query := range []string{"taskID=x&epoch=1", "taskID=1&epoch=x"} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("POST", prefix+"?"+query, strings.NewReader("[]")))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s?%s: status = %d, want %d", prefix, query, w.Code, http.StatusBadRequest)
+			}
+		}
+	}
+}
+
+// dataPusherFunc takes pushed data by calling itself.
+type dataPusherFunc func(taskID, epoch uint64, data []byte) error
+
+func (fn dataPusherFunc) PushTaskData(taskID, epoch uint64, data []byte) error {
+	return fn(taskID, epoch, data)
+}
+
+func TestPushHandlerRejectsOversizedData(t *testing.T) {
+	pusher := dataPusherFunc(func(taskID, epoch uint64, data []byte) error {
+		t.Errorf("pushed %d bytes, want them rejected", len(data))
+		return nil
+	})
+	h := frameworkhttp.NewDataPushHandler(log.New(ioutil.Discard, "", 0), pusher, 8)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	resp, err := http.Post(s.URL+frameworkhttp.DataPushPrefix+"?taskID=1&epoch=1",
+		"application/octet-stream", strings.NewReader(strings.Repeat("x", 9)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = frameworkhttp.PushData(frameworkhttp.NewClient(nil), u.Host, 1, 1, make([]byte, 9))
+	if err != frameworkhttp.ErrMessageTooLarge {
+		t.Errorf("PushData() = %v, want %v", err, frameworkhttp.ErrMessageTooLarge)
+	}
+}
+
+func TestBatchRequestDataErrors(t *testing.T) {
+	boom := errors.New("boom")
+	var getErr error
+	getter := dataGetterFunc(func(taskID, epoch uint64, req string) ([]byte, error) {
+		return nil, getErr
+	})
+	logger := log.New(ioutil.Discard, "", 0)
+	s := httptest.NewServer(frameworkhttp.NewDataBatchHandler(logger, getter))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getErr = frameworkhttp.ErrReqEpochMismatch
+	_, err = frameworkhttp.BatchRequestData(gocontext.Background(), frameworkhttp.NewClient(nil),
+		u.Host, []string{"a"}, 1, 2, 0, 0, logger)
+	if err != frameworkhttp.ErrReqEpochMismatch {
+		t.Errorf("BatchRequestData() = %v, want %v", err, frameworkhttp.ErrReqEpochMismatch)
+	}
+
+	getErr = boom
+	_, err = frameworkhttp.BatchRequestData(gocontext.Background(), frameworkhttp.NewClient(nil),
+		u.Host, []string{"a"}, 1, 2, 0, 0, logger)
+	if err == nil || err == frameworkhttp.ErrReqEpochMismatch || !strings.Contains(err.Error(), boom.Error()) {
+		t.Errorf("BatchRequestData() = %v, want the error of the handler", err)
+	}
+}
+
+func TestBatchFailureCountedOnce(t *testing.T) {
+	fw := &framework{log: log.New(ioutil.Discard, "", 0)}
+	batch := []*dataRequest{{taskID: 1, req: "a"}, {taskID: 1, req: "b"}, {taskID: 1, req: "c"}}
+	fw.requestsFailed(batch, errors.New("connection refused"))
+	if n := atomic.LoadUint64(&fw.errorCount); n != 1 {
+		t.Errorf("error count = %d, want 1", n)
+	}
+}
+
+func TestBulkDataRequest(t *testing.T) {
+	job := "TestBulkDataRequest"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	client := etcd.NewClient([]string{m.URL()})
+
+	// Every request is held until all of them arrived, which they only do
+	// if they are in flight at the same time.
+	peers := []uint64{1, 2, 3}
+	var arrived sync.WaitGroup
+	arrived.Add(len(peers))
+	getter := dataGetterFunc(func(taskID, epoch uint64, req string) ([]byte, error) {
+		arrived.Done()
+		arrived.Wait()
+		return append([]byte{payloadRaw}, req...), nil
+	})
+	ts := httptest.NewServer(frameworkhttp.NewDataRequestHandler(log.New(ioutil.Discard, "", 0), getter))
+	defer ts.Close()
+
+	fw := &framework{
+		name:       job,
+		etcdClient: client,
+		backend:    metastore.NewEtcdBackend(client),
+		log:        log.New(ioutil.Discard, "", 0),
+	}
+	for _, id := range peers {
+		if _, err := client.Set(fw.taskKey(id), strings.TrimPrefix(ts.URL, "http://"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw.setupChannels()
+	(&context{f: fw}).BulkDataRequest(peers, "gradient")
+	fw.sendRequests(<-fw.bulkReqToSendChan...)
+
+	got := make(map[uint64]string)
+	for range peers {
+		select {
+		case resp := <-fw.dataRespChan:
+			got[resp.TaskID] = string(resp.Data)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("responses received = %v, want %d", got, len(peers))
+		}
+	}
+	for _, id := range peers {
+		if got[id] != "gradient" {
+			t.Errorf("response of task %d = %q, want %q", id, got[id], "gradient")
+		}
+	}
+}
+
+func TestMessageInterceptor(t *testing.T) {
+	job := "TestMessageInterceptor"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	client := etcd.NewClient([]string{m.URL()})
+
+	getter := dataGetterFunc(func(taskID, epoch uint64, req string) ([]byte, error) {
+		return append([]byte{payloadRaw}, req...), nil
+	})
+	ts := httptest.NewServer(frameworkhttp.NewDataRequestHandler(log.New(ioutil.Discard, "", 0), getter))
+	defer ts.Close()
+
+	fw := &framework{
+		name:       job,
+		etcdClient: client,
+		backend:    metastore.NewEtcdBackend(client),
+		log:        log.New(ioutil.Discard, "", 0),
+	}
+	if _, err := client.Set(fw.taskKey(1), strings.TrimPrefix(ts.URL, "http://"), 0); err != nil {
+		t.Fatal(err)
+	}
+	fw.SetMessageInterceptor(func(from, to, epoch uint64, payload []byte) []byte {
+		if from != 1 || to != 0 {
+			t.Errorf("intercepted message from %d to %d, want from 1 to 0", from, to)
+		}
+		if string(payload) == "lost" {
+			return nil
+		}
+		return bytes.ToUpper(payload)
+	})
+	fw.setupChannels()
+	fw.sendRequests(&dataRequest{taskID: 1, req: "lost"}, &dataRequest{taskID: 1, req: "gradient"})
+
+	select {
+	case resp := <-fw.dataRespChan:
+		if resp.Req != "gradient" || string(resp.Data) != "GRADIENT" {
+			t.Errorf("response to %q = %q, want GRADIENT", resp.Req, resp.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no response received")
+	}
+	select {
+	case resp := <-fw.dataRespChan:
+		t.Errorf("response to %q received, want it dropped", resp.Req)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRPCTimeout(t *testing.T) {
+	job := "TestRPCTimeout"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	client := etcd.NewClient([]string{m.URL()})
+
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	fw := &framework{
+		name:       job,
+		etcdClient: client,
+		backend:    metastore.NewEtcdBackend(client),
+		log:        log.New(ioutil.Discard, "", 0),
+	}
+	if _, err := client.Set(fw.taskKey(1), strings.TrimPrefix(ts.URL, "http://"), 0); err != nil {
+		t.Fatal(err)
+	}
+	fw.SetRPCTimeout(100 * time.Millisecond)
+	fw.setupChannels()
+	go fw.sendRequest(&dataRequest{taskID: 1, epoch: 0, req: "slow"})
+
+	select {
+	case fail := <-fw.dataFailChan:
+		err, ok := fail.err.(*meritop.RPCTimeoutError)
+		if !ok {
+			t.Fatalf("failure = %v, want *RPCTimeoutError", fail.err)
+		}
+		if err.PeerID != 1 {
+			t.Errorf("PeerID = %d, want 1", err.PeerID)
+		}
+		if err.Elapsed < 100*time.Millisecond {
+			t.Errorf("Elapsed = %v, want >= 100ms", err.Elapsed)
+		}
+		if fail.req != "slow" {
+			t.Errorf("req = %q, want %q", fail.req, "slow")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request didn't time out")
+	}
+}
+
+func TestLocalRequestRPCTimeout(t *testing.T) {
+	// The peer never serves the request, as its event loop isn't running.
+	peer := &framework{
+		dataReqChan: make(chan *dataRequest, 1),
+		httpStop:    make(chan struct{}),
+	}
+	defer close(peer.httpStop)
+	fw := &framework{log: log.New(ioutil.Discard, "", 0)}
+	fw.SetRPCTimeout(100 * time.Millisecond)
+	fw.setupChannels()
+	go fw.sendLocalRequest(peer, &dataRequest{taskID: 1, epoch: 0, req: "slow"})
+
+	select {
+	case fail := <-fw.dataFailChan:
+		err, ok := fail.err.(*meritop.RPCTimeoutError)
+		if !ok {
+			t.Fatalf("failure = %v, want *RPCTimeoutError", fail.err)
+		}
+		if err.PeerID != 1 {
+			t.Errorf("PeerID = %d, want 1", err.PeerID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request didn't time out")
+	}
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{0, time.Second},
+		{6 * time.Second, 2 * time.Second},
+		{1500 * time.Millisecond, 500 * time.Millisecond},
+		// The TTL is at least 1s.
+		{100 * time.Millisecond, time.Second / 3},
+	}
+	for i, tt := range tests {
+		fw := &framework{}
+		fw.SetEtcdLeaseTTL(tt.ttl)
+		if get := fw.heartbeatInterval(); get != tt.want {
+			t.Errorf("#%d: heartbeat interval = %v, want %v", i, get, tt.want)
+		}
+	}
+}
+
+func TestGetEpochDurations(t *testing.T) {
+	fw := &framework{}
+	for _, epoch := range []uint64{2, 3} {
+		fw.epochStartTime = time.Now().Add(-time.Duration(epoch) * time.Second)
+		fw.recordEpochDuration(epoch)
+	}
+	// Epoch 4 was skipped.
+	fw.recordEpochDuration(4)
+
+	get := fw.GetEpochDurations()
+	if len(get) != 4 {
+		t.Fatalf("got durations of %d epochs, want 4", len(get))
+	}
+	for epoch, d := range get[:2] {
+		if d != 0 {
+			t.Errorf("epoch %d took %v before the task took over", epoch, d)
+		}
+	}
+	for epoch, d := range get[2:] {
+		if want := time.Duration(epoch+2) * time.Second; d < want || d > want+time.Second {
+			t.Errorf("epoch %d took %v, want about %v", epoch+2, d, want)
+		}
+	}
+	if epochs := (SimpleTaskBuilder{NumberOfIterations: 10}).NumberOfEpochs(); epochs != 11 {
+		t.Errorf("NumberOfEpochs = %d, want 11", epochs)
+	}
+}
+
+func TestGetIncompleteEpochTasks(t *testing.T) {
+	topo := example.NewTreeTopology(3, 4)
+	topo.SetTaskID(0)
+	fw := &framework{
+		topology:       topo,
+		childResponses: map[uint64]map[uint64][]byte{4: {1: nil}, 5: {2: nil}},
+	}
+	fw.setEpochOfLoop(5)
+	fw.SetMaxParallelEpochs(2)
+	tests := []struct {
+		epoch uint64
+		want  []uint64
+	}{
+		{5, []uint64{1, 3}},
+		{4, []uint64{2, 3}},
+	}
+	for i, tt := range tests {
+		get, err := fw.GetIncompleteEpochTasks(tt.epoch)
+		if err != nil {
+			t.Fatalf("#%d: GetIncompleteEpochTasks failed: %v", i, err)
+		}
+		if !reflect.DeepEqual(get, tt.want) {
+			t.Errorf("#%d: incomplete tasks = %v, want %v", i, get, tt.want)
+		}
+	}
+	if _, err := fw.GetIncompleteEpochTasks(3); err == nil {
+		t.Errorf("GetIncompleteEpochTasks(3) = nil error, want epoch 3 inactive")
+	}
+}
+
+func TestChildWeight(t *testing.T) {
+	fw := &framework{epoch: 5}
+	ctx := &context{epoch: 5, f: fw}
+	if w := ctx.GetChildWeight(1); w != 1 {
+		t.Errorf("default weight = %v, want 1", w)
+	}
+	ctx.SetChildWeight(1, 0.25)
+	if w := ctx.GetChildWeight(1); w != 0.25 {
+		t.Errorf("weight = %v, want 0.25", w)
+	}
+	// Weights are set per epoch.
+	if w := (&context{epoch: 4, f: fw}).GetChildWeight(1); w != 1 {
+		t.Errorf("weight in epoch 4 = %v, want 1", w)
+	}
+	fw.epoch = 6
+	fw.pruneChildResponses()
+	if len(fw.childWeights) != 0 {
+		t.Errorf("weights = %v, want those of epoch 5 dropped", fw.childWeights)
+	}
+}
+
+func TestBandwidthMeter(t *testing.T) {
+	var m bandwidthMeter
+	start := time.Now()
+	m.sent(start, 1, 1000)
+	m.sent(start, 1, 1000)
+	m.received(start, 2, 500)
+
+	rates := m.rates(start)
+	if r := rates[1]; r.SendBytesPerSec != 200 || r.RecvBytesPerSec != 0 {
+		t.Errorf("rates to task 1 = %+v, want 200 B/s sent", r)
+	}
+	if r := rates[2]; r.SendBytesPerSec != 0 || r.RecvBytesPerSec != 50 {
+		t.Errorf("rates to task 2 = %+v, want 50 B/s received", r)
+	}
+	// The rates decay by 1/e every window.
+	later := m.rates(start.Add(bandwidthWindow))
+	if get, want := later[1].SendBytesPerSec, 200/math.E; math.Abs(get-want) > 1e-9 {
+		t.Errorf("rate to task 1 a window later = %v, want %v", get, want)
+	}
+
+	fw := &framework{taskID: 3}
+	fw.bandwidth.sent(time.Now(), 1, 10)
+	if stats := fw.GetBandwidthStats(); len(stats) != 1 || stats[[2]uint64{3, 1}].SendBytesPerSec <= 0 {
+		t.Errorf("bandwidth stats = %v, want link {3 1} with data sent", stats)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	if b := newTokenBucket(0); b != nil {
+		t.Errorf("bucket without limit = %v, want nil", b)
+	}
+	b := newTokenBucket(10000)
+	start := time.Now()
+	b.wait(10000) // a second worth goes through at once
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("burst took %v, want no wait", d)
+	}
+	b.wait(2000)
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("2000 bytes over the burst took %v, want about 200ms", d)
+	}
+
+	// Reading responses is throttled by the client.
+	getter := dataGetterFunc(func(taskID, epoch uint64, req string) ([]byte, error) {
+		return make([]byte, 3000), nil
+	})
+	ts := httptest.NewServer(frameworkhttp.NewDataRequestHandler(log.New(ioutil.Discard, "", 0), getter))
+	defer ts.Close()
+	client := frameworkhttp.NewClient(nil)
+	read := 0
+	client.ThrottleReceive(func(n int) { read += n })
+	_, err := frameworkhttp.RequestData(gocontext.Background(), client, strings.TrimPrefix(ts.URL, "http://"),
+		"req", 1, 0, 0, 0, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("RequestData failed: %v", err)
+	}
+	if read != 3000 {
+		t.Errorf("throttled bytes = %d, want 3000", read)
+	}
+}
+
+func TestExportMetrics(t *testing.T) {
+	fw := &framework{name: "job", taskID: 1, errorCount: 3}
+	fw.setEpochOfLoop(2)
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"csv", "meritop_epoch,2\nmeritop_errors,3\nmeritop_pending_requests,0\n"},
+		{"json", `{"meritop_epoch":2,"meritop_errors":3,"meritop_pending_requests":0}` + "\n"},
+		{"prometheus", "# TYPE meritop_epoch gauge\n" +
+			"meritop_epoch{job=\"job\",task=\"1\"} 2\n" +
+			"# TYPE meritop_errors gauge\n" +
+			"meritop_errors{job=\"job\",task=\"1\"} 3\n" +
+			"# TYPE meritop_pending_requests gauge\n" +
+			"meritop_pending_requests{job=\"job\",task=\"1\"} 0\n"},
+	}
+	for i, tt := range tests {
+		var buf bytes.Buffer
+		if err := fw.ExportMetrics(&buf, tt.format); err != nil {
+			t.Fatalf("#%d: ExportMetrics(%s) failed: %v", i, tt.format, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("#%d: ExportMetrics(%s) = %q, want %q", i, tt.format, buf.String(), tt.want)
+		}
+	}
+	if err := fw.ExportMetrics(ioutil.Discard, "xml"); err == nil {
+		t.Error("ExportMetrics(xml) = nil, want error")
+	}
+}
+
+func TestSerializers(t *testing.T) {
+	for _, s := range []meritop.Serializer{JSONSerializer{}, GobSerializer{}} {
+		want := &dummyData{Value: 42}
+		get := new(dummyData)
+		if err := s.Unmarshal(MustMarshal(s, want), get); err != nil {
+			t.Fatalf("%T: Unmarshal failed: %v", s, err)
+		}
+		if !reflect.DeepEqual(get, want) {
+			t.Errorf("%T: get = %v, want %v", s, get, want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MustMarshal should panic on error")
+		}
+	}()
+	MustMarshal(JSONSerializer{}, make(chan int))
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	fw := &framework{}
+	for i := 1; i <= 9; i++ {
+		fw.latencies.record(1, time.Duration(i)*time.Millisecond)
+	}
+	if _, err := fw.GetLatencyPercentile(1, 50); err != meritop.ErrInsufficientData {
+		t.Fatalf("err = %v, want ErrInsufficientData", err)
+	}
+	for i := 10; i <= 100; i++ {
+		fw.latencies.record(1, time.Duration(i)*time.Millisecond)
+	}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{95, 95 * time.Millisecond},
+		{99.9, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		get, err := fw.GetLatencyPercentile(1, tt.p)
+		if err != nil {
+			t.Fatalf("#%d: GetLatencyPercentile failed: %v", i, err)
+		}
+		if get != tt.want {
+			t.Errorf("#%d: P%v = %v, want %v", i, tt.p, get, tt.want)
+		}
+	}
+	if _, err := fw.GetLatencyPercentile(1, 101); err == nil {
+		t.Error("GetLatencyPercentile(101) = nil error, want error")
+	}
+	if _, err := fw.GetLatencyPercentile(2, 50); err != meritop.ErrInsufficientData {
+		t.Errorf("unknown task: err = %v, want ErrInsufficientData", err)
+	}
+}
+
+func TestValidateEpoch(t *testing.T) {
+	fw := &framework{
+		log:            log.New(ioutil.Discard, "", 0),
+		childResponses: map[uint64]map[uint64][]byte{3: {1: []byte("NaN"), 2: []byte("1")}},
+		epochRetryChan: make(chan uint64, 1),
+	}
+	if err := fw.validateEpoch(3); err != nil {
+		t.Fatalf("validateEpoch without validator = %v, want nil", err)
+	}
+
+	var gradients map[uint64][]byte
+	fw.SetMaxEpochRetries(1)
+	fw.SetEpochValidator(func(epoch uint64, g map[uint64][]byte) error {
+		gradients = g
+		for _, d := range g {
+			if string(d) == "NaN" {
+				return fmt.Errorf("NaN gradient")
+			}
+		}
+		return nil
+	})
+	if err := fw.validateEpoch(3); err == nil {
+		t.Fatal("validateEpoch = nil, want error")
+	}
+	if !reflect.DeepEqual(gradients, fw.childResponses[3]) {
+		t.Errorf("gradients = %v, want %v", gradients, fw.childResponses[3])
+	}
+	if epoch := <-fw.epochRetryChan; epoch != 3 {
+		t.Errorf("retried epoch = %d, want 3", epoch)
+	}
+
+	fw.childResponses[3][1] = []byte("2")
+	if err := fw.validateEpoch(3); err != nil {
+		t.Errorf("validateEpoch = %v, want nil", err)
+	}
+}
+
+func TestTransitionEpoch(t *testing.T) {
+	fw := &framework{
+		epoch:    3,
+		topology: example.NewTreeTopology(2, 1),
+		log:      log.New(ioutil.Discard, "", 0),
+	}
+	if err := fw.transitionEpoch(3, 4); err != nil {
+		t.Fatalf("transitionEpoch without transition = %v, want nil", err)
+	}
+
+	var calls [][2]uint64
+	fw.SetMaxTransitionRetries(2)
+	fw.SetCustomEpochTransition(func(ctx meritop.Context, from, to uint64) error {
+		calls = append(calls, [2]uint64{from, to})
+		if len(calls) < 3 {
+			return fmt.Errorf("external system unavailable")
+		}
+		return nil
+	})
+	if err := fw.transitionEpoch(3, 4); err != nil {
+		t.Fatalf("transitionEpoch = %v, want nil after retries", err)
+	}
+	if want := [][2]uint64{{3, 4}, {3, 4}, {3, 4}}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if fw.errorCount != 2 {
+		t.Errorf("error count = %d, want 2", fw.errorCount)
+	}
+}
+
+func TestEpochCompletionCallback(t *testing.T) {
+	fw := &framework{}
+	master := &dummyMaster{framework: fw, gradient: &dummyData{Value: 7}}
+	fw.task = master
+	fw.completeEpoch(3) // no callback set
+
+	var (
+		epochs []uint64
+		sums   [][]byte
+	)
+	fw.SetEpochCompletionCallback(func(epoch uint64, gradientSum []byte) {
+		epochs = append(epochs, epoch)
+		sums = append(sums, gradientSum)
+	})
+	fw.completeEpoch(3)
+	fw.task = &testableTask{}
+	fw.completeEpoch(4)
+
+	if want := []uint64{3, 4}; !reflect.DeepEqual(epochs, want) {
+		t.Fatalf("epochs = %v, want %v", epochs, want)
+	}
+	d := new(dummyData)
+	if err := fw.GetSerializer().Unmarshal(sums[0], d); err != nil || d.Value != 7 {
+		t.Errorf("gradient sum = %s (%v), want value 7", sums[0], err)
+	}
+	if sums[1] != nil {
+		t.Errorf("gradient sum of non-aggregator = %q, want nil", sums[1])
+	}
+}
+
+func TestLogEpochBoundary(t *testing.T) {
+	var buf bytes.Buffer
+	fw := &framework{taskID: 2, log: log.New(&buf, "", 0)}
+	fw.LogEpochBoundary(50, `learning rate "decayed"`)
+
+	var get map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &get); err != nil {
+		t.Fatalf("log = %q isn't JSON: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"event": "epoch_boundary",
+		"task":  float64(2),
+		"epoch": float64(50),
+		"msg":   `learning rate "decayed"`,
+	}
+	if !reflect.DeepEqual(get, want) {
+		t.Errorf("logged %v, want %v", get, want)
+	}
+}
+
+func TestNextEpoch(t *testing.T) {
+	fw := &framework{log: log.New(ioutil.Discard, "", 0)}
+	if next := fw.nextEpoch(3); next != 4 {
+		t.Errorf("next epoch = %d, want 4", next)
+	}
+	fw.SetEpochIDGenerator(func(prev uint64) uint64 { return prev + 10 })
+	if next := fw.nextEpoch(3); next != 13 {
+		t.Errorf("next epoch = %d, want 13", next)
+	}
+	// IDs that don't move forward aren't used.
+	fw.SetEpochIDGenerator(func(prev uint64) uint64 { return prev })
+	if next := fw.nextEpoch(3); next != 4 {
+		t.Errorf("next epoch = %d, want 4", next)
+	}
+	if fw.errorCount != 1 {
+		t.Errorf("error count = %d, want 1", fw.errorCount)
+	}
+}
+
+func TestGlobalSeed(t *testing.T) {
+	a, b := &framework{}, &framework{}
+	a.SetGlobalSeed(42)
+	b.SetGlobalSeed(42)
+	if (&context{f: a}).GetRand() != a.random() {
+		t.Fatalf("context doesn't share the random number generator of the framework")
+	}
+	for i := 0; i < 10; i++ {
+		if x, y := a.random().Int63(), (&context{f: b}).GetRand().Int63(); x != y {
+			t.Fatalf("#%d: %d != %d with the same seed", i, x, y)
+		}
+	}
+}
+
+func TestMaxEpochsPerSecond(t *testing.T) {
+	fw := &framework{}
+	fw.SetMaxEpochsPerSecond(10)
+	if !fw.paceEpoch(nil) {
+		t.Fatalf("paceEpoch before the first epoch = false, want true")
+	}
+	fw.lastEpochStart = time.Now()
+	start := time.Now()
+	if !fw.paceEpoch(nil) {
+		t.Fatalf("paceEpoch = false, want true")
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("next epoch started after %v, want 100ms", d)
+	}
+
+	fw.SetMaxEpochsPerSecond(0.01)
+	fw.lastEpochStart = time.Now()
+	done := make(chan struct{})
+	close(done)
+	if fw.paceEpoch(done) {
+		t.Errorf("paceEpoch with context done = true, want false")
+	}
+}
+
+func TestWaitForEpoch(t *testing.T) {
+	job := "TestWaitForEpoch"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	client := etcd.NewClient([]string{m.URL()})
+	fw := &framework{name: job, backend: metastore.NewEtcdBackend(client)}
+	if _, err := client.Set(fw.epochKey(), "0", 0); err != nil {
+		t.Fatalf("Set epoch failed: %v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- fw.WaitForEpoch(2, 5*time.Second) }()
+	for _, epoch := range []string{"1", "2"} {
+		if _, err := client.Set(fw.epochKey(), epoch, 0); err != nil {
+			t.Fatalf("Set epoch failed: %v", err)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("WaitForEpoch(2) = %v", err)
+	}
+	if err := fw.WaitForEpoch(1, time.Second); err != nil {
+		t.Errorf("WaitForEpoch of a past epoch = %v", err)
+	}
+	err := fw.WaitForEpoch(3, 50*time.Millisecond)
+	if !errors.Is(err, meritop.ErrTimeout) || !strings.Contains(err.Error(), "epoch 2") {
+		t.Errorf("WaitForEpoch(3) = %v, want a timeout at epoch 2", err)
+	}
+
+	go func() { errc <- fw.WaitForEpoch(3, 5*time.Second) }()
+	if _, err := client.Set(fw.epochKey(), strconv.FormatUint(exitEpoch, 10), 0); err != nil {
+		t.Fatalf("Set epoch failed: %v", err)
+	}
+	if err := <-errc; err != meritop.ErrJobShutDown {
+		t.Errorf("WaitForEpoch(3) once the job is shut down = %v, want %v", err, meritop.ErrJobShutDown)
+	}
+}
+
+func TestInitDelay(t *testing.T) {
+	job := "TestInitDelay"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	ctl := controller.New(job, etcd.NewClient(etcdURLs), 3)
+	if err := ctl.InitEtcdLayout(); err != nil {
+		t.Fatalf("initEtcdLayout failed: %v", err)
+	}
+	defer ctl.DestroyEtcdLayout()
+
+	fw := &framework{name: job, etcdClient: etcd.NewClient(etcdURLs)}
+	if d := fw.initDelay(0); d != 0 {
+		t.Errorf("delay without init order = %v, want 0", d)
+	}
+	fw.SetWorkerInitOrder(func(numTasks uint64) []uint64 {
+		order := SequentialInitOrder(numTasks)
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+		return order
+	})
+	for id, want := range []time.Duration{20 * time.Millisecond, 10 * time.Millisecond, 0} {
+		if d := fw.initDelay(uint64(id)); d != want {
+			t.Errorf("task %d: delay = %v, want %v", id, d, want)
+		}
+	}
+}
+
+func TestHTTPSupervisorClient(t *testing.T) {
+	var got meritop.SupervisorStatus
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode([]meritop.SupervisorCommand{
+			{Type: meritop.CommandPause},
+			{Type: meritop.CommandScaleDown, N: 2},
+		})
+	}))
+	defer ts.Close()
+
+	fw := &framework{}
+	if err := fw.ConnectSupervisor("10.0.0.1:8080"); err == nil {
+		t.Errorf("ConnectSupervisor without scheme = nil, want error")
+	}
+	if err := fw.ConnectSupervisor(ts.URL); err != nil {
+		t.Fatalf("ConnectSupervisor failed: %v", err)
+	}
+	status := meritop.SupervisorStatus{JobName: "job", TaskID: 1, Epoch: 3, ActiveTasks: 4, ErrorCount: 5}
+	cmds, err := fw.supervisor.ReportStatus(status)
+	if err != nil {
+		t.Fatalf("ReportStatus failed: %v", err)
+	}
+	if got != status {
+		t.Errorf("supervisor got status %+v, want %+v", got, status)
+	}
+	want := []meritop.SupervisorCommand{
+		{Type: meritop.CommandPause},
+		{Type: meritop.CommandScaleDown, N: 2},
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %+v, want %+v", cmds, want)
+	}
+}
+
+func TestDataRequestPolicies(t *testing.T) {
+	tests := []struct {
+		policy meritop.DataRequestPolicy
+		// requests made in epoch 1, the last one being flushed, and epoch 2
+		wantSent []int
+	}{
+		{FetchImmediately, []int{1, 2, 3, 4, 4, 5}},
+		{NewFetchOnce(), []int{1, 2, 2, 3, 3, 4}},
+		{NewFetchLazy(), []int{0, 0, 0, 0, 4, 4}},
+		{NewFetchBatch(2), []int{0, 2, 2, 4, 4, 4}},
+	}
+	for i, tt := range tests {
+		sent := 0
+		fetch := func(uint64, string) { sent++ }
+		ctx := &context{epoch: 1}
+		var got []int
+		for _, req := range []string{"a", "b", "a", "c"} {
+			tt.policy.Execute(ctx, 1, req, fetch)
+			got = append(got, sent)
+		}
+		tt.policy.Flush(ctx)
+		got = append(got, sent)
+		// Requests held back in epoch 1 are dropped in epoch 2.
+		tt.policy.Execute(&context{epoch: 2}, 1, "a", fetch)
+		got = append(got, sent)
+		if !reflect.DeepEqual(got, tt.wantSent) {
+			t.Errorf("#%d: sent = %v, want %v", i, got, tt.wantSent)
+		}
+	}
+}
+
+func TestDataRequestPoliciesParallelEpochs(t *testing.T) {
+	sent := make(map[string]int)
+	fetch := func(_ uint64, meta string) { sent[meta]++ }
+	p := NewFetchLazy()
+	p.Execute(&context{epoch: 1}, 1, "a", fetch)
+	p.Execute(&context{epoch: 2}, 1, "b", fetch)
+	p.Flush(&context{epoch: 1})
+	if sent["a"] != 1 || sent["b"] != 0 {
+		t.Errorf("flushing epoch 1 sent %v, want only a", sent)
+	}
+	p.Flush(&context{epoch: 2})
+	if sent["b"] != 1 {
+		t.Errorf("flushing epoch 2 sent b %d times, want 1", sent["b"])
+	}
+
+	// Requests of epochs long gone are dropped.
+	p.Execute(&context{epoch: 3}, 1, "c", fetch)
+	p.Execute(&context{epoch: 3 + heldEpochs}, 1, "d", fetch)
+	p.Flush(&context{epoch: 3})
+	if sent["c"] != 0 {
+		t.Errorf("request of an epoch long gone was sent")
+	}
+}
+
+func TestCustomKeys(t *testing.T) {
+	fw := &framework{name: "job"}
+	if k := fw.epochKey(); k != "/meritop/job/epoch" {
+		t.Errorf("epochKey() = %s, want /meritop/job/epoch", k)
+	}
+	if k := fw.taskKey(3); k != "/meritop/job/tasks/3/0" {
+		t.Errorf("taskKey(3) = %s, want /meritop/job/tasks/3/0", k)
+	}
+	fw.SetCustomEpochKey(func(jobName string, epoch uint64) string { return "/acl/" + jobName + "/epoch" })
+	fw.SetCustomTaskKey(func(jobName string, taskID uint64) string {
+		return fmt.Sprintf("/acl/%s/addr/%d", jobName, taskID)
+	})
+	if k := fw.epochKey(); k != "/acl/job/epoch" {
+		t.Errorf("epochKey() = %s, want /acl/job/epoch", k)
+	}
+	if k := fw.taskKey(3); k != "/acl/job/addr/3" {
+		t.Errorf("taskKey(3) = %s, want /acl/job/addr/3", k)
+	}
+}
+
+func TestInjectFaultAt(t *testing.T) {
+	newFramework := func() *framework {
+		return &framework{
+			taskID:    1,
+			log:       log.New(ioutil.Discard, "", 0),
+			epochChan: make(chan uint64),
+		}
+	}
+	InjectFaultAt(1, "SetEpoch", 2)
+	if newFramework().injectedFault("SetEpoch", 2) {
+		t.Fatal("fault fired without fault injection mode")
+	}
+	EnableFaultInjectionMode()
+	defer DisableFaultInjectionMode()
+	fw := newFramework()
+	if fw.injectedFault("SetEpoch", 1) || fw.injectedFault("ChildDataReady", 2) {
+		t.Fatal("fault fired at the wrong method or epoch")
+	}
+	if !fw.injectedFault("SetEpoch", 2) {
+		t.Fatal("fault didn't fire")
+	}
+	if _, ok := <-fw.epochChan; ok {
+		t.Errorf("node wasn't stopped")
+	}
+	// The node taking over doesn't fail again.
+	if newFramework().injectedFault("SetEpoch", 2) {
+		t.Errorf("fault fired twice")
+	}
+}
+
+func TestEtcdAuthenticationFailed(t *testing.T) {
+	// etcd with authentication enabled rejects bad credentials like this.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "root" || pass != "secret" {
+			http.Error(w, `{"message":"Insufficient credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	fw := &framework{
+		name:     "TestEtcdAuthenticationFailed",
+		etcdURLs: []string{ts.URL},
+		log:      log.New(&buf, "", 0),
+	}
+	fw.SetTaskBuilder(&testableTaskBuilder{})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	fw.SetEtcdCredentials("root", "wrong-password")
+	done := make(chan struct{})
+	go func() {
+		fw.Start()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start didn't return on bad credentials")
+	}
+	if out := buf.String(); !strings.Contains(out, meritop.ErrAuthenticationFailed.Error()) {
+		t.Errorf("log = %q, want it to report %v", out, meritop.ErrAuthenticationFailed)
+	} else if strings.Contains(out, "wrong-password") {
+		t.Errorf("log = %q, has the password", out)
+	}
+}
+
+func TestTaskAffinityGroups(t *testing.T) {
+	job := "TestTaskAffinityGroups"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	var wg sync.WaitGroup
+	fw.SetTaskBuilder(&testableTaskBuilder{setupLatch: &wg})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	fw.SetTaskAffinityGroups(map[string][]uint64{"rack-a": {0, 2}, "rack-b": {1}})
+	wg.Add(1)
+	go fw.Start()
+	defer fw.ShutdownJob()
+	wg.Wait()
+
+	tests := []struct {
+		taskID uint64
+		group  string
+	}{
+		{0, "rack-a"},
+		// Task 1 isn't part of the job, so it never tags its group.
+		{1, ""},
+	}
+	for i, tt := range tests {
+		group, err := fw.GetAffinityGroup(tt.taskID)
+		if err != nil {
+			t.Fatalf("#%d: GetAffinityGroup failed: %v", i, err)
+		}
+		if group != tt.group {
+			t.Errorf("#%d: affinity group = %q, want %q", i, group, tt.group)
+		}
+	}
+}
+
+func TestTaskAffinityGroupsConflict(t *testing.T) {
+	var buf bytes.Buffer
+	fw := &framework{
+		name: "TestTaskAffinityGroupsConflict",
+		log:  log.New(&buf, "", 0),
+	}
+	fw.SetTaskBuilder(&testableTaskBuilder{})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	fw.SetTaskAffinityGroups(map[string][]uint64{"rack-a": {0}, "rack-b": {0}})
+	fw.Start()
+	if out := buf.String(); !strings.Contains(out, "refuse to start") {
+		t.Errorf("log = %q, want the framework to refuse to start", out)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	cfg := NewSelfSignedTLSConfig()
+	fw := &framework{
+		taskID: 3,
+		ln:     createListener(t),
+		log:    log.New(ioutil.Discard, "", 0),
+	}
+	fw.SetTLSConfig(cfg)
+	fw.setupChannels()
+	go fw.startHTTP()
+	defer fw.stopHTTP()
+
+	addr := fw.ln.Addr().String()
+	id, err := frameworkhttp.Ping(frameworkhttp.NewClient(cfg), addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Ping over TLS failed: %v", err)
+	}
+	if id != 3 {
+		t.Errorf("task ID = %d, want 3", id)
+	}
+	if _, err := frameworkhttp.Ping(nil, addr, 5*time.Second); err == nil {
+		t.Error("Ping over plain HTTP succeeded, want failure")
+	}
+}
+
+func TestTLSRequired(t *testing.T) {
+	var buf bytes.Buffer
+	fw := &framework{
+		name: "TestTLSRequired",
+		log:  log.New(&buf, "", 0),
+	}
+	fw.SetTaskBuilder(&testableTaskBuilder{})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	fw.SetTLSConfig(nil)
+	fw.Start()
+	if out := buf.String(); !strings.Contains(out, "refuse to start") {
+		t.Errorf("log = %q, want the framework to refuse to start", out)
+	}
+}
+
+func TestTaskCountMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	fw := &framework{
+		name: "TestTaskCountMismatch",
+		log:  log.New(&buf, "", 0),
+	}
+	fw.SetTaskBuilder(SimpleTaskBuilder{
+		GDataChan:          make(chan int32),
+		FinishChan:         make(chan struct{}),
+		NumberOfIterations: 1,
+		Topology:           example.NewTreeTopology(2, 3),
+	})
+	fw.SetTopology(example.NewTreeTopology(2, 2))
+	fw.Start()
+	if out := buf.String(); !strings.Contains(out, "refuse to start") {
+		t.Errorf("log = %q, want the framework to refuse to start", out)
+	}
+}
+
+func TestUpdateTopology(t *testing.T) {
+	job := "TestUpdateTopology"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	epochChan := make(chan uint64, 10)
+	updates := make(chan [2]meritop.Topology, 10)
+	oldTopo, newTopo := example.NewTreeTopology(2, 1), example.NewTreeTopology(3, 1)
+	fw.SetTaskBuilder(&testableTaskBuilder{epochChan: epochChan})
+	fw.SetTopology(oldTopo)
+	fw.SetTopologyUpdateCallback(func(old, new meritop.Topology) {
+		updates <- [2]meritop.Topology{old, new}
+	})
+	go fw.Start()
+	defer fw.ShutdownJob()
+
+	wait := func(want uint64) {
+		select {
+		case epoch := <-epochChan:
+			if epoch != want {
+				t.Fatalf("epoch set = %d, want %d", epoch, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("SetEpoch(%d) wasn't called", want)
+		}
+	}
+	wait(0)
+	fw.UpdateTopology(newTopo)
+	if fw.GetTopology() != oldTopo {
+		t.Errorf("topology changed within the epoch")
+	}
+	fw.incEpoch(0)
+	wait(1)
+	// The callback was called before SetEpoch(1).
+	select {
+	case u := <-updates:
+		if u[0] != oldTopo || u[1] != newTopo {
+			t.Errorf("callback got topologies %v, want %v and %v", u, oldTopo, newTopo)
+		}
+	default:
+		t.Fatalf("topology update callback wasn't called before SetEpoch")
+	}
+	if fw.GetTopology() != newTopo {
+		t.Errorf("topology = %v, want the new one", fw.GetTopology())
+	}
+}
+
+func TestSetTopologyBetweenEpochs(t *testing.T) {
+	job := "TestSetTopologyBetweenEpochs"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	epochChan := make(chan uint64, 10)
+	oldTopo, newTopo := example.NewTreeTopology(2, 1), example.NewTreeTopology(3, 1)
+	fw.SetTaskBuilder(&testableTaskBuilder{epochChan: epochChan})
+	if err := fw.SetTopology(oldTopo); err != nil {
+		t.Fatalf("SetTopology before Start = %v", err)
+	}
+	// Leaves a second between incEpoch and the next SetEpoch.
+	fw.SetMaxEpochsPerSecond(1)
+	go fw.Start()
+	defer fw.ShutdownJob()
+
+	wait := func(want uint64) {
+		select {
+		case epoch := <-epochChan:
+			if epoch != want {
+				t.Fatalf("epoch set = %d, want %d", epoch, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("SetEpoch(%d) wasn't called", want)
+		}
+	}
+	wait(0)
+	// Reads the topology while the event loop swaps it, for -race to check.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				fw.GetTopology()
+			}
+		}
+	}()
+	if err := fw.SetTopology(newTopo); err != meritop.ErrEpochInProgress {
+		t.Fatalf("SetTopology within epoch 0 = %v, want %v", err, meritop.ErrEpochInProgress)
+	}
+	fw.incEpoch(0)
+	if err := fw.SetTopology(newTopo); err != nil {
+		t.Fatalf("SetTopology after incEpoch = %v", err)
+	}
+	wait(1)
+	fw.incEpoch(1)
+	wait(2)
+	if fw.GetTopology() != newTopo {
+		t.Errorf("topology = %v, want the new one", fw.GetTopology())
+	}
+}
+
+func TestSetTopologyJSONAfterStart(t *testing.T) {
+	oldTopo := example.NewTreeTopology(2, 1)
+	fw := &framework{topology: oldTopo, started: true, epochInProgress: 1}
+	config := `{"type": "tree", "fanout": 3, "numTasks": 1}`
+	if err := fw.SetTopologyJSON(config); err != meritop.ErrEpochInProgress {
+		t.Fatalf("SetTopologyJSON within an epoch = %v, want %v", err, meritop.ErrEpochInProgress)
+	}
+	atomic.StoreInt32(&fw.epochInProgress, 0)
+	if err := fw.SetTopologyJSON(config); err != nil {
+		t.Fatalf("SetTopologyJSON between epochs = %v", err)
+	}
+	// The event loop switches to it when the next epoch starts.
+	if fw.GetTopology() != oldTopo || fw.nextTopology == nil {
+		t.Errorf("topology switched before the next epoch")
+	}
+}
+
+func TestCommitStrategies(t *testing.T) {
+	tests := []struct {
+		strategy               meritop.CommitStrategy
+		responded, numChildren int
+		ready                  bool
+		timeout                time.Duration
+	}{
+		{ManualCommit, 2, 2, false, 0},
+		{AutoCommitAfterQuorum(0.5), 0, 3, false, 0},
+		{AutoCommitAfterQuorum(0.5), 1, 3, false, 0},
+		{AutoCommitAfterQuorum(0.5), 2, 3, true, 0},
+		{AutoCommitAfterQuorum(1), 0, 0, true, 0},
+		{AutoCommitAfterTimeout(time.Second), 2, 2, false, time.Second},
+	}
+	for i, tt := range tests {
+		if ready := tt.strategy.Ready(tt.responded, tt.numChildren); ready != tt.ready {
+			t.Errorf("#%d: Ready(%d, %d) = %v, want %v", i, tt.responded, tt.numChildren, ready, tt.ready)
+		}
+		if d := tt.strategy.Timeout(); d != tt.timeout {
+			t.Errorf("#%d: Timeout() = %v, want %v", i, d, tt.timeout)
+		}
+	}
+}
+
+func TestAutoCommitAfterTimeout(t *testing.T) {
+	job := "TestAutoCommitAfterTimeout"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	epochChan := make(chan uint64, 10)
+	fw.SetTaskBuilder(&testableTaskBuilder{epochChan: epochChan})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	fw.SetEpochCommitStrategy(AutoCommitAfterTimeout(50 * time.Millisecond))
+	go fw.Start()
+	defer fw.ShutdownJob()
+
+	// The task never calls IncEpoch.
+	for want := uint64(0); want < 3; want++ {
+		select {
+		case epoch := <-epochChan:
+			if epoch != want {
+				t.Fatalf("epoch set = %d, want %d", epoch, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("SetEpoch(%d) wasn't called", want)
+		}
+	}
+}
+
+func TestCommitTimeoutStoppedOnRetry(t *testing.T) {
+	fw := &framework{
+		topology:       example.NewTreeTopology(2, 1),
+		commitStrategy: AutoCommitAfterTimeout(10 * time.Millisecond),
+		commitChan:     make(chan commitTimeout, 10),
+	}
+	fw.startCommit(0)
+	// The epoch is started over before the first attempt timed out.
+	fw.stopCommit()
+	atomic.AddUint64(&fw.attempt, 1)
+	fw.startCommit(0)
+	select {
+	case c := <-fw.commitChan:
+		if c.attempt != 1 {
+			t.Errorf("commit timed out in attempt %d, want 1", c.attempt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("commit didn't time out")
+	}
+	select {
+	case c := <-fw.commitChan:
+		t.Errorf("commit timed out again in attempt %d", c.attempt)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTaskGroupBarrier(t *testing.T) {
+	store := metastore.NewInMemoryMetadataStore()
+	reached := make(chan uint64, 2)
+	fws := make([]*framework, 3)
+	for id := range fws {
+		fws[id] = &framework{name: "TestTaskGroupBarrier", taskID: uint64(id), metaStore: store}
+		fws[id].SetTaskGroupBarrier("g", []uint64{0, 1}, func(ctx meritop.Context, epoch uint64) {
+			if epoch != 3 {
+				t.Errorf("barrier function called at epoch %d, want 3", epoch)
+			}
+			reached <- ctx.(*context).f.taskID
+		})
+	}
+
+	errc := make(chan error, 2)
+	go func() { errc <- (&context{epoch: 3, f: fws[0]}).ReachBarrier("g") }()
+	select {
+	case err := <-errc:
+		t.Fatalf("ReachBarrier returned %v before the group reached it", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	go func() { errc <- (&context{epoch: 3, f: fws[1]}).ReachBarrier("g") }()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				t.Errorf("ReachBarrier = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ReachBarrier didn't return")
+		}
+	}
+	if len(reached) != 2 {
+		t.Errorf("barrier function called %d times, want 2", len(reached))
+	}
+
+	if err := (&context{epoch: 3, f: fws[2]}).ReachBarrier("g"); err == nil {
+		t.Errorf("ReachBarrier of a task outside the group = nil, want error")
+	}
+	if err := (&context{epoch: 3, f: fws[0]}).ReachBarrier("h"); err == nil {
+		t.Errorf("ReachBarrier of an unknown group = nil, want error")
+	}
+
+	// Waiting ends with the epoch, and an aborted epoch starts the barrier
+	// over.
+	for _, fw := range fws {
+		fw.SetTaskGroupBarrier("a", []uint64{0, 1}, nil)
+		fw.log = log.New(ioutil.Discard, "", 0)
+		fw.epoch = 4
+		fw.startBarrierScope()
+	}
+	go func() { errc <- (&context{epoch: 4, f: fws[0]}).ReachBarrier("a") }()
+	time.Sleep(100 * time.Millisecond)
+	fws[0].endBarrierScope()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Errorf("ReachBarrier after the epoch was over = nil, want error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ReachBarrier didn't return once the epoch was over")
+	}
+	for _, fw := range fws {
+		fw.endBarrierScope()
+		atomic.AddUint64(&fw.epochAborts, 1)
+		fw.startBarrierScope()
+	}
+	go func() { errc <- (&context{epoch: 4, f: fws[1]}).ReachBarrier("a") }()
+	select {
+	case err := <-errc:
+		t.Fatalf("ReachBarrier returned %v on a key of the aborted attempt", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := (&context{epoch: 4, f: fws[0]}).ReachBarrier("a"); err != nil {
+		t.Errorf("ReachBarrier = %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("ReachBarrier = %v", err)
+	}
+	if err := (&context{epoch: 3, f: fws[0]}).ReachBarrier("a"); err == nil {
+		t.Errorf("ReachBarrier in an epoch over = nil, want error")
+	}
+
+	// Keys are deleted once the epoch after is over too.
+	key := etcdutil.BarrierPath(fws[0].jobPath(), "a", 4, 1, 0)
+	fws[0].endBarrierScope()
+	fws[0].epoch = 5
+	fws[0].startBarrierScope()
+	fws[0].endBarrierScope()
+	for i := 0; ; i++ {
+		if _, err := store.Get(gocontext.TODO(), key); err == meritop.ErrKeyNotFound {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("barrier key %s wasn't deleted", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestApplyGradient(t *testing.T) {
+	fw := &framework{}
+	ctx := &context{f: fw}
+	if p := ctx.ApplyGradient([]byte{5}, []byte{2}); !bytes.Equal(p, []byte{5}) {
+		t.Errorf("ApplyGradient without update function = %v, want the param as is", p)
+	}
+	// SGD on a single byte with a learning rate of 1.
+	fw.SetParameterUpdateFunction(func(param, gradient []byte) []byte {
+		return []byte{param[0] - gradient[0]}
+	})
+	if p := ctx.ApplyGradient([]byte{5}, []byte{2}); !bytes.Equal(p, []byte{3}) {
+		t.Errorf("ApplyGradient = %v, want [3]", p)
+	}
+}
+
+func TestWarmupEpochs(t *testing.T) {
+	fw := &framework{}
+	if (&context{epoch: 0, f: fw}).IsWarmup() {
+		t.Errorf("IsWarmup() without warm-up epochs = true, want false")
+	}
+	fw.SetWarmupEpochs(2)
+	for epoch, want := range []bool{true, true, false} {
+		if get := (&context{epoch: uint64(epoch), f: fw}).IsWarmup(); get != want {
+			t.Errorf("IsWarmup() at epoch %d = %v, want %v", epoch, get, want)
+		}
+	}
+}
+
+func TestChildResponseValidator(t *testing.T) {
+	topo := example.NewTreeTopology(2, 3)
+	topo.SetTaskID(0)
+	fw := &framework{
+		topology:          topo,
+		log:               log.New(ioutil.Discard, "", 0),
+		dataReqtoSendChan: make(chan *dataRequest, 10),
+		dataFailChan:      make(chan *dataFailure, 10),
+	}
+	fw.SetChildResponseValidator(func(childID, epoch uint64, data []byte) error {
+		if string(data) == "NaN" {
+			return fmt.Errorf("child %d sent NaN", childID)
+		}
+		return nil
+	})
+	fw.SetMaxChildResponseRetries(1)
+
+	dr := &dataRequest{taskID: 1, epoch: 0, req: "gradient"}
+	if !fw.validateChildResponse(dr, []byte("0.5")) {
+		t.Errorf("valid response rejected")
+	}
+	// Rejected once, the request is sent again.
+	if fw.validateChildResponse(dr, []byte("NaN")) {
+		t.Fatalf("NaN response accepted")
+	}
+	select {
+	case r := <-fw.dataReqtoSendChan:
+		if r.taskID != 1 || r.req != "gradient" {
+			t.Errorf("resent request = %+v, want %+v", r, dr)
+		}
+	default:
+		t.Fatalf("rejected request wasn't sent again")
+	}
+	// Rejected twice, the request fails.
+	if fw.validateChildResponse(dr, []byte("NaN")) {
+		t.Fatalf("NaN response accepted")
+	}
+	select {
+	case fail := <-fw.dataFailChan:
+		if fail.taskID != 1 || fail.err == nil {
+			t.Errorf("failure = %+v, want one of task 1", fail)
+		}
+	default:
+		t.Fatalf("request didn't fail after the retries")
+	}
+	if len(fw.dataReqtoSendChan) != 0 {
+		t.Errorf("request sent again after the retries")
+	}
+}
+
+func TestRotateEtcdCredentials(t *testing.T) {
+	job := "TestRotateEtcdCredentials"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	epochChan := make(chan uint64, 10)
+	fw.SetTaskBuilder(&testableTaskBuilder{epochChan: epochChan})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	fw.SetEtcdCredentials("root", "old")
+	go fw.Start()
+	defer fw.ShutdownJob()
+
+	// The test etcd doesn't check credentials, so the job has to go on
+	// no matter which ones are used.
+	for epoch := uint64(0); ; epoch++ {
+		select {
+		case get := <-epochChan:
+			if get != epoch {
+				t.Fatalf("epoch set = %d, want %d", get, epoch)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("SetEpoch(%d) wasn't called", epoch)
+		}
+		if epoch == 3 {
+			break
+		}
+		creds := meritop.Credentials{Username: "root", Password: fmt.Sprintf("new%d", epoch)}
+		if err := fw.RotateEtcdCredentials(creds); err != nil {
+			t.Fatalf("RotateEtcdCredentials failed: %v", err)
+		}
+		fw.incEpoch(epoch)
+	}
+}
+
+func TestRotateEtcdCredentialsRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "root" || pass != "secret" {
+			http.Error(w, `{"message":"Insufficient credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	client := etcd.NewClient([]string{ts.URL})
+	client.SetCredentials("root", "secret")
+	fw := &framework{
+		etcdURLs:     []string{ts.URL},
+		etcdClient:   client,
+		watchClient:  client,
+		etcdUsername: "root",
+		etcdPassword: "secret",
+		log:          log.New(ioutil.Discard, "", 0),
+	}
+	err := fw.RotateEtcdCredentials(meritop.Credentials{Username: "root", Password: "wrong"})
+	if err != meritop.ErrAuthenticationFailed {
+		t.Errorf("RotateEtcdCredentials = %v, want %v", err, meritop.ErrAuthenticationFailed)
+	}
+	if fw.etcdPassword != "secret" {
+		t.Errorf("password = %q after rejected rotation, want the old one", fw.etcdPassword)
+	}
+	if err := fw.checkEtcdConnection(); err != nil {
+		t.Errorf("checkEtcdConnection = %v after rejected rotation, want nil", err)
+	}
+}
+
+func TestGetEtcdHealth(t *testing.T) {
+	job := "TestGetEtcdHealth"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	fw := &framework{etcdURLs: []string{"http://127.0.0.1:1", m.URL()}}
+
+	// The single member elects itself a little after it starts.
+	var h meritop.EtcdHealth
+	var err error
+	for i := 0; i < 50 && !h.IsHealthy; i++ {
+		if h, err = fw.GetEtcdHealth(); err != nil {
+			t.Fatalf("GetEtcdHealth failed: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !h.IsHealthy || len(h.Members) != 1 || h.Leader != h.Members[0] || h.RTT <= 0 {
+		t.Fatalf("health = %+v, want a single healthy member that leads", h)
+	}
+	if err := fw.checkEtcdHealth(); err != nil {
+		t.Fatalf("checkEtcdHealth failed: %v", err)
+	}
+
+	down := &framework{etcdURLs: []string{"http://127.0.0.1:1"}}
+	if _, err := down.GetEtcdHealth(); err == nil {
+		t.Fatalf("GetEtcdHealth succeeded without etcd")
+	}
+	ts := httptest.NewServer(frameworkhttp.NewHealthHandler(down.checkEtcdHealth))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + frameworkhttp.HealthPrefix)
+	if err != nil {
+		t.Fatalf("http.Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestOnShutdown(t *testing.T) {
+	job := "TestOnShutdown"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	var wg sync.WaitGroup
+	fw.SetTaskBuilder(&testableTaskBuilder{
+		setupLatch: &wg,
+	})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	causes := make(chan meritop.ShutdownCause, 2)
+	fw.SetOnShutdown(func(cause meritop.ShutdownCause) { causes <- cause })
+	wg.Add(1)
+	done := make(chan struct{})
+	go func() {
+		fw.Start()
+		close(done)
+	}()
+	wg.Wait()
+
+	fw.ShutdownJobWithCause(meritop.EpochTimeout)
+	<-done
+	close(causes)
+	if c := <-causes; c != meritop.EpochTimeout {
+		t.Fatalf("cause = %d, want %d", c, meritop.EpochTimeout)
+	}
+	if _, ok := <-causes; ok {
+		t.Fatalf("shutdown callback called more than once")
+	}
+}
+
+func TestInMemoryCoordinationBackend(t *testing.T) {
+	job := "TestInMemoryCoordinationBackend"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcdURLs := []string{m.URL()}
+	controller := controller.New(job, etcd.NewClient(etcdURLs), 1)
+	controller.Start()
+	defer controller.Stop()
+
+	// Tasks are still taken over and send heartbeats through etcd, see
+	// Bootstrap.SetCoordinationBackend, so etcd is needed nonetheless.
+	ctx := gocontext.Background()
+	backend := metastore.NewInMemoryBackend()
+	fw := &framework{
+		name:     job,
+		etcdURLs: etcdURLs,
+		ln:       createListener(t),
+	}
+	epochKey := fw.epochKey()
+	backend.Put(ctx, epochKey, "0")
+	if err := backend.AtomicCompareAndSwap(ctx, epochKey, "1", "2"); err != meritop.ErrCompareFailed {
+		t.Errorf("AtomicCompareAndSwap = %v, want %v", err, meritop.ErrCompareFailed)
+	}
+	if err := backend.CreateEphemeral(ctx, epochKey, "0", time.Second); err != meritop.ErrKeyExists {
+		t.Errorf("CreateEphemeral = %v, want %v", err, meritop.ErrKeyExists)
+	}
+
+	epochChan := make(chan uint64, 10)
+	fw.SetTaskBuilder(&testableTaskBuilder{epochChan: epochChan})
+	fw.SetTopology(example.NewTreeTopology(2, 1))
+	fw.SetCoordinationBackend(backend)
+	done := make(chan struct{})
+	go func() {
+		fw.Start()
+		close(done)
+	}()
+	for _, want := range []uint64{0, 1} {
+		select {
+		case epoch := <-epochChan:
+			if epoch != want {
+				t.Fatalf("epoch = %d, want %d", epoch, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("SetEpoch(%d) wasn't called", want)
+		}
+		if want == 0 {
+			// The epoch lives in the backend only.
+			if err := backend.AtomicCompareAndSwap(ctx, epochKey, "0", "1"); err != nil {
+				t.Fatalf("AtomicCompareAndSwap failed: %v", err)
+			}
+		}
+	}
+	if addr, err := fw.GetPeerAddress(0); err != nil || addr != fw.ln.Addr().String() {
+		t.Errorf("GetPeerAddress(0) = %q, %v, want %q", addr, err, fw.ln.Addr())
+	}
+	fw.ShutdownJob()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task didn't stop after ShutdownJob")
+	}
+	if _, err := fw.GetPeerAddress(0); err != meritop.ErrKeyNotFound {
+		t.Errorf("GetPeerAddress(0) after stop = %v, want %v", err, meritop.ErrKeyNotFound)
+	}
+}
+
+func TestCountDownLatchReset(t *testing.T) {
+	c := newCountDownLatch(1)
+	c.CountDown()
+	c.Await()
+
+	c.Reset(2)
+	if c.Count() != 2 {
+		t.Fatalf("Count() = %d, want 2", c.Count())
+	}
+	done := make(chan struct{})
+	go func() {
+		c.Await()
+		close(done)
+	}()
+	c.CountDown()
+	c.CountDown()
+	<-done
+}
+
+func TestCountDownLatchCounterType(t *testing.T) {
+	c := newCountDownLatch(int8(2))
+	c.CountDown()
+	c.CountDown()
+	c.CountDown()
+	if c.Count() != 0 {
+		t.Errorf("Count() = %d, want 0", c.Count())
+	}
+	c.Await()
+
+	u := newCountDownLatch(uint64(1))
+	u.CountDown()
+	u.CountDown()
+	if u.Count() != 0 {
+		t.Errorf("Count() = %d, want 0", u.Count())
+	}
+	u.Await()
+}
+
+func TestTraceBufferWrap(t *testing.T) {
+	b := newTraceBuffer(3)
+	for i := uint64(0); i < 5; i++ {
+		b.record(meritop.TraceRequestIssued, i, 0, "", nil)
+	}
+	events := b.dump()
+	if len(events) != 3 {
+		t.Fatalf("len(dump()) = %d, want 3", len(events))
+	}
+	for i, ev := range events {
+		if ev.TaskID != uint64(i+2) {
+			t.Errorf("event %d: TaskID = %d, want %d", i, ev.TaskID, i+2)
+		}
+	}
+
+	var disabled *traceBuffer
+	disabled.record(meritop.TraceError, 0, 0, "", nil)
+	if events := disabled.dump(); len(events) != 0 {
+		t.Errorf("disabled dump() = %v, want empty", events)
+	}
+}
+
+func TestTraceBufferConcurrent(t *testing.T) {
+	const writers, perWriter, size = 4, 1000, 16
+	b := newTraceBuffer(size)
+	// check fails unless the events of each writer are in the order they
+	// were recorded, with the epoch as sequence number.
+	check := func(events []meritop.TraceEvent) {
+		last := make(map[uint64]uint64)
+		for i, ev := range events {
+			if seq, ok := last[ev.TaskID]; ok && ev.Epoch <= seq {
+				t.Fatalf("event %d: epoch %d of task %d after %d", i, ev.Epoch, ev.TaskID, seq)
+			}
+			last[ev.TaskID] = ev.Epoch
+			if i > 0 && ev.Time < events[i-1].Time {
+				t.Fatalf("event %d recorded before event %d", i, i-1)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := uint64(0); w < writers; w++ {
+		wg.Add(1)
+		go func(w uint64) {
+			defer wg.Done()
+			for i := uint64(0); i < perWriter; i++ {
+				b.record(meritop.TraceRequestIssued, w, i, "", nil)
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			events := b.dump()
+			if len(events) != size {
+				t.Fatalf("len(dump()) = %d, want %d", len(events), size)
+			}
+			check(events)
+			return
+		default:
+			check(b.dump())
+		}
+	}
+}
+
+type tDataBundle struct {
+	id   uint64
+	meta string
+	req  string
+	resp []byte
+}
+
+type testableTaskBuilder struct {
+	dataMap    map[string][]byte
+	cDataChan  chan *tDataBundle
+	pDataChan  chan *tDataBundle
+	setupLatch *sync.WaitGroup
+	epochChan  chan uint64
+	abortChan  chan string
+	cmdChan    chan meritop.Command
+	retryChan  chan uint64
+}
+
+func (b *testableTaskBuilder) GetTask(taskID uint64) meritop.Task {
+	switch taskID {
+	case 0:
+		return &testableTask{dataMap: b.dataMap, dataChan: b.cDataChan,
+			setupLatch: b.setupLatch, epochChan: b.epochChan, abortChan: b.abortChan,
+			cmdChan: b.cmdChan, retryChan: b.retryChan}
+	case 1:
+		return &testableTask{dataMap: b.dataMap, dataChan: b.pDataChan,
+			setupLatch: b.setupLatch}
+	default:
+		panic("unimplemented")
+	}
+}
+
+type testableTask struct {
+	id         uint64
+	framework  meritop.Framework
+	setupLatch *sync.WaitGroup
+	// dataMap will be used to serve data according to request
+	dataMap map[string][]byte
+
+	// This channel is used to convey data passed from framework back to the main
+	// thread, for checking. Thus it's initialized and passed in from outside.
+	//
+	// The basic idea is that there are only two nodes -- one parent and one child.
+	// When this channel is for parent, it passes information from child.
+	dataChan chan *tDataBundle
+	// gets the epochs the task is set to, if not nil.
+	epochChan chan uint64
+	// gets the reasons epochs are aborted for, if not nil.
+	abortChan chan string
+	// gets the commands sent to the task, if not nil.
+	cmdChan chan meritop.Command
+	// gets the attempts epochs are started over with, if not nil.
+	retryChan chan uint64
+}
+
+func (t *testableTask) Init(taskID uint64, framework meritop.Framework) {
+	t.id = taskID
+	t.framework = framework
+	if t.setupLatch != nil {
+		t.setupLatch.Done()
+	}
+}
+func (t *testableTask) Exit() {}
+func (t *testableTask) SetEpoch(ctx meritop.Context, epoch uint64) {
+	if t.epochChan != nil {
+		t.epochChan <- epoch
+	}
+}
+
+func (t *testableTask) EpochAborted(ctx meritop.Context, epoch uint64, reason string) {
+	if t.abortChan != nil {
+		t.abortChan <- reason
+	}
+}
+
+func (t *testableTask) EpochRetry(ctx meritop.Context, epoch, attempt uint64) {
+	if t.retryChan != nil {
+		t.retryChan <- attempt
+	}
+}
+
+func (t *testableTask) HandleCommand(ctx meritop.Context, cmd meritop.Command) error {
+	if t.cmdChan != nil {
+		t.cmdChan <- cmd
+	}
+	return nil
+}
+
+func (t *testableTask) ParentMetaReady(ctx meritop.Context, fromID uint64, meta string) {
+	if t.dataChan != nil {
+		t.dataChan <- &tDataBundle{fromID, meta, "", nil}
+	}
+}
+
+func (t *testableTask) ChildMetaReady(ctx meritop.Context, fromID uint64, meta string) {
+	t.ParentMetaReady(ctx, fromID, meta)
+}
+
+func (t *testableTask) ServeAsParent(fromID uint64, req string) []byte {
+	if t.dataChan != nil {
+		t.dataChan <- &tDataBundle{fromID, "", req, nil}
+	}
+	return t.dataMap[req]
+}
 func (t *testableTask) ServeAsChild(fromID uint64, req string) []byte {
 	return t.ServeAsParent(fromID, req)
 }
@@ -321,6 +3386,16 @@ func (t *testableTask) ChildDataReady(ctx meritop.Context, fromID uint64, req st
 	t.ParentDataReady(ctx, fromID, req, resp)
 }
 
+func (t *testableTask) ParentPushReceived(ctx meritop.Context, fromID uint64, data []byte) {
+	t.ParentDataReady(ctx, fromID, "", data)
+}
+
+func (t *testableTask) DataRequestFailed(ctx meritop.Context, toID uint64, req string, err error) {
+	if t.dataChan != nil {
+		t.dataChan <- &tDataBundle{toID, "failed", req, nil}
+	}
+}
+
 func createListener(t *testing.T) net.Listener {
 	l, err := net.Listen("tcp4", "127.0.0.1:0")
 	if err != nil {
@@ -0,0 +1,68 @@
+package frameworkhttp
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Client reaches the HTTP servers of other tasks. A nil *Client talks plain
+// HTTP.
+type Client struct {
+	client *http.Client
+	scheme string
+	// if set, called with the number of bytes after every read of a
+	// response body.
+	recvWait func(n int)
+}
+
+// NewClient returns a client talking HTTPS with the given TLS config, or
+// plain HTTP if it's nil.
+func NewClient(tlsConfig *tls.Config) *Client {
+	if tlsConfig == nil {
+		return &Client{client: http.DefaultClient, scheme: "http"}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	return &Client{client: &http.Client{Transport: t}, scheme: "https"}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	return c.client
+}
+
+func (c *Client) url(addr, path string) url.URL {
+	scheme := "http"
+	if c != nil {
+		scheme = c.scheme
+	}
+	return url.URL{Scheme: scheme, Host: addr, Path: path}
+}
+
+// ThrottleReceive makes reading responses call wait with the number of bytes
+// read, e.g. to block until a rate limiter allows more.
+func (c *Client) ThrottleReceive(wait func(n int)) { c.recvWait = wait }
+
+func (c *Client) body(r io.Reader) io.Reader {
+	if c == nil || c.recvWait == nil {
+		return r
+	}
+	return &throttledReader{r: r, wait: c.recvWait}
+}
+
+type throttledReader struct {
+	r    io.Reader
+	wait func(n int)
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.wait(n)
+	}
+	return n, err
+}
@@ -1,17 +1,24 @@
 package frameworkhttp
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"strconv"
+	"time"
 )
 
 var (
 	ErrReqEpochMismatch error = errors.New("data request error: epoch mismatch")
 	ErrServerClosed     error = errors.New("server has been closed")
+	ErrMessageTooLarge  error = errors.New("data request error: message too large")
 )
 
 const (
@@ -19,12 +26,18 @@ const (
 	DataRequestTaskID string = "taskID"
 	DataRequestReq    string = "req"
 	DataRequestEpoch  string = "epoch"
+	DataPushPrefix    string = "/datapush"
+	DataBatchPrefix   string = "/datareqbatch"
 )
 
 type DataGetter interface {
 	GetTaskData(uint64, uint64, string) ([]byte, error)
 }
 
+type DataPusher interface {
+	PushTaskData(uint64, uint64, []byte) error
+}
+
 type dataReqHandler struct {
 	logger *log.Logger
 	DataGetter
@@ -35,6 +48,9 @@ type DataResponse struct {
 	Epoch  uint64
 	Req    string
 	Data   []byte
+	// Attempt is set by the requester to the attempt of the epoch it made
+	// the request in. It isn't sent over the wire.
+	Attempt uint64
 }
 
 func NewDataRequestHandler(logger *log.Logger, dg DataGetter) http.Handler {
@@ -66,10 +82,14 @@ func (h *dataReqHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	b, err := h.GetTaskData(fromID, epoch, req)
 	if err != nil {
 		if err == ErrReqEpochMismatch || err == ErrServerClosed {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusConflict)
 			w.Write([]byte(err.Error()))
 			return
 		}
+		if err == ErrMessageTooLarge {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
 		h.logger.Panic("unimplemented")
 	}
 	if _, err := w.Write(b); err != nil {
@@ -77,12 +97,11 @@ func (h *dataReqHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func RequestData(addr string, req string, from, to, epoch uint64, logger *log.Logger) (*DataResponse, error) {
-	u := url.URL{
-		Scheme: "http",
-		Host:   addr,
-		Path:   DataRequestPrefix,
-	}
+// RequestData fails with ErrMessageTooLarge instead of reading responses
+// larger than maxSize bytes. A maxSize of 0 means no limit. The request is
+// given up once ctx is done.
+func RequestData(ctx context.Context, c *Client, addr string, req string, from, to, epoch uint64, maxSize int64, logger *log.Logger) (*DataResponse, error) {
+	u := c.url(addr, DataRequestPrefix)
 	q := u.Query()
 	q.Add(DataRequestTaskID, strconv.FormatUint(from, 10))
 	q.Add(DataRequestReq, req)
@@ -91,7 +110,11 @@ func RequestData(addr string, req string, from, to, epoch uint64, logger *log.Lo
 	urlStr := u.String()
 	// send request
 	// pass the response to the awaiting event loop for data response
-	resp, err := http.Get(urlStr)
+	httpReq, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(httpReq.WithContext(ctx))
 	if err != nil {
 		// The error could be caused because: 1. network failure; 2. We might have
 		// sent request to failed server.
@@ -99,15 +122,29 @@ func RequestData(addr string, req string, from, to, epoch uint64, logger *log.Lo
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusInternalServerError {
-			// Now assuming only epoch mismatch can cause this error.
+		switch resp.StatusCode {
+		case http.StatusConflict:
 			return nil, ErrReqEpochMismatch
+		case http.StatusRequestEntityTooLarge:
+			return nil, ErrMessageTooLarge
 		}
 		logger.Fatalf("http: response code = %d, expect = %d", resp.StatusCode, 200)
 	}
-	data, err := ioutil.ReadAll(resp.Body)
+	body := c.body(resp.Body)
+	if maxSize > 0 {
+		if resp.ContentLength > maxSize {
+			return nil, ErrMessageTooLarge
+		}
+		// Read one byte more than allowed to tell if the limit was exceeded.
+		body = io.LimitReader(body, maxSize+1)
+	}
+	data, err := ioutil.ReadAll(body)
 	if err != nil {
-		logger.Fatalf("http: ioutil.ReadAll(%v) returns error: %v", resp.Body, err)
+		// e.g. ctx is done before the whole response came.
+		return nil, err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, ErrMessageTooLarge
 	}
 	return &DataResponse{
 		TaskID: to,
@@ -116,3 +153,292 @@ func RequestData(addr string, req string, from, to, epoch uint64, logger *log.Lo
 		Data:   data,
 	}, nil
 }
+
+type dataBatchHandler struct {
+	logger *log.Logger
+	DataGetter
+}
+
+func NewDataBatchHandler(logger *log.Logger, dg DataGetter) http.Handler {
+	return &dataBatchHandler{
+		logger:     logger,
+		DataGetter: dg,
+	}
+}
+
+// Each batch will be in the format: "POST /datareqbatch?taskID=XXX&epoch=XXX"
+// with the JSON array of requests in http body. The responses are written in
+// the order of the requests, each as its length in 8 bytes big endian
+// followed by the data. The batch fails as a whole if any request fails.
+func (h *dataBatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != DataBatchPrefix || r.Method != "POST" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	fromID, err := strconv.ParseUint(q.Get(DataRequestTaskID), 0, 64)
+	if err != nil {
+		http.Error(w, "taskID couldn't be parsed", http.StatusBadRequest)
+		return
+	}
+	epoch, err := strconv.ParseUint(q.Get(DataRequestEpoch), 0, 64)
+	if err != nil {
+		http.Error(w, "epoch couldn't be parsed", http.StatusBadRequest)
+		return
+	}
+	var reqs []string
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Requests are served concurrently, as they would be one by one.
+	data := make([][]byte, len(reqs))
+	errs := make([]error, len(reqs))
+	done := make(chan struct{})
+	for i, req := range reqs {
+		go func(i int, req string) {
+			data[i], errs[i] = h.GetTaskData(fromID, epoch, req)
+			done <- struct{}{}
+		}(i, req)
+	}
+	for range reqs {
+		<-done
+	}
+	for _, err := range errs {
+		switch err {
+		case nil:
+			continue
+		case ErrReqEpochMismatch, ErrServerClosed:
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(err.Error()))
+		case ErrMessageTooLarge:
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	for _, b := range data {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+		w.Write(size[:])
+		if _, err := w.Write(b); err != nil {
+			log.Printf("http: response write failed: %v", err)
+			return
+		}
+	}
+}
+
+// BatchRequestData sends all requests to the task in one round trip and
+// returns their responses in order. Like RequestData, it fails with
+// ErrMessageTooLarge if a response is larger than maxSize bytes, and gives
+// up once ctx is done.
+func BatchRequestData(ctx context.Context, c *Client, addr string, reqs []string, from, to, epoch uint64, maxSize int64, logger *log.Logger) ([]*DataResponse, error) {
+	u := c.url(addr, DataBatchPrefix)
+	q := u.Query()
+	q.Add(DataRequestTaskID, strconv.FormatUint(from, 10))
+	q.Add(DataRequestEpoch, strconv.FormatUint(epoch, 10))
+	u.RawQuery = q.Encode()
+	body, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient().Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusConflict:
+		return nil, ErrReqEpochMismatch
+	case http.StatusRequestEntityTooLarge:
+		return nil, ErrMessageTooLarge
+	default:
+		return nil, responseError(resp)
+	}
+
+	r := c.body(resp.Body)
+	res := make([]*DataResponse, len(reqs))
+	for i, req := range reqs {
+		var size [8]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint64(size[:])
+		if maxSize > 0 && n > uint64(maxSize) {
+			return nil, ErrMessageTooLarge
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		res[i] = &DataResponse{
+			TaskID: to,
+			Epoch:  epoch,
+			Req:    req,
+			Data:   data,
+		}
+	}
+	return res, nil
+}
+
+type dataPushHandler struct {
+	logger  *log.Logger
+	maxSize int64
+	DataPusher
+}
+
+// NewDataPushHandler replies 413 instead of reading pushed data larger than
+// maxSize bytes. A maxSize of 0 means no limit.
+func NewDataPushHandler(logger *log.Logger, dp DataPusher, maxSize int64) http.Handler {
+	return &dataPushHandler{
+		logger:     logger,
+		maxSize:    maxSize,
+		DataPusher: dp,
+	}
+}
+
+// Each push will be in the format: "POST /datapush?taskID=XXX&epoch=XXX" with
+// the pushed data in http body.
+func (h *dataPushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != DataPushPrefix || r.Method != "POST" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	fromID, err := strconv.ParseUint(q.Get(DataRequestTaskID), 0, 64)
+	if err != nil {
+		http.Error(w, "taskID couldn't be parsed", http.StatusBadRequest)
+		return
+	}
+	epoch, err := strconv.ParseUint(q.Get(DataRequestEpoch), 0, 64)
+	if err != nil {
+		http.Error(w, "epoch couldn't be parsed", http.StatusBadRequest)
+		return
+	}
+	body := r.Body
+	if h.maxSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, h.maxSize)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, ErrMessageTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.PushTaskData(fromID, epoch, data); err != nil {
+		if err == ErrReqEpochMismatch || err == ErrServerClosed {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func PushData(c *Client, addr string, from, epoch uint64, data []byte) error {
+	u := c.url(addr, DataPushPrefix)
+	q := u.Query()
+	q.Add(DataRequestTaskID, strconv.FormatUint(from, 10))
+	q.Add(DataRequestEpoch, strconv.FormatUint(epoch, 10))
+	u.RawQuery = q.Encode()
+	resp, err := c.httpClient().Post(u.String(), "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		return ErrReqEpochMismatch
+	case http.StatusRequestEntityTooLarge:
+		return ErrMessageTooLarge
+	default:
+		return responseError(resp)
+	}
+}
+
+// responseError describes a failed response by its status code and the
+// start of its body, which has the error of the handler.
+func responseError(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("http: response code = %d, expect = %d: %s",
+		resp.StatusCode, http.StatusOK, bytes.TrimSpace(msg))
+}
+
+// HealthPrefix is where a task answers health probes, e.g. of Kubernetes:
+// 200 if it's healthy, 503 with the reason if not.
+const HealthPrefix string = "/healthz"
+
+type healthHandler struct {
+	check func() error
+}
+
+func NewHealthHandler(check func() error) http.Handler {
+	return &healthHandler{check: check}
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// PingPrefix is where a task answers pings with its task ID, so that others
+// can check they reach it.
+const PingPrefix string = "/ping"
+
+type pingHandler struct {
+	taskID func() uint64
+}
+
+func NewPingHandler(taskID func() uint64) http.Handler {
+	return &pingHandler{taskID: taskID}
+}
+
+func (h *pingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != PingPrefix {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(strconv.FormatUint(h.taskID(), 10)))
+}
+
+// Ping returns the ID of the task serving at addr. It fails if there's no
+// answer within timeout.
+func Ping(c *Client, addr string, timeout time.Duration) (uint64, error) {
+	u := c.url(addr, PingPrefix)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("http: response code = %d, expect = %d", resp.StatusCode, 200)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
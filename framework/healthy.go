@@ -1,21 +1,260 @@
 package framework
 
 import (
+	gocontext "context"
+	"path"
+	"runtime"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
 )
 
-var (
-	heartbeatInterval = 1 * time.Second
-)
+// TTL of the etcd keys kept alive by heartbeats, e.g. the healthy key of a
+// task, unless set otherwise. A task is taken for dead once its key expired.
+const defaultEtcdLeaseTTL = 3 * time.Second
+
+// a heartbeat every third of the TTL keeps the keys alive through a missed one.
+const defaultHeartbeatInterval = defaultEtcdLeaseTTL / 3
+
+func (f *framework) SetEtcdLeaseTTL(d time.Duration) { f.etcdLeaseTTL = d }
+
+func (f *framework) heartbeatInterval() time.Duration {
+	switch {
+	case f.etcdLeaseTTL == 0:
+		return defaultHeartbeatInterval
+	case f.etcdLeaseTTL < time.Second:
+		return time.Second / 3
+	}
+	return f.etcdLeaseTTL / 3
+}
 
 func (f *framework) heartbeat() {
 	f.heartbeatStop = make(chan struct{})
+	cpu := &cpuSampler{}
+	cpu.usage()
+	status := func() string {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		return etcdutil.TaskStatus{
+			Epoch:           f.loadEpoch(),
+			ErrorCount:      atomic.LoadUint64(&f.errorCount),
+			CPUUsage:        cpu.usage(),
+			MemoryUsage:     mem.HeapAlloc + mem.StackInuse,
+			PendingRequests: uint64(atomic.LoadInt64(&f.pendingRequests)),
+			PendingIncoming: uint64(atomic.LoadInt64(&f.pendingIncoming)),
+			PendingMeta:     uint64(atomic.LoadInt64(&f.pendingMeta)),
+			NodeID:          f.nodeID,
+		}.String()
+	}
 	go func() {
-		err := etcdutil.Heartbeat(f.etcdClient, f.name, f.taskID, heartbeatInterval, f.heartbeatStop)
+		err := etcdutil.HeartbeatWithStatus(f.etcdClient, f.jobPath(), f.taskID, f.heartbeatInterval(), status, f.heartbeatStop)
 		if err != nil {
 			f.log.Printf("Heartbeat stops with error: %v\n", err)
 		}
 	}()
+	if f.heartbeatHandler != nil {
+		go f.dispatchHeartbeats(f.heartbeatStop)
+	}
+}
+
+func (f *framework) SetHeartbeatHandler(fn func(taskID uint64, status meritop.HeartbeatStatus)) {
+	f.heartbeatHandler = fn
+}
+
+// dispatchHeartbeats passes the status of every live task to the heartbeat
+// handler once per heartbeat interval until stop.
+func (f *framework) dispatchHeartbeats(stop chan struct{}) {
+	for {
+		select {
+		case <-time.After(f.heartbeatInterval()):
+		case <-stop:
+			return
+		}
+		statuses, err := f.taskStatuses()
+		if err != nil {
+			f.log.Printf("task %d failed to list heartbeats: %v", f.taskID, err)
+			continue
+		}
+		for id, s := range statuses {
+			f.heartbeatHandler(id, meritop.HeartbeatStatus{
+				CPUUsage:        s.CPUUsage,
+				MemoryUsage:     s.MemoryUsage,
+				CurrentEpoch:    s.Epoch,
+				PendingRequests: int(s.PendingRequests),
+			})
+		}
+	}
+}
+
+// GetMemoryUsage reports the memory in use by every live task as of its
+// last heartbeat.
+func (f *framework) GetMemoryUsage() (map[uint64]uint64, error) {
+	statuses, err := f.taskStatuses()
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[uint64]uint64, len(statuses))
+	for id, s := range statuses {
+		usage[id] = s.MemoryUsage
+	}
+	return usage, nil
+}
+
+// GetQueueDepths reports the operations every live task had pending as of
+// its last heartbeat.
+func (f *framework) GetQueueDepths() (map[uint64]meritop.QueueDepth, error) {
+	statuses, err := f.taskStatuses()
+	if err != nil {
+		return nil, err
+	}
+	depths := make(map[uint64]meritop.QueueDepth, len(statuses))
+	for id, s := range statuses {
+		depths[id] = meritop.QueueDepth{
+			PendingIncoming:  int(s.PendingIncoming),
+			PendingOutgoing:  int(s.PendingRequests),
+			PendingMetaFlags: int(s.PendingMeta),
+		}
+	}
+	return depths, nil
+}
+
+// taskStatuses returns the status every live task reported with its last
+// heartbeat.
+func (f *framework) taskStatuses() (map[uint64]etcdutil.TaskStatus, error) {
+	beats, err := f.listDir(etcdutil.HealthyPath(f.jobPath()))
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[uint64]etcdutil.TaskStatus, len(beats))
+	for idStr, beat := range beats {
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		// A node that has just occupied the task hasn't reported its status yet.
+		s, err := etcdutil.ParseTaskStatus(beat.Value)
+		if err != nil {
+			continue
+		}
+		statuses[id] = s
+	}
+	return statuses, nil
+}
+
+// cpuSampler measures the CPU used by this process between two calls.
+type cpuSampler struct {
+	lastCPU  time.Duration
+	lastWall time.Time
+}
+
+// usage returns the CPU time spent per wall time since the last call,
+// e.g. 1.5 means one and a half CPUs were busy on average. It's 0 where the
+// CPU time of the process isn't known.
+func (s *cpuSampler) usage() float64 {
+	cpu, ok := processCPUTime()
+	if !ok {
+		return 0
+	}
+	now := time.Now()
+	var u float64
+	if !s.lastWall.IsZero() && now.After(s.lastWall) {
+		u = float64(cpu-s.lastCPU) / float64(now.Sub(s.lastWall))
+	}
+	s.lastCPU, s.lastWall = cpu, now
+	return u
+}
+
+func (f *framework) WaitForAllTasks(timeout time.Duration) error {
+	taskIDs, err := etcdutil.ListTaskIDs(f.etcdClient, f.jobPath())
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		beats, err := f.listDir(etcdutil.HealthyPath(f.jobPath()))
+		if err != nil {
+			return err
+		}
+		var missing []uint64
+		for _, id := range taskIDs {
+			if _, ok := beats[strconv.FormatUint(id, 10)]; !ok {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		if time.Now().Add(pingRetryInterval).After(deadline) {
+			return &meritop.MissingTasksError{Missing: missing}
+		}
+		time.Sleep(pingRetryInterval)
+	}
+}
+
+func (f *framework) GetTopologyHealth() ([]meritop.NodeHealth, error) {
+	taskIDs, err := etcdutil.ListTaskIDs(f.etcdClient, f.jobPath())
+	if err != nil {
+		return nil, err
+	}
+	value, err := f.backend.Get(gocontext.TODO(), f.epochKey())
+	if err != nil {
+		return nil, err
+	}
+	jobEpoch, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	// Both directories are empty (and thus might not exist) when every task
+	// is dead or, respectively, when every task is occupied.
+	beats, err := f.listDir(etcdutil.HealthyPath(f.jobPath()))
+	if err != nil {
+		return nil, err
+	}
+	frees, err := f.listDir(etcdutil.FreeTaskDir(f.jobPath()))
+	if err != nil {
+		return nil, err
+	}
+
+	report := make([]meritop.NodeHealth, len(taskIDs))
+	for i, id := range taskIDs {
+		h := meritop.NodeHealth{TaskID: id, Status: meritop.NodeDead}
+		idStr := strconv.FormatUint(id, 10)
+		if beat, ok := beats[idStr]; ok {
+			h.Status = meritop.NodeRunning
+			h.LastHeartbeat = etcdutil.LastHeartbeat(beat, f.heartbeatInterval())
+			// A node that has just occupied the task hasn't reported its status yet.
+			if s, err := etcdutil.ParseTaskStatus(beat.Value); err == nil {
+				h.CurrentEpoch = s.Epoch
+				h.ErrorCount = int(s.ErrorCount)
+				h.NodeID = s.NodeID
+				if s.Epoch < jobEpoch {
+					h.Status = meritop.NodeLagging
+				}
+			}
+		} else if free, ok := frees[idStr]; ok && free.Value == "failed" {
+			h.Status = meritop.NodeRestarting
+		}
+		report[i] = h
+	}
+	return report, nil
+}
+
+// listDir returns nodes under the given etcd directory by their base name.
+func (f *framework) listDir(dir string) (map[string]*etcd.Node, error) {
+	resp, err := f.etcdClient.Get(dir, false, false)
+	if err != nil {
+		if etcdutil.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	nodes := make(map[string]*etcd.Node, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		nodes[path.Base(n.Key)] = n
+	}
+	return nodes, nil
 }
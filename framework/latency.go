@@ -0,0 +1,95 @@
+package framework
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+const (
+	// latencies of the last maxLatencySamples requests to each task are kept.
+	maxLatencySamples = 1024
+	minLatencySamples = 10
+)
+
+// latencyRecorder keeps the latencies of recent data requests by the task
+// they were sent to.
+type latencyRecorder struct {
+	mu    sync.Mutex
+	peers map[uint64]*latencyWindow
+}
+
+type latencyWindow struct {
+	samples []time.Duration
+	// where the next sample goes once the window is full.
+	next int
+}
+
+func (r *latencyRecorder) record(taskID uint64, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.peers == nil {
+		r.peers = make(map[uint64]*latencyWindow)
+	}
+	w, ok := r.peers[taskID]
+	if !ok {
+		w = &latencyWindow{}
+		r.peers[taskID] = w
+	}
+	if len(w.samples) < maxLatencySamples {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % maxLatencySamples
+}
+
+// last returns the latency of the latest request sent to taskID.
+func (r *latencyRecorder) last(taskID uint64) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.peers[taskID]
+	if !ok || len(w.samples) == 0 {
+		return 0, false
+	}
+	if len(w.samples) < maxLatencySamples {
+		return w.samples[len(w.samples)-1], true
+	}
+	return w.samples[(w.next+maxLatencySamples-1)%maxLatencySamples], true
+}
+
+func (r *latencyRecorder) percentile(taskID uint64, p float64) (time.Duration, error) {
+	if p < 0 || p > 100 {
+		return 0, fmt.Errorf("percentile %v not in [0, 100]", p)
+	}
+	r.mu.Lock()
+	var samples []time.Duration
+	if w, ok := r.peers[taskID]; ok {
+		samples = append(samples, w.samples...)
+	}
+	r.mu.Unlock()
+	if len(samples) < minLatencySamples {
+		return 0, meritop.ErrInsufficientData
+	}
+	sort.Sort(durationSlice(samples))
+	// nearest rank
+	i := int(math.Ceil(p/100*float64(len(samples)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return samples[i], nil
+}
+
+type durationSlice []time.Duration
+
+func (s durationSlice) Len() int           { return len(s) }
+func (s durationSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s durationSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (f *framework) GetLatencyPercentile(taskID uint64, percentile float64) (time.Duration, error) {
+	return f.latencies.percentile(taskID, percentile)
+}
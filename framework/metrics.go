@@ -0,0 +1,49 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+)
+
+// metrics returns a snapshot of the framework metrics by name.
+func (f *framework) metrics() map[string]float64 {
+	return map[string]float64{
+		"meritop_epoch":            float64(f.loadEpoch()),
+		"meritop_errors":           float64(atomic.LoadUint64(&f.errorCount)),
+		"meritop_pending_requests": float64(atomic.LoadInt64(&f.pendingRequests)),
+	}
+}
+
+func (f *framework) ExportMetrics(w io.Writer, format string) error {
+	m := f.metrics()
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch format {
+	case "prometheus":
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "# TYPE %s gauge\n%s{job=%q,task=\"%d\"} %g\n",
+				name, name, f.name, f.taskID, m[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		return json.NewEncoder(w).Encode(m)
+	case "csv":
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "%s,%g\n", name, m[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown metrics format %q", format)
+	}
+}
@@ -0,0 +1,39 @@
+package framework
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// random returns the random number generator of the framework, seeded with
+// the global seed if one was set, or with the current time.
+func (f *framework) random() *rand.Rand {
+	f.randOnce.Do(func() {
+		seed := time.Now().UnixNano()
+		if f.seedSet {
+			seed = f.seed
+		}
+		f.rand = rand.New(&lockedSource{src: rand.NewSource(seed)})
+	})
+	return f.rand
+}
+
+// lockedSource makes a rand.Source safe for concurrent use, like the one
+// behind the top-level functions of math/rand.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
@@ -1,12 +1,12 @@
 package framework
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
 
@@ -39,7 +39,6 @@ type dummyData struct {
 type dummyMaster struct {
 	dataChan           chan int32
 	finishChan         chan struct{}
-	NodeProducer       chan bool
 	framework          meritop.Framework
 	epoch, taskID      uint64
 	logger             *log.Logger
@@ -48,6 +47,7 @@ type dummyMaster struct {
 
 	param, gradient *dummyData
 	fromChildren    map[uint64]*dummyData
+	aggregated      bool
 }
 
 // This is useful to bring the task up to speed from scratch or if it recovers.
@@ -84,16 +84,13 @@ func (t *dummyMaster) SetEpoch(ctx meritop.Context, epoch uint64) {
 
 	// Make sure we have a clean slate.
 	t.fromChildren = make(map[uint64]*dummyData)
+	t.aggregated = false
 	ctx.FlagMetaToChild("ParamReady")
 }
 
 // These are payload rpc for application purpose.
 func (t *dummyMaster) ServeAsParent(fromID uint64, req string) []byte {
-	b, err := json.Marshal(t.param)
-	if err != nil {
-		t.logger.Fatalf("Master can't encode parameter: %v, error: %v\n", t.param, err)
-	}
-	return b
+	return MustMarshal(t.framework.GetSerializer(), t.param)
 }
 
 func (t *dummyMaster) ServeAsChild(fromID uint64, req string) []byte {
@@ -103,7 +100,7 @@ func (t *dummyMaster) ServeAsChild(fromID uint64, req string) []byte {
 func (t *dummyMaster) ParentDataReady(ctx meritop.Context, parentID uint64, req string, resp []byte) {}
 func (t *dummyMaster) ChildDataReady(ctx meritop.Context, childID uint64, req string, resp []byte) {
 	d := new(dummyData)
-	json.Unmarshal(resp, d)
+	t.framework.GetSerializer().Unmarshal(resp, d)
 	if _, ok := t.fromChildren[childID]; ok {
 		return
 	}
@@ -112,12 +109,12 @@ func (t *dummyMaster) ChildDataReady(ctx meritop.Context, childID uint64, req st
 	t.logger.Printf("master ChildDataReady, task: %d, epoch: %d, child: %d, ready: %d\n",
 		t.taskID, t.epoch, childID, len(t.fromChildren))
 
-	// This is a weak form of checking. We can also check the task ids.
-	// But this really means that we get all the events from children, we
-	// should go into the next epoch now.
-	if len(t.fromChildren) == len(t.framework.GetTopology().GetChildren(t.epoch)) {
-		for _, g := range t.fromChildren {
-			t.gradient.Value += g.Value
+	// Once enough children responded (all of them by default), we should go
+	// into the next epoch now. Later responses of this epoch are ignored.
+	if ctx.IsQuorumMet() && !t.aggregated {
+		t.aggregated = true
+		for id, g := range t.fromChildren {
+			t.gradient.Value += int32(ctx.GetChildWeight(id) * float64(g.Value))
 		}
 
 		t.dataChan <- t.gradient.Value
@@ -138,6 +135,21 @@ func (t *dummyMaster) ChildDataReady(ctx meritop.Context, childID uint64, req st
 	}
 }
 
+// The gradient summed up from the children is the aggregate of the epoch.
+func (t *dummyMaster) Aggregate(epoch uint64) []byte {
+	return MustMarshal(t.framework.GetSerializer(), t.gradient)
+}
+
+// The parameter is all the state a master carries between epochs.
+func (t *dummyMaster) Checkpoint(epoch uint64) ([]byte, error) {
+	return t.framework.GetSerializer().Marshal(t.param)
+}
+
+func (t *dummyMaster) Restore(epoch uint64, data []byte) error {
+	t.param = new(dummyData)
+	return t.framework.GetSerializer().Unmarshal(data, t.param)
+}
+
 func (t *dummyMaster) testablyFail(method string, args ...string) bool {
 	if t.config == nil {
 		return false
@@ -151,12 +163,11 @@ func (t *dummyMaster) testablyFail(method string, args ...string) bool {
 			return false
 		}
 	}
-	if !probablyFail(t.config["faillevel"]) {
+	if !probablyFail(t.framework.(*framework).random(), t.config["faillevel"]) {
 		return false
 	}
 	t.logger.Printf("master task %d testably fail, method: %s\n", t.taskID, method)
 	t.framework.(*framework).stop()
-	t.NodeProducer <- true
 	return true
 }
 
@@ -167,12 +178,11 @@ type dummySlave struct {
 	framework     meritop.Framework
 	epoch, taskID uint64
 	logger        *log.Logger
-	NodeProducer  chan bool
 	config        map[string]string
 
 	param, gradient *dummyData
 	fromChildren    map[uint64]*dummyData
-	gradientReady   *countDownLatch
+	gradientReady   *countDownLatch[int]
 }
 
 // This is useful to bring the task up to speed from scratch or if it recovers.
@@ -202,7 +212,11 @@ func (t *dummySlave) SetEpoch(ctx meritop.Context, epoch uint64) {
 	t.logger.Printf("slave SetEpoch, task: %d, epoch: %d\n", t.taskID, epoch)
 	t.param = &dummyData{}
 	t.gradient = &dummyData{}
-	t.gradientReady = newCountDownLatch(1)
+	if t.gradientReady == nil {
+		t.gradientReady = newCountDownLatch(1)
+	} else {
+		t.gradientReady.Reset(1)
+	}
 
 	t.epoch = epoch
 	// Make sure we have a clean slate.
@@ -211,19 +225,11 @@ func (t *dummySlave) SetEpoch(ctx meritop.Context, epoch uint64) {
 
 // These are payload rpc for application purpose.
 func (t *dummySlave) ServeAsParent(fromID uint64, req string) []byte {
-	b, err := json.Marshal(t.param)
-	if err != nil {
-		t.logger.Fatalf("Slave can't encode parameter: %v, error: %v\n", t.param, err)
-	}
-	return b
+	return MustMarshal(t.framework.GetSerializer(), t.param)
 }
 
 func (t *dummySlave) ServeAsChild(fromID uint64, req string) []byte {
-	b, err := json.Marshal(t.gradient)
-	if err != nil {
-		t.logger.Fatalf("Slave can't encode gradient: %v, error: %v\n", t.gradient, err)
-	}
-	return b
+	return MustMarshal(t.framework.GetSerializer(), t.gradient)
 }
 
 func (t *dummySlave) ParentDataReady(ctx meritop.Context, parentID uint64, req string, resp []byte) {
@@ -235,7 +241,7 @@ func (t *dummySlave) ParentDataReady(ctx meritop.Context, parentID uint64, req s
 		return
 	}
 	t.param = new(dummyData)
-	json.Unmarshal(resp, t.param)
+	t.framework.GetSerializer().Unmarshal(resp, t.param)
 	// We need to carry out local compuation.
 	t.gradient.Value = t.param.Value * int32(t.framework.GetTaskID())
 	t.gradientReady.CountDown()
@@ -254,7 +260,7 @@ func (t *dummySlave) ParentDataReady(ctx meritop.Context, parentID uint64, req s
 
 func (t *dummySlave) ChildDataReady(ctx meritop.Context, childID uint64, req string, resp []byte) {
 	d := new(dummyData)
-	json.Unmarshal(resp, d)
+	t.framework.GetSerializer().Unmarshal(resp, d)
 	if _, ok := t.fromChildren[childID]; ok {
 		return
 	}
@@ -271,8 +277,8 @@ func (t *dummySlave) ChildDataReady(ctx meritop.Context, childID uint64, req str
 		// simultaneously request both data. We need to wait until gradient data is there.
 		t.gradientReady.Await()
 		// In real ML, we add the gradient first.
-		for _, g := range t.fromChildren {
-			t.gradient.Value += g.Value
+		for id, g := range t.fromChildren {
+			t.gradient.Value += int32(ctx.GetChildWeight(id) * float64(g.Value))
 		}
 
 		// If this failure happens, a new node will redo computing again.
@@ -295,6 +301,15 @@ func (t *dummySlave) ChildDataReady(ctx meritop.Context, childID uint64, req str
 	}
 }
 
+func (t *dummySlave) Checkpoint(epoch uint64) ([]byte, error) {
+	return t.framework.GetSerializer().Marshal(t.param)
+}
+
+func (t *dummySlave) Restore(epoch uint64, data []byte) error {
+	t.param = new(dummyData)
+	return t.framework.GetSerializer().Unmarshal(data, t.param)
+}
+
 func (t *dummySlave) testablyFail(method string, args ...string) bool {
 	if t.config == nil {
 		return false
@@ -302,21 +317,20 @@ func (t *dummySlave) testablyFail(method string, args ...string) bool {
 	if t.config[method] != "fail" {
 		return false
 	}
-	if !probablyFail(t.config["faillevel"]) {
+	if !probablyFail(t.framework.(*framework).random(), t.config["faillevel"]) {
 		return false
 	}
 	t.logger.Printf("slave task %d testably fail, method: %s\n", t.taskID, method)
 	t.framework.(*framework).stop()
-	t.NodeProducer <- true
 	return true
 }
 
-func probablyFail(levelStr string) bool {
+func probablyFail(r *rand.Rand, levelStr string) bool {
 	level, err := strconv.Atoi(levelStr)
 	if err != nil {
 		return false
 	}
-	if level < rand.Intn(100)+1 {
+	if level < r.Intn(100)+1 {
 		return false
 	}
 	return true
@@ -327,9 +341,11 @@ type SimpleTaskBuilder struct {
 	GDataChan          chan int32
 	FinishChan         chan struct{}
 	NumberOfIterations uint64
-	NodeProducer       chan bool
 	MasterConfig       map[string]string
 	SlaveConfig        map[string]string
+	// the topology the tasks run in, which tells the number of tasks if it's
+	// a SizedTopology.
+	Topology meritop.Topology
 }
 
 // This method is called once by framework implementation to get the
@@ -340,39 +356,100 @@ func (tc SimpleTaskBuilder) GetTask(taskID uint64) meritop.Task {
 		return &dummyMaster{
 			dataChan:           tc.GDataChan,
 			finishChan:         tc.FinishChan,
-			NodeProducer:       tc.NodeProducer,
 			config:             tc.MasterConfig,
 			numberOfIterations: tc.NumberOfIterations,
 		}
 	}
 	return &dummySlave{
-		NodeProducer: tc.NodeProducer,
-		config:       tc.SlaveConfig,
+		config: tc.SlaveConfig,
 	}
 }
 
+// The config keys understood by dummy tasks by their lowercased name.
+var dummyConfigKeys = map[string]string{
+	"setepoch":        "SetEpoch",
+	"parentdataready": "ParentDataReady",
+	"childdataready":  "ChildDataReady",
+	"failepoch":       "failepoch",
+	"faillevel":       "faillevel",
+	"writefile":       "writefile",
+}
+
+// SetConfig adds config to both master and slave config, overriding what
+// was there.
+func (tc *SimpleTaskBuilder) SetConfig(config map[string]string) (unknown []string) {
+	for k, v := range config {
+		key, ok := dummyConfigKeys[k]
+		if !ok {
+			unknown = append(unknown, k)
+			continue
+		}
+		if tc.MasterConfig == nil {
+			tc.MasterConfig = make(map[string]string)
+		}
+		if tc.SlaveConfig == nil {
+			tc.SlaveConfig = make(map[string]string)
+		}
+		tc.MasterConfig[key] = v
+		tc.SlaveConfig[key] = v
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// The master runs epochs 0 to NumberOfIterations.
+func (tc SimpleTaskBuilder) NumberOfEpochs() uint64 { return tc.NumberOfIterations + 1 }
+
+// GetTaskCount returns the number of tasks of the topology, or 0 if it's
+// unknown.
+func (tc SimpleTaskBuilder) GetTaskCount() uint64 {
+	if t, ok := tc.Topology.(meritop.SizedTopology); ok {
+		return t.GetTaskCount()
+	}
+	return 0
+}
+
+// Validate checks the configuration GetTask relies on.
+func (tc SimpleTaskBuilder) Validate() error {
+	switch {
+	case tc.NumberOfIterations == 0:
+		return fmt.Errorf("SimpleTaskBuilder: NumberOfIterations must be positive")
+	case tc.GDataChan == nil:
+		return fmt.Errorf("SimpleTaskBuilder: GDataChan is nil")
+	case tc.FinishChan == nil:
+		return fmt.Errorf("SimpleTaskBuilder: FinishChan is nil")
+	}
+	return nil
+}
+
 // I am writing this count down latch because sync.WaitGroup doesn't support
-// decrementing counter when it's 0.
-type countDownLatch struct {
+// decrementing counter when it's 0. The counter can be of any integer type,
+// e.g. int8 for a few sub-tasks or int64 for a very large tree.
+type countDownLatch[T integer] struct {
 	sync.Mutex
 	cond    *sync.Cond
-	counter int
+	counter T
+}
+
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
 }
 
-func newCountDownLatch(count int) *countDownLatch {
-	c := new(countDownLatch)
+func newCountDownLatch[T integer](count T) *countDownLatch[T] {
+	c := new(countDownLatch[T])
 	c.cond = sync.NewCond(c)
 	c.counter = count
 	return c
 }
 
-func (c *countDownLatch) Count() int {
+func (c *countDownLatch[T]) Count() T {
 	c.Lock()
 	defer c.Unlock()
 	return c.counter
 }
 
-func (c *countDownLatch) CountDown() {
+func (c *countDownLatch[T]) CountDown() {
 	c.Lock()
 	defer c.Unlock()
 	if c.counter == 0 {
@@ -384,7 +461,20 @@ func (c *countDownLatch) CountDown() {
 	}
 }
 
-func (c *countDownLatch) Await() {
+// Reset makes the latch count down from n again, so that it can be reused
+// across epochs instead of allocating a new one. Anyone still waiting on the
+// previous count is released.
+func (c *countDownLatch[T]) Reset(n T) {
+	c.Lock()
+	defer c.Unlock()
+	if c.cond == nil {
+		c.cond = sync.NewCond(c)
+	}
+	c.cond.Broadcast()
+	c.counter = n
+}
+
+func (c *countDownLatch[T]) Await() {
 	c.Lock()
 	defer c.Unlock()
 	if c.counter == 0 {
@@ -0,0 +1,52 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+)
+
+type JSONSerializer struct{}
+
+func (JSONSerializer) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONSerializer) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// GobSerializer encodes every value as a gob stream of its own, so values
+// can be decoded independently of each other.
+type GobSerializer struct{}
+
+func (GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// MustMarshal is like s.Marshal but panics on error. It is meant for places
+// that can't return an error, e.g. Task.ServeAsParent.
+func MustMarshal(s meritop.Serializer, v interface{}) []byte {
+	b, err := s.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("can't marshal %v: %v", v, err))
+	}
+	return b
+}
+
+func (f *framework) SetCustomSerializer(s meritop.Serializer) { f.serializer = s }
+
+// GetSerializer returns JSONSerializer unless a custom one is set.
+func (f *framework) GetSerializer() meritop.Serializer {
+	if f.serializer == nil {
+		return JSONSerializer{}
+	}
+	return f.serializer
+}
@@ -0,0 +1,81 @@
+package framework
+
+import (
+	gocontext "context"
+	"sync"
+	"time"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+)
+
+// sharedMemoryStore is the SharedMemoryStore of the process.
+type sharedMemoryStore struct {
+	m sync.Map
+}
+
+var sharedMemory = &sharedMemoryStore{}
+
+func (s *sharedMemoryStore) Put(key string, data []byte) { s.m.Store(key, data) }
+
+func (s *sharedMemoryStore) Get(key string) ([]byte, bool) {
+	v, ok := s.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.([]byte), true
+}
+
+func (f *framework) GetSharedMemoryStore() meritop.SharedMemoryStore { return sharedMemory }
+
+// localTasks maps the task keys of the tasks running in this process to
+// their frameworks.
+var localTasks sync.Map
+
+// localPeer returns the framework of the given task if it runs in this
+// process and requests to it may skip the network, or nil.
+func (f *framework) localPeer(taskID uint64) *framework {
+	if !f.sharedMemory {
+		return nil
+	}
+	v, ok := localTasks.Load(f.taskKey(taskID))
+	if !ok {
+		return nil
+	}
+	return v.(*framework)
+}
+
+// sendLocalRequest asks the framework of a task in this process for data
+// directly, as its data request handler would. Like a request over HTTP, it
+// fails once the RPC timeout passed.
+func (f *framework) sendLocalRequest(peer *framework, dr *dataRequest) {
+	f.traceBuf.record(meritop.TraceRequestIssued, dr.taskID, dr.epoch, dr.req, nil)
+	start := time.Now()
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), f.rpcTimeoutOrDefault())
+	defer cancel()
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		d, err := peer.GetTaskData(f.taskID, dr.epoch, dr.req)
+		done <- result{d, err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = ctx.Err()
+	}
+	if res.err != nil {
+		f.requestFailed(dr, rpcError(ctx, dr.taskID, start, res.err))
+		return
+	}
+	f.responseReceived(dr, &frameworkhttp.DataResponse{
+		TaskID: dr.taskID,
+		Epoch:  dr.epoch,
+		Req:    dr.req,
+		Data:   res.data,
+	}, time.Since(start))
+}
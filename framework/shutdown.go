@@ -0,0 +1,48 @@
+package framework
+
+import (
+	gocontext "context"
+	"strconv"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// notifyShutdown tells the shutdown callback, if any, why the task stops.
+// Only the first cause is told.
+func (f *framework) notifyShutdown(cause meritop.ShutdownCause) {
+	if f.onShutdown == nil {
+		return
+	}
+	f.shutdownOnce.Do(func() { f.onShutdown(cause) })
+}
+
+// shutdownCause tells why run returned: the job was shut down with the cause
+// kept as its status, the context is done, or the task stopped on its own.
+func (f *framework) shutdownCause() meritop.ShutdownCause {
+	switch {
+	case f.epoch == exitEpoch:
+		value, err := f.backend.Get(gocontext.TODO(), etcdutil.JobStatusPath(f.jobPath()))
+		if err != nil {
+			f.log.Printf("task %d can't get job status: %v", f.taskID, err)
+			return meritop.EtcdFailure
+		}
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			f.log.Printf("task %d can't get job status: %v", f.taskID, err)
+			return meritop.EtcdFailure
+		}
+		return meritop.ShutdownCause(status)
+	case f.ctx != nil && f.ctx.Err() != nil:
+		return meritop.ExternalSignal
+	default:
+		return meritop.TaskFailure
+	}
+}
+
+// etcdFailed is log.Fatalf for etcd failures the task can't go on after,
+// telling the shutdown callback first.
+func (f *framework) etcdFailed(format string, v ...interface{}) {
+	f.notifyShutdown(meritop.EtcdFailure)
+	f.log.Fatalf(format, v...)
+}
@@ -0,0 +1,185 @@
+package framework
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/topology"
+)
+
+// A snapshot starts with snapshotMagic and the format version, followed by,
+// all integers big endian:
+//
+//	epoch uint64
+//	topology length uint32, topology as in topology.MarshalJSON
+//	number of checkpoints uint32, then for each:
+//	  task ID uint64, epoch uint64, data length uint32, data
+const (
+	snapshotMagic   = "MTSN"
+	snapshotVersion = uint32(1)
+)
+
+type taskSnapshot struct {
+	taskID, epoch uint64
+	data          []byte
+}
+
+// ExportCheckpoint writes the last checkpoints of all tasks in the
+// checkpoint store as of the current epoch. The snapshot is at the earliest
+// epoch of those, and has the last checkpoint of every task at or before it.
+func (f *framework) ExportCheckpoint(w io.Writer) error {
+	if f.checkpointStore == nil {
+		return fmt.Errorf("no checkpoint store to export from")
+	}
+	cur := f.loadTopology()
+	t, ok := cur.(meritop.SizedTopology)
+	if !ok {
+		return fmt.Errorf("topology %T doesn't tell the number of tasks", cur)
+	}
+	topo, err := topology.MarshalJSON(cur)
+	if err != nil {
+		return err
+	}
+	tasks := make([]taskSnapshot, 0)
+	current := f.loadEpoch()
+	epoch := current
+	for id := uint64(0); id < t.GetTaskCount(); id++ {
+		e, data, err := f.checkpointStore.Load(id, current)
+		if err == meritop.ErrNoCheckpoint {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if e < epoch {
+			epoch = e
+		}
+		tasks = append(tasks, taskSnapshot{taskID: id, epoch: e, data: data})
+	}
+	if len(tasks) == 0 {
+		return meritop.ErrNoCheckpoint
+	}
+	// Tasks ahead of the snapshot resume from an earlier checkpoint, if any.
+	kept := tasks[:0]
+	for _, ts := range tasks {
+		if ts.epoch != epoch {
+			ts.epoch, ts.data, err = f.checkpointStore.Load(ts.taskID, epoch)
+			if err == meritop.ErrNoCheckpoint {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		kept = append(kept, ts)
+	}
+	tasks = kept
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	binary.Write(bw, binary.BigEndian, snapshotVersion)
+	binary.Write(bw, binary.BigEndian, epoch)
+	binary.Write(bw, binary.BigEndian, uint32(len(topo)))
+	bw.Write(topo)
+	binary.Write(bw, binary.BigEndian, uint32(len(tasks)))
+	for _, ts := range tasks {
+		binary.Write(bw, binary.BigEndian, ts.taskID)
+		binary.Write(bw, binary.BigEndian, ts.epoch)
+		binary.Write(bw, binary.BigEndian, uint32(len(ts.data)))
+		bw.Write(ts.data)
+	}
+	return bw.Flush()
+}
+
+// ImportCheckpoint saves the checkpoints of the snapshot to the checkpoint
+// store and resumes the job at its epoch, like ReplayFromCheckpoint. If the
+// topology set already has a different number of tasks, the snapshot's is
+// resized to it, and checkpoints of tasks beyond are dropped.
+func (f *framework) ImportCheckpoint(r io.Reader) error {
+	if f.checkpointStore == nil {
+		return fmt.Errorf("no checkpoint store to import to")
+	}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return err
+	}
+	if string(magic) != snapshotMagic {
+		return fmt.Errorf("not a checkpoint snapshot")
+	}
+	var version uint32
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return err
+	}
+	if version != snapshotVersion {
+		return fmt.Errorf("unsupported checkpoint snapshot version %d", version)
+	}
+	var epoch uint64
+	if err := binary.Read(br, binary.BigEndian, &epoch); err != nil {
+		return err
+	}
+	topoJSON, err := readSnapshotBytes(br)
+	if err != nil {
+		return err
+	}
+	topo, err := topology.ParseJSON(topoJSON)
+	if err != nil {
+		return err
+	}
+	numTasks := uint64(0)
+	if t, ok := topo.(meritop.SizedTopology); ok {
+		numTasks = t.GetTaskCount()
+	}
+	if t, ok := f.loadTopology().(meritop.SizedTopology); ok && t.GetTaskCount() != numTasks {
+		numTasks = t.GetTaskCount()
+		topo.SetNumberOfTasks(numTasks)
+	}
+
+	var count uint32
+	if err := binary.Read(br, binary.BigEndian, &count); err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		var ts taskSnapshot
+		if err := binary.Read(br, binary.BigEndian, &ts.taskID); err != nil {
+			return err
+		}
+		if err := binary.Read(br, binary.BigEndian, &ts.epoch); err != nil {
+			return err
+		}
+		if ts.data, err = readSnapshotBytes(br); err != nil {
+			return err
+		}
+		if ts.taskID >= numTasks {
+			continue
+		}
+		if err := f.checkpointStore.Save(ts.taskID, ts.epoch, ts.data); err != nil {
+			return err
+		}
+	}
+	f.storeTopology(topo)
+	f.replayEpoch = epoch
+	f.replaySnapshot = true
+	return nil
+}
+
+// readSnapshotBytes reads a length-prefixed byte slice of a snapshot. The
+// length isn't trusted: no more is allocated than actually read.
+func readSnapshotBytes(r io.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,143 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+// httpSupervisorClient reports status as JSON to the supervisor at
+// {addr}/status, which answers with a JSON list of commands.
+type httpSupervisorClient struct {
+	addr   string
+	client *http.Client
+}
+
+// NewHTTPSupervisorClient returns a supervisor client that talks HTTP to the
+// supervisor at the given address, e.g. "http://10.0.0.1:8080".
+func NewHTTPSupervisorClient(addr string) (meritop.SupervisorClient, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("supervisor address %q is not an http(s) URL", addr)
+	}
+	return &httpSupervisorClient{
+		addr:   addr,
+		client: &http.Client{Timeout: defaultHeartbeatInterval},
+	}, nil
+}
+
+func (c *httpSupervisorClient) ReportStatus(status meritop.SupervisorStatus) ([]meritop.SupervisorCommand, error) {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Post(c.addr+"/status", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("supervisor responded %s: %s", resp.Status, msg)
+	}
+	var cmds []meritop.SupervisorCommand
+	if err := json.NewDecoder(resp.Body).Decode(&cmds); err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+func (f *framework) ConnectSupervisor(addr string) error {
+	c, err := NewHTTPSupervisorClient(addr)
+	if err != nil {
+		return err
+	}
+	f.supervisor = c
+	return nil
+}
+
+func (f *framework) SetSupervisorClient(c meritop.SupervisorClient) { f.supervisor = c }
+
+// reportToSupervisor reports the status of the task to the supervisor once
+// per heartbeat interval and passes its commands to the event loop until stop.
+func (f *framework) reportToSupervisor(stop chan struct{}) {
+	for {
+		select {
+		case <-time.After(f.heartbeatInterval()):
+		case <-stop:
+			return
+		}
+		statuses, err := f.taskStatuses()
+		if err != nil {
+			f.log.Printf("task %d failed to list heartbeats: %v", f.taskID, err)
+		}
+		cmds, err := f.supervisor.ReportStatus(meritop.SupervisorStatus{
+			JobName:     f.name,
+			TaskID:      f.taskID,
+			Epoch:       f.loadEpoch(),
+			ActiveTasks: len(statuses),
+			ErrorCount:  atomic.LoadUint64(&f.errorCount),
+		})
+		if err != nil {
+			f.log.Printf("task %d failed to report to supervisor: %v", f.taskID, err)
+			continue
+		}
+		for _, cmd := range cmds {
+			select {
+			case f.supervisorCmdChan <- cmd:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// handleSupervisorCommand carries out a command other than pause and resume,
+// which the event loop takes care of. It must be called from the event loop.
+func (f *framework) handleSupervisorCommand(cmd meritop.SupervisorCommand) {
+	f.log.Printf("task %d got supervisor command %s", f.taskID, cmd.Type)
+	switch cmd.Type {
+	case meritop.CommandCheckpoint:
+		if err := f.saveCheckpoint(NewDirCheckpointStore(cmd.Dir), f.epoch); err != nil {
+			f.log.Printf("task %d failed to checkpoint: %v", f.taskID, err)
+			atomic.AddUint64(&f.errorCount, 1)
+		}
+		return
+	}
+	if h, ok := f.task.(meritop.SupervisorCommandHandler); ok {
+		go h.HandleSupervisorCommand(f.createContext(), cmd)
+		return
+	}
+	f.log.Printf("task %d ignored supervisor command %s", f.taskID, cmd.Type)
+}
+
+// waitResume blocks the event loop while the job is paused. It returns false
+// if the node should stop instead.
+func (f *framework) waitResume(ctxDone <-chan struct{}) bool {
+	f.log.Printf("task %d paused", f.taskID)
+	for {
+		select {
+		case <-ctxDone:
+			return false
+		case cmd := <-f.supervisorCmdChan:
+			switch cmd.Type {
+			case meritop.CommandResume:
+				f.log.Printf("task %d resumed", f.taskID)
+				return true
+			case meritop.CommandPause:
+			default:
+				f.handleSupervisorCommand(cmd)
+			}
+		}
+	}
+}
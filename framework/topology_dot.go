@@ -0,0 +1,57 @@
+package framework
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+func (f *framework) DumpTopologyDOT() (string, error) {
+	health, err := f.GetTopologyHealth()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "digraph %q {\n", f.name)
+	for _, h := range health {
+		color := "black"
+		if h.Status == meritop.NodeDead {
+			color = "red"
+		}
+		fmt.Fprintf(&buf, "\t%d [label=\"task %d\\n%s\\nepoch %d\", color=%s];\n",
+			h.TaskID, h.TaskID, h.Status, h.CurrentEpoch, color)
+	}
+	adj := f.loadTopology().GetAdjacencyList(f.loadEpoch())
+	parents := make([]uint64, 0, len(adj))
+	for p := range adj {
+		parents = append(parents, p)
+	}
+	sort.Sort(uint64Slice(parents))
+	for _, p := range parents {
+		for _, c := range adj[p] {
+			fmt.Fprintf(&buf, "\t%d -> %d", p, c)
+			// Only the latencies of requests sent by this task are known.
+			if lat, ok := f.edgeLatency(p, c); ok {
+				fmt.Fprintf(&buf, " [label=%q]", lat.String())
+			}
+			buf.WriteString(";\n")
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String(), nil
+}
+
+// edgeLatency returns the latency of the last data request over the edge
+// between parent and child, if this task is one of them.
+func (f *framework) edgeLatency(parent, child uint64) (time.Duration, bool) {
+	switch f.taskID {
+	case parent:
+		return f.latencies.last(child)
+	case child:
+		return f.latencies.last(parent)
+	}
+	return 0, false
+}
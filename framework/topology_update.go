@@ -0,0 +1,32 @@
+package framework
+
+import "github.com/go-distributed/meritop"
+
+func (f *framework) SetTopologyUpdateCallback(fn func(old, new meritop.Topology)) {
+	f.topologyUpdateCallback = fn
+}
+
+func (f *framework) UpdateTopology(topology meritop.Topology) {
+	f.nextTopologyMu.Lock()
+	defer f.nextTopologyMu.Unlock()
+	f.nextTopology = topology
+}
+
+// applyTopologyUpdate switches to the topology given last to UpdateTopology,
+// if any, calling the topology update callback first. It must be called from
+// the event loop between epochs.
+func (f *framework) applyTopologyUpdate() {
+	f.nextTopologyMu.Lock()
+	next := f.nextTopology
+	f.nextTopology = nil
+	f.nextTopologyMu.Unlock()
+	if next == nil {
+		return
+	}
+	next.SetTaskID(f.taskID)
+	if f.topologyUpdateCallback != nil {
+		f.topologyUpdateCallback(f.loadTopology(), next)
+	}
+	f.storeTopology(next)
+	f.log.Printf("task %d switched topology at epoch %d", f.taskID, f.epoch)
+}
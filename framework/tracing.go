@@ -0,0 +1,65 @@
+package framework
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+// traceBuffer is a fixed size ring of trace events, guarded by mu so that a
+// dump has the last events in the order they were recorded.
+type traceBuffer struct {
+	mu     sync.Mutex
+	next   uint64
+	events []meritop.TraceEvent
+}
+
+func newTraceBuffer(size int) *traceBuffer {
+	return &traceBuffer{events: make([]meritop.TraceEvent, size)}
+}
+
+// record is a no-op on a nil buffer, i.e. when tracing is disabled.
+func (b *traceBuffer) record(typ string, taskID, epoch uint64, req string, err error) {
+	if b == nil || len(b.events) == 0 {
+		return
+	}
+	ev := meritop.TraceEvent{
+		Type:   typ,
+		TaskID: taskID,
+		Epoch:  epoch,
+		Req:    req,
+	}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ev.Time = time.Now().UnixNano()
+	b.events[b.next%uint64(len(b.events))] = ev
+	b.next++
+}
+
+func (b *traceBuffer) dump() []meritop.TraceEvent {
+	if b == nil || len(b.events) == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	size := uint64(len(b.events))
+	start := uint64(0)
+	if b.next > size {
+		start = b.next - size
+	}
+	events := make([]meritop.TraceEvent, 0, b.next-start)
+	for i := start; i < b.next; i++ {
+		events = append(events, b.events[i%size])
+	}
+	return events
+}
+
+func (f *framework) EnableRequestTracing(bufferSize int) {
+	f.traceBuf = newTraceBuffer(bufferSize)
+}
+
+func (f *framework) GetTraceBuffer() []meritop.TraceEvent { return f.traceBuf.dump() }
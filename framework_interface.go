@@ -1,15 +1,377 @@
 package meritop
 
-import "log"
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"time"
+)
+
+var ErrInsufficientData = errors.New("not enough samples")
+
+// ErrAuthenticationFailed is reported when etcd rejects the credentials.
+var ErrAuthenticationFailed = errors.New("etcd authentication failed")
+
+// ErrEpochInProgress is returned by Framework.SetTopology during an epoch.
+var ErrEpochInProgress = errors.New("epoch in progress")
+
+// ErrTimeout is returned by Framework.WaitForEpoch if the epoch isn't reached
+// in time.
+var ErrTimeout = errors.New("timeout")
+
+// ErrJobShutDown is returned by Framework.WaitForEpoch if the job is shut
+// down instead of reaching the epoch.
+var ErrJobShutDown = errors.New("job shut down")
 
 // This interface is used by application during taskgraph configuration phase.
 type Bootstrap interface {
+	// Change the job name given at construction. It panics after Start.
+	SetJobName(name string)
+
+	// Replace the built-in etcd key the job epoch is kept in, e.g. to fit
+	// etcd ACL policies. The epoch lives in a single key the framework
+	// watches, so fn is called with epoch 0 and must return the key the
+	// controller created, see Controller.SetCustomEpochKey.
+	// It panics after Start.
+	SetCustomEpochKey(fn func(jobName string, epoch uint64) string)
+	// Replace the built-in etcd key a task registers its address in. It
+	// panics after Start.
+	SetCustomTaskKey(fn func(jobName string, taskID uint64) string)
+
 	// These allow application developer to set the task configuration so framework
 	// implementation knows which task to invoke at each node.
 	SetTaskBuilder(taskBuilder TaskBuilder)
 
-	// This allow the application to specify how tasks are connection at each epoch
-	SetTopology(topology Topology)
+	// This allow the application to specify how tasks are connection at each epoch.
+	// Before Start it never fails, see Framework.SetTopology for later on.
+	SetTopology(topology Topology) error
+
+	// Same as SetTopology, but with the topology described in JSON, e.g.
+	// {"type": "tree", "fanout": 2, "numTasks": 7}. The type must be
+	// registered, see package topology.
+	SetTopologyJSON(config string) error
+
+	// All etcd keys of the job are scoped under <prefix>/<jobName>, so that
+	// jobs sharing an etcd cluster don't collide. Defaults to "/meritop".
+	// The controller of the job must use the same prefix.
+	SetKeyPrefix(prefix string)
+
+	// Put the key prefix under namespace ns, so that teams sharing an etcd
+	// cluster are isolated from each other. ns may only contain letters,
+	// digits and hyphens. The controller of the job must use the same one.
+	SetEtcdNamespace(ns string) error
+
+	// Timeouts used by the etcd client. The dial timeout bounds connection
+	// setup; the request timeout bounds each request except watches.
+	SetEtcdDialTimeout(d time.Duration)
+	SetEtcdRequestTimeout(d time.Duration)
+
+	// The keys a task keeps alive in etcd, e.g. the one telling it is
+	// healthy, expire d after its last heartbeat, and heartbeats are sent
+	// every d/3. A shorter d detects failed tasks sooner, a longer one
+	// makes less etcd traffic. Defaults to 3s; less than 1s counts as 1s,
+	// and etcd rounds it up to whole seconds.
+	SetEtcdLeaseTTL(d time.Duration)
+
+	// Authenticate to etcd with the given user. The framework refuses to
+	// start if etcd rejects them. The password is never logged.
+	SetEtcdCredentials(username, password string)
+
+	// Share connections to etcd with other frameworks in the same process
+	// instead of opening new ones, see etcdutil.Pool. The etcd timeouts and
+	// credentials above still apply.
+	UseSharedEtcdPool(pool EtcdPool)
+
+	// Keep task metadata, e.g. meta flags, in the given store instead of etcd.
+	SetMetadataStore(store MetadataStore)
+
+	// Coordinate with the other tasks through backend instead of etcd: the
+	// epoch, the job status and task addresses, and metadata too unless a
+	// metadata store is set. Tasks still take over tasks and send
+	// heartbeats through etcd.
+	SetCoordinationBackend(backend CoordinationBackend)
+
+	// At most n Task.ServeAsParent/ServeAsChild calls run at the same time;
+	// other data requests wait for their turn. Defaults to the number of CPUs.
+	SetWorkerPoolSize(n int)
+
+	// Keep the last bufferSize data request trace events in memory. They can
+	// be dumped with Framework.GetTraceBuffer. Tracing is off by default.
+	EnableRequestTracing(bufferSize int)
+
+	// fn is called once per heartbeat interval with the latest status of every
+	// live task of the job, e.g. to spot tasks about to fail before they do.
+	SetHeartbeatHandler(fn func(taskID uint64, status HeartbeatStatus))
+
+	// Responses to data requests and pushed data larger than this are
+	// rejected instead of being read into memory. Defaults to 100 MB.
+	SetMaxMessageSize(bytes int64)
+
+	// At most n meta flags, 100 by default, are written to etcd at a time
+	// so that flagging from many goroutines doesn't overwhelm etcd.
+	// FlagMetaToParent and FlagMetaToChild block for a free slot. A flag
+	// holds its slot until etcd acknowledged it; every child watches the
+	// same key, so a flag to any number of children is a single write.
+	SetMaxPendingMetaFlags(n int)
+
+	// When an epoch starts, the meta flags of up to n parents and children,
+	// 1 by default, are set up to be watched at once. Each takes round trips
+	// to etcd, so with many children a larger n starts epochs faster.
+	SetMetaFlagConcurrency(n int)
+
+	// Send up to n data requests to the same task at the same epoch in one
+	// round trip, e.g. those issued one after another in a callback. The
+	// task serves each of them as usual. Defaults to 1, i.e. no batching.
+	SetDataRequestBatchSize(n int)
+
+	// Data requests not answered within d, 30s by default, are given up.
+	// The task learns about it as DataRequestFailed with an
+	// *RPCTimeoutError, if it is a DataRequestFailureHandler.
+	SetRPCTimeout(d time.Duration)
+
+	// RPCs between tasks go over TLS with cfg, both when serving and when
+	// sending them. Once it's called the framework refuses to start without
+	// TLS, i.e. if cfg is nil. See framework.NewSelfSignedTLSConfig for
+	// testing.
+	SetTLSConfig(cfg *tls.Config)
+
+	// Limit the bytes per second of data this task sends to, or receives
+	// from, other tasks with a token bucket. etcd traffic isn't limited.
+	// Zero, the default, means no limit.
+	SetBandwidthLimit(bytesPerSec int64)
+	SetReceiveBandwidthLimit(bytesPerSec int64)
+
+	// Log epochs starting, completing and being aborted, and the data
+	// exchanged with other tasks, to store. Data is logged by its SHA-256.
+	// See framework.NewFileAuditStore and framework.NewEtcdAuditStore.
+	EnableEpochAuditLog(store AuditStore)
+
+	// fn is called before a topology given to Framework.UpdateTopology
+	// takes effect, with both the old and new topology, so that the task
+	// can prepare for the new connectivity. It is called synchronously
+	// between epochs, i.e. before SetEpoch of the first epoch with the new
+	// topology.
+	SetTopologyUpdateCallback(fn func(old, new Topology))
+
+	// Tasks in the same group communicate a lot and had better run close
+	// to each other, e.g. on the same machine or rack. Every task records
+	// its group in etcd once it started, for schedulers to find with
+	// Framework.GetAffinityGroup. A task can be in one group at most.
+	SetTaskAffinityGroups(groups map[string][]uint64)
+
+	// Register a barrier for the tasks of a group, see Context.ReachBarrier.
+	// Once all of them reached it in an epoch, fn is called on each of them
+	// before they continue. Every task of the group must register the same.
+	SetTaskGroupBarrier(group string, taskIDs []uint64, fn func(ctx Context, epoch uint64))
+
+	// Compress data responses and pushes with gzip. Only those of at least
+	// the threshold, 4096 bytes by default, are compressed, as compressing
+	// small ones hardly pays off and might even make them larger.
+	SetCompression(enabled bool)
+	SetCompressionThreshold(bytes int)
+
+	// Send data requests to tasks running in the same process straight to
+	// them instead of over the network, e.g. when a test runs the whole job
+	// in one process. Off by default.
+	SetSharedMemoryOptimization(enabled bool)
+
+	// fn sees the data of every response to a data request and every push
+	// this task receives, from either parents or children, before the task
+	// does. The task gets what fn returns instead, e.g. corrupted gradients
+	// in tests, or nothing if it returns nil, as if the message was lost.
+	SetMessageInterceptor(fn func(fromTaskID, toTaskID, epoch uint64, payload []byte) []byte)
+
+	// Metrics emitted via Context.EmitMetric go to sink. They are dropped
+	// if no sink is set.
+	SetMetricsSink(sink MetricsSink)
+
+	// Load configuration from environment variables starting with prefix,
+	// e.g. "MERITOP_". The prefix is stripped and the rest of the name is
+	// lowercased to get the key. The configuration is handed to the task
+	// builder if it is a ConfigurableTaskBuilder.
+	LoadConfigFromEnv(prefix string)
+
+	// Warn about keys loaded from environment that the task builder
+	// doesn't know.
+	SetStrictEnvConfig(strict bool)
+
+	// Drop data requests to the same task with the same epoch and meta while
+	// one is in flight, and responses to them delivered again within the
+	// deduplication window (one minute by default).
+	SetDataRequestDeduplication(enabled bool)
+	SetDeduplicationWindow(d time.Duration)
+
+	// fn is called with every new epoch before Task.SetEpoch. If it returns
+	// true, the epoch is skipped: the framework moves on to the next one
+	// without calling SetEpoch. All tasks of the job must use the same policy.
+	SetEpochSkipPolicy(fn func(epoch uint64) bool)
+
+	// true is sent on ch whenever the node stops because its task failed, so
+	// that whoever manages nodes can start a new one to take over.
+	SetNodeProducer(ch chan<- bool)
+
+	// Quorum is the fraction of children that need to respond to data
+	// requests in an epoch before Context.IsQuorumMet says so, e.g. 0.8 to
+	// aggregate without the slowest fifth of children. Defaults to 1.
+	SetAggregationQuorum(quorum float64)
+
+	// Deliver data responses and pushes of the last w epochs, instead of only
+	// the current one, to allow for asynchronous (Hogwild!-style) training.
+	// Stale data is handed to the task with a context of the current epoch.
+	// Defaults to 0.
+	SetStalenessWindow(w uint64)
+
+	// Data of the regression tasks and checkpoints is encoded with s instead
+	// of JSON.
+	SetCustomSerializer(s Serializer)
+
+	// fn is called when a task increments the epoch, with the data each child
+	// responded with in that epoch, e.g. to catch NaN gradients. If it
+	// returns an error, the epoch is started over instead. After more than
+	// max epoch retries failures of the same epoch, the job is shut down.
+	// Epochs aborted by Context.AbortEpoch are always started over. Tasks
+	// learn of every start over via EpochRetryHandler.
+	SetEpochValidator(fn func(epoch uint64, gradients map[uint64][]byte) error)
+	SetMaxEpochRetries(n int)
+
+	// fn is called when a task, usually the master, increments the epoch,
+	// after the epoch validator and before any task sets the next epoch,
+	// e.g. to persist state or update a learning rate schedule. If it
+	// returns an error, it is retried up to max transition retries times,
+	// then the job is shut down.
+	SetCustomEpochTransition(fn func(ctx Context, fromEpoch, toEpoch uint64) error)
+	SetMaxTransitionRetries(n int)
+
+	// fn is called with every response of a child before the task gets it,
+	// e.g. to catch a NaN gradient of a single child. Unlike the epoch
+	// validator, it sees one child's data at a time. If it returns an
+	// error, the data request is sent again, up to max child response
+	// retries times. After that the request fails, see
+	// DataRequestFailureHandler, and the child's data is left out.
+	SetChildResponseValidator(fn func(childID, epoch uint64, data []byte) error)
+	SetMaxChildResponseRetries(n int)
+
+	// fn is called when a task, usually the master, increments the epoch,
+	// once the epoch validator accepted it, e.g. to tell dashboards or
+	// early-stopping controllers. gradientSum is what the task aggregated,
+	// if it's an Aggregator, or nil.
+	SetEpochCompletionCallback(fn func(epoch uint64, gradientSum []byte))
+
+	// fn computes the ID of the epoch after prevEpoch, instead of
+	// prevEpoch+1, e.g. prevEpoch+10 for striding or a timestamp. It must
+	// return IDs larger than prevEpoch and be the same on every task, since
+	// any task can increment the epoch. Windows counted in epochs, such as
+	// SetMaxParallelEpochs and SetStalenessWindow, count IDs then.
+	SetEpochIDGenerator(fn func(prevEpoch uint64) uint64)
+
+	// fn returns a permutation of task IDs in which tasks register in etcd.
+	// Registering for the task at position i is delayed by i*10ms, which
+	// avoids a write storm when a large job starts, see
+	// framework.SequentialInitOrder. Standby nodes taking over a failed task
+	// are delayed the same way.
+	SetWorkerInitOrder(fn func(numTasks uint64) []uint64)
+
+	// Report the job status to the external supervisor at addr over HTTP
+	// once per heartbeat interval and follow its commands. While paused, the
+	// task gets no events. Commands other than pause, resume and checkpoint
+	// are passed to the task if it's a SupervisorCommandHandler.
+	ConnectSupervisor(addr string) error
+	// Like ConnectSupervisor, but talking to the supervisor through c.
+	SetSupervisorClient(c SupervisorClient)
+
+	// Decide when data requests of the task are sent. The default is
+	// framework.FetchImmediately.
+	SetDataRequestPolicy(policy DataRequestPolicy)
+
+	// Decide when the job moves on to the next epoch. The default is
+	// framework.ManualCommit; with the others, the task must not call
+	// Context.IncEpoch itself.
+	SetEpochCommitStrategy(strategy CommitStrategy)
+
+	// fn applies a gradient to parameters, both serialized, and returns the
+	// updated parameters, e.g. a step of SGD or Adam. Tasks call it with
+	// Context.ApplyGradient, so that they can share the optimizer.
+	SetParameterUpdateFunction(fn func(param, gradient []byte) []byte)
+
+	// The first n epochs are warm-up epochs, see Context.IsWarmup. They are
+	// run like any other and count as iterations as well.
+	SetWarmupEpochs(n uint64)
+
+	// Keep the last n epochs open. Epochs still start one after another, and
+	// SetEpoch and meta flags are only for the current one, but data
+	// requests, responses and pushes of the open epochs are still served,
+	// e.g. so that gradients of the last mini-batch can arrive once the next
+	// one started. Unlike with SetStalenessWindow, such data is kept apart
+	// and callbacks get a context of the epoch it's for, so that the task
+	// can keep an accumulator per epoch. The default, 1, keeps only the
+	// current epoch open.
+	SetMaxParallelEpochs(n int)
+
+	// Identify the machine or container this node runs on, e.g. by container
+	// ID. It defaults to the hostname. It's reported in GetTopologyHealth, so
+	// that tasks running on the same machine can be told.
+	SetNodeID(nodeID string)
+
+	// Task.Init is given up after d, in which case the task is told to exit
+	// and Init is retried on a new task up to max task restarts times. After
+	// that the job is shut down. Zero d, the default, means no timeout.
+	SetInitTimeout(d time.Duration)
+	SetMaxTaskRestarts(n int)
+
+	// Once the job is shut down, the framework calls Task.Exit and waits up to
+	// d for it to return before stopping. Zero d, the default, means no limit.
+	SetShutdownTimeout(d time.Duration)
+
+	// Before epoch 0, Start waits up to d for every task of the job to have
+	// a heartbeat, see Framework.WaitForAllTasks, so that none misses it.
+	// Tasks still missing then are logged, and the epoch starts without
+	// them. Zero d, the default, means no waiting.
+	SetStartupWaitTimeout(d time.Duration)
+
+	// fn is told why the task stops, once the task exited but before the
+	// framework stops heartbeats and serving, e.g. to flush buffers or update
+	// external status stores. It's also called before the process exits on
+	// etcd failures.
+	SetOnShutdown(fn func(cause ShutdownCause))
+
+	// Seed the random number generator of the framework, which it uses for
+	// whatever it does at random, e.g. picking a free task to take over, and
+	// tasks get by Context.GetRand, so that runs can be reproduced. It's
+	// seeded with the current time by default.
+	SetGlobalSeed(seed int64)
+
+	// Don't start more than rate epochs per second, e.g. for online learning
+	// on data that arrives in real time. Once the epoch is incremented,
+	// SetEpoch for the next one waits until 1/rate seconds after SetEpoch for
+	// the last one. Zero, the default, means no pacing.
+	SetMaxEpochsPerSecond(rate float64)
+
+	// Resume the job from the checkpoints saved at given epoch instead of
+	// starting from scratch. Epochs before it are not run again. Checkpoints
+	// are read from dir, see framework.CheckpointPath for the file layout.
+	ReplayFromCheckpoint(dir string, epoch uint64) error
+
+	// Resume the job from a snapshot written by Framework.ExportCheckpoint,
+	// possibly of a job with a different number of tasks. The checkpoints
+	// are saved to the checkpoint store, which must be set first. The
+	// snapshot's topology is used, resized to the number of tasks of the
+	// topology set before, if any.
+	ImportCheckpoint(r io.Reader) error
+
+	// Tasks implementing Checkpointable are checkpointed to store once done
+	// with every epoch that is a multiple of n. A task taking over from a
+	// failed one is restored from its last checkpoint, if any, before it
+	// starts. Zero n, the default, means no checkpoints are saved.
+	SetCheckpointStore(store CheckpointStore)
+	SetCheckpointInterval(n uint64)
+
+	// Tie the node's lifetime to ctx: once ctx is done, the node stops
+	// running and Start returns. It returns the bootstrap for chaining.
+	WithContext(ctx context.Context) Bootstrap
 
 	// After all the configure is done, driver need to call start so that all
 	// nodes will get into the event loop to run the application.
@@ -29,15 +391,309 @@ type Framework interface {
 	// This allow the task implementation query its neighbors.
 	GetTopology() Topology
 
+	// UpdateTopology replaces the topology of this task from the next epoch
+	// on, e.g. after tasks were added or removed. Every task is expected to
+	// make the same update at the same epoch.
+	UpdateTopology(topology Topology)
+
+	// SetTopology is UpdateTopology for callers that must not race with an
+	// epoch, e.g. when tasks were added for elastic training. It returns
+	// ErrEpochInProgress from SetEpoch of an epoch until this task calls
+	// IncEpoch in it or the next epoch is reached. Otherwise the topology
+	// is used from the next epoch on.
+	SetTopology(topology Topology) error
+
+	// GetTopologyDepth returns the depth of the topology at the current
+	// epoch, see Topology.GetDepth. A reduction up a tree takes that many
+	// hops.
+	GetTopologyDepth() uint64
+
+	// The topology at the current epoch over all tasks of the job, as a
+	// matrix in which m[i][j] is true if task i is a parent of task j.
+	GetTopologyAsAdjacencyMatrix() ([][]bool, error)
+
+	// The topology at the current epoch over all tasks of the job, as a
+	// graph to run e.g. shortest path searches on.
+	GetTaskGraph() (*TaskGraph, error)
+
 	// Some task can inform all participating tasks to shutdown.
 	// If successful, all tasks will be gracefully shutdown.
-	// TODO: @param status
+	// The cause is NormalCompletion.
 	ShutdownJob()
 
+	// ShutdownJobWithCause is ShutdownJob telling every task why, e.g.
+	// EpochTimeout by a task that watches epoch deadlines.
+	ShutdownJobWithCause(cause ShutdownCause)
+
+	// SendCommandToTask hands cmd to the CommandHandler of the given task as
+	// soon as it gets to it, in whatever epoch. Commands go through their own
+	// etcd keys, apart from the epoch and meta of the job.
+	SendCommandToTask(taskID uint64, cmd Command) error
+
 	GetLogger() *log.Logger
 
 	// This is used to figure out taskid for current node
 	GetTaskID() uint64
+
+	// The machine or container this task runs on, which might run other
+	// tasks as well.
+	GetNodeID() string
+
+	// GetJobName returns the name of the job this task belongs to.
+	GetJobName() string
+
+	// GetEtcdNamespace returns the namespace set by Bootstrap.SetEtcdNamespace.
+	GetEtcdNamespace() string
+
+	// GetJobStartTime returns when Bootstrap.Start was called on this node,
+	// and GetJobElapsedTime how long ago that was.
+	GetJobStartTime() time.Time
+	GetJobElapsedTime() time.Duration
+
+	// GetPeerAddress returns the host:port the given task registered, so that
+	// tasks can talk to each other directly without the framework.
+	GetPeerAddress(taskID uint64) (string, error)
+
+	// GetLatencyPercentile returns the given percentile, in [0, 100], of the
+	// latencies of recent data requests this task sent to taskID. It returns
+	// ErrInsufficientData until there are 10 of them.
+	GetLatencyPercentile(taskID uint64, percentile float64) (time.Duration, error)
+
+	// GetBandwidthStats returns the rates of data this task sent to and
+	// received from every task it talked to, keyed by {this task, peer}.
+	// They are moving averages over about the last 10 seconds.
+	GetBandwidthStats() map[[2]uint64]BandwidthStats
+
+	// GetTopologyHealth reports how every task of the job is doing, based on
+	// heartbeats and failure reports in etcd.
+	GetTopologyHealth() ([]NodeHealth, error)
+
+	// GetEtcdHealth asks etcd about its members and leader, and how long it
+	// took to answer. Every task also answers health probes at
+	// frameworkhttp.HealthPrefix with 503 while etcd has no leader.
+	GetEtcdHealth() (EtcdHealth, error)
+
+	// GetSharedMemoryStore returns a store shared by every task running in
+	// this process, to hand each other data without copying it.
+	GetSharedMemoryStore() SharedMemoryStore
+
+	// DumpTopologyDOT renders the topology at the current epoch as a
+	// Graphviz digraph, e.g. for `dot -Tpng`. Nodes show the status and
+	// epoch of every task as in GetTopologyHealth. Edges to and from this
+	// task show the latency of the last data request sent over them.
+	DumpTopologyDOT() (string, error)
+
+	// GetMemoryUsage returns the bytes of heap and stack in use by every live
+	// task as of its last heartbeat. Go can't account memory per goroutine,
+	// so this is the usage of the process running the task.
+	GetMemoryUsage() (map[uint64]uint64, error)
+
+	// GetQueueDepths returns the operations every live task had pending as
+	// of its last heartbeat, e.g. to find what holds up a slow epoch.
+	GetQueueDepths() (map[uint64]QueueDepth, error)
+
+	// EnsureTopologyConnected pings every ancestor of this task and waits
+	// for the answers. Tasks not answering within timeout, e.g. because of
+	// networking misconfiguration, are listed in a *ConnectivityError.
+	// Called by every leaf before the first epoch, it checks the whole tree.
+	EnsureTopologyConnected(timeout time.Duration) error
+
+	// WaitForAllTasks waits until every task of the job has a heartbeat.
+	// Tasks still absent after timeout are listed in a *MissingTasksError.
+	// It must not be called from task callbacks, which would hold up the
+	// task's events; see Bootstrap.SetStartupWaitTimeout to keep epoch 0
+	// from starting without every task.
+	WaitForAllTasks(timeout time.Duration) error
+
+	// RotateEtcdCredentials switches the etcd clients of this task to new
+	// credentials, e.g. before the old ones expire, without interrupting
+	// the job. The old ones stay in use unless etcd accepts the new ones;
+	// ErrAuthenticationFailed is returned if it rejects them. With a
+	// shared etcd pool, this changes the credentials of all its users.
+	RotateEtcdCredentials(newCreds Credentials) error
+
+	// GetAffinityGroup returns the affinity group the given task recorded,
+	// see Bootstrap.SetTaskAffinityGroups. It is empty if the task is in
+	// no group or hasn't started yet.
+	GetAffinityGroup(taskID uint64) (string, error)
+
+	// GetIncompleteEpochTasks returns the children of this task that
+	// haven't responded to a data request at the given epoch yet, e.g. to
+	// find what holds up a stuck epoch. The epoch must be open, i.e. the
+	// current one or one kept open by SetMaxParallelEpochs.
+	GetIncompleteEpochTasks(epoch uint64) ([]uint64, error)
+
+	// GetCheckpointInterval returns the interval set by
+	// Bootstrap.SetCheckpointInterval.
+	GetCheckpointInterval() uint64
+
+	// ExportCheckpoint writes the last checkpoints of all tasks in the
+	// checkpoint store, the epoch and the topology as a self-contained,
+	// versioned snapshot, see Bootstrap.ImportCheckpoint.
+	ExportCheckpoint(w io.Writer) error
+
+	// GetEpochDurations returns how long each epoch this task finished took
+	// by epoch, from SetEpoch until the job moved on. Epochs run before the
+	// task took over are zero. With an epoch ID generator, they are in the
+	// order the epochs ran instead.
+	GetEpochDurations() []time.Duration
+
+	// WaitForEpoch blocks until the job reaches the given epoch or a later
+	// one. It returns an error wrapping ErrTimeout, with the epoch the job is
+	// at, if that doesn't happen within timeout, and ErrJobShutDown if the
+	// job is shut down before.
+	WaitForEpoch(epoch uint64, timeout time.Duration) error
+
+	// LogEpochBoundary annotates the run at the given epoch, e.g. "learning
+	// rate decayed". It's logged as a JSON object tagged with
+	// "event": "epoch_boundary", for log aggregation systems to filter.
+	LogEpochBoundary(epoch uint64, msg string)
+
+	// GetTraceBuffer returns the recorded trace events, oldest first. It is
+	// empty unless Bootstrap.EnableRequestTracing was called.
+	GetTraceBuffer() []TraceEvent
+
+	// GetSerializer returns the serializer set by Bootstrap.SetCustomSerializer,
+	// JSON by default.
+	GetSerializer() Serializer
+
+	// ExportMetrics writes the framework metrics of this task, e.g. the
+	// current epoch and error count, to w. format is one of "prometheus"
+	// (the Prometheus text exposition format), "json" (an object keyed by
+	// metric name) and "csv" (one "name,value" line per metric).
+	ExportMetrics(w io.Writer, format string) error
+}
+
+// Possible values of NodeHealth.Status.
+const (
+	NodeRunning    = "running"    // heartbeat alive and at the job's epoch
+	NodeLagging    = "lagging"    // heartbeat alive but behind the job's epoch
+	NodeRestarting = "restarting" // failure reported, waiting for a standby to take over
+	NodeDead       = "dead"       // no node is serving the task
+)
+
+// SharedMemoryStore keeps data in memory for tasks of the same process, see
+// Framework.GetSharedMemoryStore. Data is shared, not copied, so it must not
+// be changed once put.
+type SharedMemoryStore interface {
+	Put(key string, data []byte)
+	Get(key string) ([]byte, bool)
+}
+
+// ShutdownCause tells why a task stopped.
+type ShutdownCause int
+
+const (
+	// The job was shut down by ShutdownJob.
+	NormalCompletion ShutdownCause = iota
+	EpochTimeout
+	// The task failed, or the job was shut down because a task couldn't go
+	// on, e.g. its Init timed out or an epoch kept failing validation.
+	TaskFailure
+	// The context of the framework is done, see Bootstrap.WithContext.
+	ExternalSignal
+	EtcdFailure
+)
+
+// EtcdHealth is a snapshot of the health of the etcd cluster. It's healthy
+// if it has a leader.
+type EtcdHealth struct {
+	Leader    string
+	Members   []string
+	RTT       time.Duration
+	IsHealthy bool
+}
+
+// NodeHealth is a snapshot of the health of a single task.
+type NodeHealth struct {
+	TaskID        uint64
+	Status        string
+	LastHeartbeat time.Time
+	CurrentEpoch  uint64
+	ErrorCount    int
+	// the machine or container the task runs on, see Bootstrap.SetNodeID.
+	NodeID string
+}
+
+// BandwidthStats are the transfer rates over a link between two tasks.
+type BandwidthStats struct {
+	SendBytesPerSec float64
+	RecvBytesPerSec float64
+}
+
+// ConnectivityError lists the tasks that couldn't be reached.
+type ConnectivityError struct {
+	Unreachable []uint64
+}
+
+func (e *ConnectivityError) Error() string {
+	return fmt.Sprintf("tasks %v unreachable", e.Unreachable)
+}
+
+// Credentials of an etcd user.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// RPCTimeoutError tells that a task didn't answer a data request in time.
+type RPCTimeoutError struct {
+	PeerID  uint64
+	Elapsed time.Duration
+}
+
+func (e *RPCTimeoutError) Error() string {
+	return fmt.Sprintf("rpc to task %d timed out after %v", e.PeerID, e.Elapsed)
+}
+
+// MissingTasksError lists the tasks that didn't show up in time.
+type MissingTasksError struct {
+	Missing []uint64
+}
+
+func (e *MissingTasksError) Error() string {
+	return fmt.Sprintf("tasks %v missing", e.Missing)
+}
+
+// QueueDepth is the number of operations a task has pending.
+type QueueDepth struct {
+	// data requests received but not yet served.
+	PendingIncoming int
+	// data requests issued but not yet responded.
+	PendingOutgoing int
+	// meta flags of parents and children not yet handled by the task.
+	PendingMetaFlags int
+}
+
+// HeartbeatStatus is the status a task reports with its heartbeat.
+type HeartbeatStatus struct {
+	// CPUs busy on average since the last heartbeat, e.g. 1.5, and bytes of
+	// heap and stack in use, both by the process running the task.
+	CPUUsage    float64
+	MemoryUsage uint64
+
+	CurrentEpoch uint64
+	// data requests sent by the task still waiting for a response.
+	PendingRequests int
+}
+
+// Steps of a data request recorded in TraceEvent.Type.
+const (
+	TraceRequestIssued    = "request issued"
+	TraceResponseReceived = "response received"
+	TraceCallbackCalled   = "callback called"
+	TraceError            = "error"
+)
+
+// TraceEvent records one step of a data request to or from task TaskID.
+type TraceEvent struct {
+	Type   string
+	TaskID uint64
+	Epoch  uint64
+	Req    string
+	Err    string
+	// UnixNano timestamp
+	Time int64
 }
 
 // Context is used in task callbacks. It provides APIs for tasks to ask framework
@@ -52,6 +708,71 @@ type Context interface {
 	// Some task can inform all participating tasks to new epoch
 	IncEpoch()
 
-	// Request data from parent or children.
+	// The epoch the context is for, which is an earlier one than the
+	// current epoch for data of epochs kept open, see
+	// Bootstrap.SetMaxParallelEpochs.
+	GetEpoch() uint64
+
+	// Same as IncEpoch, but returns without waiting for the epoch update.
+	// callback is called with the new epoch once the update is done, or with
+	// the current one and the error if the epoch validator rejected it.
+	IncEpochAsync(callback func(newEpoch uint64, err error))
+
+	// Request data from parent or children. When the request is sent depends
+	// on the data request policy, see Bootstrap.SetDataRequestPolicy.
 	DataRequest(toID uint64, meta string)
+
+	// Request data from all the given parents or children at once, instead
+	// of one DataRequest after another.
+	BulkDataRequest(peers []uint64, meta string)
+
+	// Send the data requests the data request policy held back in this epoch.
+	FlushDataRequests()
+
+	// Abandon this epoch on every task of the job, e.g. on bad input data.
+	// Tasks are told via EpochAbortHandler, forget the data requests and
+	// responses of the epoch, and start it over.
+	AbortEpoch(reason string)
+
+	// Send data to parents without being asked. Parents get it via
+	// PushReceiver.ParentPushReceived.
+	PushDataToParent(data []byte)
+
+	// Tells if enough children have responded to data requests in this epoch,
+	// including the one being handled, see Bootstrap.SetAggregationQuorum.
+	IsQuorumMet() bool
+
+	// The time SetEpoch was called for the current epoch on this task, e.g.
+	// to log how long the epoch took before calling IncEpoch.
+	GetCurrentEpochStartTime() time.Time
+
+	// Emit an application metric, e.g. the loss value, to the metrics sink.
+	// name gets prefixed with UserMetricPrefix.
+	EmitMetric(name string, value float64, labels map[string]string)
+
+	// Weigh the data of a child in this epoch, e.g. by its dataset size for
+	// FedAvg. It's meant to be called in SetEpoch or ChildMetaReady, before
+	// the data is aggregated. Children default to a weight of 1.
+	SetChildWeight(childID uint64, weight float64)
+	GetChildWeight(childID uint64) float64
+
+	// The random number generator of the framework, see
+	// Bootstrap.SetGlobalSeed. It's safe for concurrent use.
+	GetRand() *rand.Rand
+
+	// Block until all tasks of the group reached the group barrier in this
+	// epoch, see Bootstrap.SetTaskGroupBarrier. It's to be called once per
+	// epoch, and fails if this task isn't in the group, or once the epoch is
+	// over or aborted, which starts the barrier over.
+	ReachBarrier(group string) error
+
+	// Apply the gradient to the parameters with the function given to
+	// Bootstrap.SetParameterUpdateFunction. Without one, param is returned
+	// as is.
+	ApplyGradient(param, gradient []byte) []byte
+
+	// Tells if this epoch is one of the warm-up epochs, see
+	// Bootstrap.SetWarmupEpochs, e.g. to accumulate gradients without
+	// applying them to the parameters.
+	IsWarmup() bool
 }
@@ -28,26 +28,24 @@ func TestMasterSetEpochFailure(t *testing.T) {
 	controller.Start()
 	defer controller.Stop()
 
+	framework.EnableFaultInjectionMode()
+	defer framework.DisableFaultInjectionMode()
+	framework.InjectFaultAt(0, "SetEpoch", 1)
+
 	// We need to set etcd so that nodes know what to do.
 	taskBuilder := &framework.SimpleTaskBuilder{
-		GDataChan:    make(chan int32, 10),
-		FinishChan:   make(chan struct{}),
-		NodeProducer: make(chan bool, 1),
-		MasterConfig: map[string]string{
-			"SetEpoch":  "fail",
-			"failepoch": "1",
-			"faillevel": "100",
-		},
+		GDataChan:          make(chan int32, 10),
+		FinishChan:         make(chan struct{}),
 		NumberOfIterations: numOfIterations,
 	}
+	nodeProducer := make(chan bool, 1)
 	for i := uint64(0); i < numOfTasks; i++ {
-		go drive(t, job, etcdURLs, numOfTasks, taskBuilder)
+		go drive(t, job, etcdURLs, numOfTasks, taskBuilder, nodeProducer)
 	}
-	if <-taskBuilder.NodeProducer {
-		taskBuilder.MasterConfig = nil
+	if <-nodeProducer {
 		log.Println("Starting a new node")
-		// this time we start a new bootstrap whose task master doesn't fail.
-		go drive(t, job, etcdURLs, numOfTasks, taskBuilder)
+		// The fault fired already, so the new node's task master doesn't fail.
+		go drive(t, job, etcdURLs, numOfTasks, taskBuilder, nodeProducer)
 	}
 
 	wantData := []int32{0, 105, 210, 315, 420, 525, 630, 735, 840, 945, 1050}
@@ -101,18 +99,18 @@ func testSlaveFailure(t *testing.T, job string, slaveConfig map[string]string) {
 	taskBuilder := &framework.SimpleTaskBuilder{
 		GDataChan:          make(chan int32, 10),
 		FinishChan:         make(chan struct{}),
-		NodeProducer:       make(chan bool, 1),
 		SlaveConfig:        slaveConfig,
 		NumberOfIterations: numOfIterations,
 	}
+	nodeProducer := make(chan bool, 1)
 	go func() {
-		for _ = range taskBuilder.NodeProducer {
+		for _ = range nodeProducer {
 			log.Println("Starting a new node")
-			go drive(t, job, etcdURLs, numOfTasks, taskBuilder)
+			go drive(t, job, etcdURLs, numOfTasks, taskBuilder, nodeProducer)
 		}
 	}()
 	for i := uint64(0); i < numOfTasks; i++ {
-		taskBuilder.NodeProducer <- true
+		nodeProducer <- true
 	}
 
 	wantData := []int32{0, 105, 210, 315, 420, 525, 630, 735, 840, 945, 1050}
@@ -126,6 +124,6 @@ func testSlaveFailure(t *testing.T, job string, slaveConfig map[string]string) {
 			t.Errorf("#%d: data want = %d, get = %d", i, wantData[i], getData[i])
 		}
 	}
-	close(taskBuilder.NodeProducer)
+	close(nodeProducer)
 	<-taskBuilder.FinishChan
 }
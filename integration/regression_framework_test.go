@@ -2,7 +2,9 @@ package integration
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
 	"testing"
 
 	"github.com/coreos/go-etcd/etcd"
@@ -36,7 +38,7 @@ func TestRegressionFramework(t *testing.T) {
 		NumberOfIterations: numOfIterations,
 	}
 	for i := uint64(0); i < numOfTasks; i++ {
-		go drive(t, job, etcds, numOfTasks, taskBuilder)
+		go drive(t, job, etcds, numOfTasks, taskBuilder, nil)
 	}
 
 	wantData := []int32{0, 105, 210, 315, 420, 525, 630, 735, 840, 945, 1050}
@@ -53,6 +55,109 @@ func TestRegressionFramework(t *testing.T) {
 	<-taskBuilder.FinishChan
 }
 
+// TestGradientCorrectnessAllTopologies runs the regression framework on trees
+// of different shapes. Every slave adds taskID * epoch to the gradient, so the
+// master should get epoch * (0 + 1 + ... + numOfTasks-1) at each epoch.
+// A star is a tree whose fanout makes every slave a child of the master.
+func TestGradientCorrectnessAllTopologies(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "framework_topologies_test")
+	m.Launch()
+	defer m.Terminate(t)
+	etcds := []string{fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())}
+	numOfIterations := uint64(3)
+
+	tests := []struct {
+		name       string
+		fanout     uint64
+		numOfTasks uint64
+	}{
+		{"binary_tree", 2, 7},
+		{"ternary_tree", 3, 13},
+		{"star", 5, 6},
+	}
+	for i, tt := range tests {
+		job := "framework_topologies_test_" + tt.name
+		controller := controller.New(job, etcd.NewClient(etcds), tt.numOfTasks)
+		controller.InitEtcdLayout()
+
+		taskBuilder := &framework.SimpleTaskBuilder{
+			GDataChan:          make(chan int32, numOfIterations+1),
+			FinishChan:         make(chan struct{}),
+			NumberOfIterations: numOfIterations,
+		}
+		for j := uint64(0); j < tt.numOfTasks; j++ {
+			go func(fanout, numOfTasks uint64) {
+				bootstrap := framework.NewBootStrap(job, etcds, createListener(t), nil)
+				bootstrap.SetTaskBuilder(taskBuilder)
+				bootstrap.SetTopology(example.NewTreeTopology(fanout, numOfTasks))
+				bootstrap.Start()
+			}(tt.fanout, tt.numOfTasks)
+		}
+
+		sumOfIDs := int32(tt.numOfTasks * (tt.numOfTasks - 1) / 2)
+		for epoch := int32(0); epoch <= int32(numOfIterations); epoch++ {
+			if get := <-taskBuilder.GDataChan; get != epoch*sumOfIDs {
+				t.Errorf("#%d %s: epoch %d: data want = %d, get = %d", i, tt.name, epoch, epoch*sumOfIDs, get)
+			}
+		}
+		<-taskBuilder.FinishChan
+		controller.DestroyEtcdLayout()
+	}
+}
+
+// TestRegressionFrameworkReplay simulates a job that was interrupted after
+// checkpointing at epoch 5. The resumed job should only run epochs 5 to 10.
+func TestRegressionFrameworkReplay(t *testing.T) {
+	job := "framework_replay_test"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	etcds := []string{m.URL()}
+	numOfTasks := uint64(15)
+	numOfIterations := uint64(10)
+	replayEpoch := uint64(5)
+
+	dir, err := ioutil.TempDir("", job)
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for i := uint64(0); i < numOfTasks; i++ {
+		data := []byte(fmt.Sprintf("{\"Value\":%d}", replayEpoch))
+		if err := ioutil.WriteFile(framework.CheckpointPath(dir, i, replayEpoch), data, 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	controller := controller.New(job, etcd.NewClient(etcds), numOfTasks)
+	controller.InitEtcdLayout()
+	defer controller.DestroyEtcdLayout()
+
+	taskBuilder := &framework.SimpleTaskBuilder{
+		GDataChan:          make(chan int32, 11),
+		FinishChan:         make(chan struct{}),
+		NumberOfIterations: numOfIterations,
+	}
+	for i := uint64(0); i < numOfTasks; i++ {
+		go func() {
+			bootstrap := framework.NewBootStrap(job, etcds, createListener(t), nil)
+			if err := bootstrap.ReplayFromCheckpoint(dir, replayEpoch); err != nil {
+				t.Errorf("ReplayFromCheckpoint failed: %v", err)
+			}
+			bootstrap.SetTaskBuilder(taskBuilder)
+			bootstrap.SetTopology(example.NewTreeTopology(2, numOfTasks))
+			bootstrap.Start()
+		}()
+	}
+
+	wantData := []int32{525, 630, 735, 840, 945, 1050}
+	for i := range wantData {
+		if get := <-taskBuilder.GDataChan; get != wantData[i] {
+			t.Errorf("#%d: data want = %d, get = %d", i, wantData[i], get)
+		}
+	}
+	<-taskBuilder.FinishChan
+}
+
 func createListener(t *testing.T) net.Listener {
 	l, err := net.Listen("tcp4", "127.0.0.1:0")
 	if err != nil {
@@ -62,9 +167,11 @@ func createListener(t *testing.T) net.Listener {
 }
 
 // This is used to show how to drive the network.
-func drive(t *testing.T, jobName string, etcds []string, ntask uint64, taskBuilder meritop.TaskBuilder) {
+// nodeProducer is told when the node fails, if not nil.
+func drive(t *testing.T, jobName string, etcds []string, ntask uint64, taskBuilder meritop.TaskBuilder, nodeProducer chan<- bool) {
 	bootstrap := framework.NewBootStrap(jobName, etcds, createListener(t), nil)
 	bootstrap.SetTaskBuilder(taskBuilder)
+	bootstrap.SetNodeProducer(nodeProducer)
 	bootstrap.SetTopology(example.NewTreeTopology(2, ntask))
 	bootstrap.Start()
 }
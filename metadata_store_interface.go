@@ -0,0 +1,34 @@
+package meritop
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrKeyNotFound = errors.New("metadata store: key not found")
+
+// MetadataStore is where the framework keeps task metadata, e.g. the meta
+// flags tasks raise to their parents and children. It is backed by etcd
+// unless the application plugs in something else.
+type MetadataStore interface {
+	Put(ctx context.Context, key, value string) error
+	// Get returns ErrKeyNotFound if there is no such key.
+	Get(ctx context.Context, key string) (string, error)
+	// Watch reports every change to keys under prefix made after Watch
+	// returns. The channel is closed once ctx is done.
+	Watch(ctx context.Context, prefix string) (<-chan KVEvent, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type KVEventType int
+
+const (
+	KVPut KVEventType = iota
+	KVDelete
+)
+
+type KVEvent struct {
+	Type  KVEventType
+	Key   string
+	Value string
+}
@@ -0,0 +1,11 @@
+package meritop
+
+// User metrics are emitted with this prefix, so that they don't collide with
+// metrics of the framework itself.
+const UserMetricPrefix = "meritop_user_"
+
+// MetricsSink is the metrics backend framework emits to, e.g. an adapter for
+// Prometheus or StatsD.
+type MetricsSink interface {
+	EmitMetric(name string, value float64, labels map[string]string)
+}
@@ -7,8 +7,11 @@ import (
 	"github.com/coreos/go-etcd/etcd"
 )
 
-func GetAndWatchEpoch(client *etcd.Client, appname string, epochC chan uint64, stop chan bool) (uint64, error) {
-	resp, err := client.Get(EpochPath(appname), false, false)
+// The epoch helpers take the key the epoch is kept in, EpochPath(appName)
+// unless customized.
+
+func GetAndWatchEpoch(client *etcd.Client, key string, epochC chan uint64, stop chan bool) (uint64, error) {
+	resp, err := client.Get(key, false, false)
 	if err != nil {
 		log.Fatal("etcdutil: can not get epoch from etcd")
 	}
@@ -17,7 +20,7 @@ func GetAndWatchEpoch(client *etcd.Client, appname string, epochC chan uint64, s
 		return 0, err
 	}
 	receiver := make(chan *etcd.Response, 1)
-	go client.Watch(EpochPath(appname), resp.EtcdIndex+1, false, receiver, stop)
+	go client.Watch(key, resp.EtcdIndex+1, false, receiver, stop)
 	go func() {
 		for resp := range receiver {
 			if resp.Action != "compareAndSwap" && resp.Action != "set" {
@@ -34,9 +37,17 @@ func GetAndWatchEpoch(client *etcd.Client, appname string, epochC chan uint64, s
 	return ep, nil
 }
 
-func CASEpoch(client *etcd.Client, appname string, prevEpoch, epoch uint64) error {
+func GetEpoch(client *etcd.Client, key string) (uint64, error) {
+	resp, err := client.Get(key, false, false)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(resp.Node.Value, 10, 64)
+}
+
+func CASEpoch(client *etcd.Client, key string, prevEpoch, epoch uint64) error {
 	prevEpochStr := strconv.FormatUint(prevEpoch, 10)
 	epochStr := strconv.FormatUint(epoch, 10)
-	_, err := client.CompareAndSwap(EpochPath(appname), epochStr, 0, prevEpochStr, 0)
+	_, err := client.CompareAndSwap(key, epochStr, 0, prevEpochStr, 0)
 	return err
 }
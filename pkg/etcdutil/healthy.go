@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coreos/go-etcd/etcd"
@@ -13,8 +14,14 @@ import (
 
 // heartbeat to etcd cluster until stop
 func Heartbeat(client *etcd.Client, name string, taskID uint64, interval time.Duration, stop chan struct{}) error {
+	return HeartbeatWithStatus(client, name, taskID, interval, func() string { return "health" }, stop)
+}
+
+// HeartbeatWithStatus is like Heartbeat, but every beat carries the value
+// returned by status, so that others can learn how the task is doing.
+func HeartbeatWithStatus(client *etcd.Client, name string, taskID uint64, interval time.Duration, status func() string, stop chan struct{}) error {
 	for {
-		_, err := client.Set(TaskHealthyPath(name, taskID), "health", computeTTL(interval))
+		_, err := client.Set(TaskHealthyPath(name, taskID), status(), computeTTL(interval))
 		if err != nil {
 			return err
 		}
@@ -49,14 +56,15 @@ func ReportFailure(client *etcd.Client, name, failedTask string) error {
 	return err
 }
 
-// WaitFreeTask blocks until it gets a hint of free task
-func WaitFreeTask(client *etcd.Client, name string, logger *log.Logger) (uint64, error) {
+// WaitFreeTask blocks until it gets a hint of free task. If there are many,
+// one is picked at random by r.
+func WaitFreeTask(client *etcd.Client, name string, r *rand.Rand, logger *log.Logger) (uint64, error) {
 	slots, err := client.Get(FreeTaskDir(name), false, true)
 	if err != nil {
 		return 0, err
 	}
 	if total := len(slots.Node.Nodes); total > 0 {
-		ri := rand.Intn(total)
+		ri := r.Intn(total)
 		s := slots.Node.Nodes[ri]
 		idStr := path.Base(s.Key)
 		id, err := strconv.ParseUint(idStr, 0, 64)
@@ -98,9 +106,72 @@ func WaitFreeTask(client *etcd.Client, name string, logger *log.Logger) (uint64,
 	return id, nil
 }
 
+// TaskStatus is what a task reports about itself with every heartbeat.
+type TaskStatus struct {
+	Epoch           uint64
+	ErrorCount      uint64
+	CPUUsage        float64
+	MemoryUsage     uint64
+	PendingRequests uint64
+	PendingIncoming uint64
+	PendingMeta     uint64
+	NodeID          string
+}
+
+// String encodes the status as "{epoch}-{errorCount}-{cpuUsage}-
+// {memoryUsage}-{pendingRequests}-{pendingIncoming}-{pendingMeta}-{nodeID}".
+// The node ID comes last, as it might contain "-" itself.
+func (s TaskStatus) String() string {
+	return fmt.Sprintf("%d-%d-%s-%d-%d-%d-%d-%s", s.Epoch, s.ErrorCount,
+		strconv.FormatFloat(s.CPUUsage, 'f', -1, 64), s.MemoryUsage, s.PendingRequests,
+		s.PendingIncoming, s.PendingMeta, s.NodeID)
+}
+
+func ParseTaskStatus(value string) (s TaskStatus, err error) {
+	values := strings.SplitN(value, "-", 8)
+	if len(values) != 8 {
+		return s, fmt.Errorf("etcdutil: malformed heartbeat status %q", value)
+	}
+	if s.Epoch, err = strconv.ParseUint(values[0], 10, 64); err != nil {
+		return s, err
+	}
+	if s.ErrorCount, err = strconv.ParseUint(values[1], 10, 64); err != nil {
+		return s, err
+	}
+	if s.CPUUsage, err = strconv.ParseFloat(values[2], 64); err != nil {
+		return s, err
+	}
+	if s.MemoryUsage, err = strconv.ParseUint(values[3], 10, 64); err != nil {
+		return s, err
+	}
+	if s.PendingRequests, err = strconv.ParseUint(values[4], 10, 64); err != nil {
+		return s, err
+	}
+	if s.PendingIncoming, err = strconv.ParseUint(values[5], 10, 64); err != nil {
+		return s, err
+	}
+	if s.PendingMeta, err = strconv.ParseUint(values[6], 10, 64); err != nil {
+		return s, err
+	}
+	s.NodeID = values[7]
+	return s, nil
+}
+
+// LastHeartbeat estimates when the given healthy node was last refreshed by
+// a heartbeat with the given interval.
+func LastHeartbeat(n *etcd.Node, interval time.Duration) time.Time {
+	if n.Expiration == nil {
+		return time.Time{}
+	}
+	return n.Expiration.Add(-time.Duration(computeTTL(interval)) * time.Second)
+}
+
+// computeTTL returns the TTL in seconds that lets a key outlive three
+// heartbeat intervals, rounded up to whole seconds.
 func computeTTL(interval time.Duration) uint64 {
-	if interval/time.Second < 1 {
-		return 3
+	ttl := uint64((3*interval + time.Second - 1) / time.Second)
+	if ttl < 1 {
+		return 1
 	}
-	return 3 * uint64(interval/time.Second)
+	return ttl
 }
@@ -1,42 +1,91 @@
 package etcdutil
 
 import (
+	"fmt"
 	"path"
 	"strconv"
 )
 
-// The directory layout we going to define in etcd:
+// The directory layout we going to define in etcd. All of it is scoped under
+// an optional namespace and a key prefix (DefaultKeyPrefix unless configured),
+// so {app} below stands for /{namespace}/{prefix}/{jobName}:
 //   /{app}/config -> application configuration
 //   /{app}/epoch -> global value for epoch
+//   /{app}/abort -> {epoch}-{reason} of the last aborted epoch
+//   /{app}/commands/{taskID} -> the last command sent to the task, as JSON
+//   /{app}/barriers/{group}/{epoch}/{taskID} -> the task reached the group barrier
 //   /{app}/tasks/: register tasks under this directory
 //   /{app}/tasks/{taskID}/{replicaID} -> pointer to nodes, 0 replicaID means master
 //   /{app}/tasks/{taskID}/parentMeta
 //   /{app}/tasks/{taskID}/childMeta
+//   /{app}/tasks/{taskID}/affinity -> affinity group of the task, if any
 //   /{app}/healthy/{taskID} -> tasks' healthy condition
 //   /{app}/nodes/: register nodes under this directory
 //   /{app}/nodes/{nodeID}/address -> scheme://host:port/{path(if http)}
 //   /{app}/nodes/{nodeID}/ttl -> keep alive timeout
 //   /{app}/FreeTasks/{taskID}
 
+const DefaultKeyPrefix = "/meritop"
+
 const (
 	TasksDir       = "tasks"
 	NodesDir       = "nodes"
 	ConfigDir      = "config"
 	FreeDir        = "freeTasks"
 	Epoch          = "epoch"
+	Abort          = "abort"
+	CommandsDir    = "commands"
+	BarriersDir    = "barriers"
 	Status         = "status"
 	TaskMaster     = "0"
 	TaskParentMeta = "parentMeta"
 	TaskChildMeta  = "childMeta"
+	TaskAffinity   = "affinity"
 	NodeAddr       = "address"
 	NodeTTL        = "ttl"
 	Healthy        = "healthy"
 )
 
+// JobPath returns the directory that holds every key of the given job. The
+// result can be passed as appName to all the path helpers below. An empty
+// namespace is left out.
+func JobPath(namespace, prefix, jobName string) string {
+	return path.Join("/", namespace, prefix, jobName)
+}
+
+// CheckNamespace makes sure a namespace only has letters, digits and hyphens,
+// so that it can't reach out of its own directory.
+func CheckNamespace(ns string) error {
+	for _, r := range ns {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-':
+		default:
+			return fmt.Errorf("etcdutil: invalid character %q in namespace %q", r, ns)
+		}
+	}
+	return nil
+}
+
 func EpochPath(appName string) string {
 	return path.Join("/", appName, Epoch)
 }
 
+func AbortPath(appName string) string {
+	return path.Join("/", appName, Abort)
+}
+
+func TaskCommandPath(appName string, taskID uint64) string {
+	return path.Join("/", appName, CommandsDir, strconv.FormatUint(taskID, 10))
+}
+
+func BarrierDir(appName, group string, epoch, attempt uint64) string {
+	return path.Join("/", appName, BarriersDir, group, strconv.FormatUint(epoch, 10), strconv.FormatUint(attempt, 10))
+}
+
+func BarrierPath(appName, group string, epoch, attempt, taskID uint64) string {
+	return path.Join(BarrierDir(appName, group, epoch, attempt), strconv.FormatUint(taskID, 10))
+}
+
 func JobStatusPath(appName string) string {
 	return path.Join("/", appName, Status)
 }
@@ -78,3 +127,11 @@ func ChildMetaPath(appName string, taskID uint64) string {
 		strconv.FormatUint(taskID, 10),
 		TaskChildMeta)
 }
+
+func TaskAffinityPath(appName string, taskID uint64) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		TaskAffinity)
+}
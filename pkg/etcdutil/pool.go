@@ -0,0 +1,54 @@
+package etcdutil
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pool shares connections to etcd among frameworks running in the same
+// process: each set of etcd machines and timeouts gets one transport, which
+// the etcd clients of those frameworks use. Clients keep their own
+// credentials. It is safe for concurrent use.
+type Pool struct {
+	mu         sync.Mutex
+	transports map[poolKey]*http.Transport
+}
+
+type poolKey struct {
+	machines                    string
+	dialTimeout, requestTimeout time.Duration
+}
+
+func NewPool() *Pool {
+	return &Pool{transports: make(map[poolKey]*http.Transport)}
+}
+
+// Transport returns the transport shared by everyone talking to the given
+// machines with the given timeouts. Zero requestTimeout means none.
+func (p *Pool) Transport(machines []string, dialTimeout, requestTimeout time.Duration) *http.Transport {
+	key := poolKey{strings.Join(machines, ","), dialTimeout, requestTimeout}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tr, ok := p.transports[key]
+	if !ok {
+		tr = &http.Transport{
+			Dial:                  (&net.Dialer{Timeout: dialTimeout, KeepAlive: time.Second}).Dial,
+			ResponseHeaderTimeout: requestTimeout,
+		}
+		p.transports[key] = tr
+	}
+	return tr
+}
+
+// Close closes idle connections of all transports in the pool.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, tr := range p.transports {
+		tr.CloseIdleConnections()
+		delete(p.transports, key)
+	}
+}
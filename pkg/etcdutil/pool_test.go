@@ -0,0 +1,30 @@
+package etcdutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	p := NewPool()
+	machines := []string{"http://127.0.0.1:4001"}
+	tr := p.Transport(machines, time.Second, 0)
+	if p.Transport(machines, time.Second, 0) != tr {
+		t.Errorf("transport of the same machines and timeouts isn't shared")
+	}
+	if p.Transport([]string{"http://127.0.0.1:4002"}, time.Second, 0) == tr {
+		t.Errorf("transport shared among different machines")
+	}
+	timed := p.Transport(machines, time.Second, 3*time.Second)
+	if timed == tr {
+		t.Errorf("transport shared among different request timeouts")
+	}
+	if timed.ResponseHeaderTimeout != 3*time.Second {
+		t.Errorf("response header timeout = %v, want %v", timed.ResponseHeaderTimeout, 3*time.Second)
+	}
+
+	p.Close()
+	if p.Transport(machines, time.Second, 0) == tr {
+		t.Errorf("transport still shared after Close")
+	}
+}
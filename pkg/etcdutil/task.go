@@ -2,19 +2,25 @@ package etcdutil
 
 import (
 	"log"
+	"path"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 )
 
-func TryOccupyTask(client *etcd.Client, name string, taskID uint64, connection string) bool {
-	_, err := client.Create(TaskHealthyPath(name, taskID), "health", 3)
+// TryOccupyTask registers connection as the address of the task at
+// masterKey, TaskMasterPath(name, taskID) unless customized. The healthy
+// key of the task lives as long as heartbeats at the given interval keep it.
+func TryOccupyTask(client *etcd.Client, name string, taskID uint64, masterKey, connection string, heartbeatInterval time.Duration) bool {
+	_, err := client.Create(TaskHealthyPath(name, taskID), "health", computeTTL(heartbeatInterval))
 	if err != nil {
 		return false
 	}
 	idStr := strconv.FormatUint(taskID, 10)
 	client.Delete(FreeTaskPath(name, idStr), false)
-	_, err = client.Set(TaskMasterPath(name, taskID), connection, 0)
+	_, err = client.Set(masterKey, connection, 0)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -25,8 +31,9 @@ func TryOccupyTask(client *etcd.Client, name string, taskID uint64, connection s
 // the task that we want to talk to.
 // Currently we grab the information from etcd every time. Local cache could be used.
 // If it failed, e.g. network failure, it should return error.
-func GetAddress(client *etcd.Client, name string, id uint64) (string, error) {
-	resp, err := client.Get(TaskMasterPath(name, id), false, false)
+// masterKey is where the task registered, see TryOccupyTask.
+func GetAddress(client *etcd.Client, masterKey string) (string, error) {
+	resp, err := client.Get(masterKey, false, false)
 	if err != nil {
 		return "", err
 	}
@@ -34,6 +41,39 @@ func GetAddress(client *etcd.Client, name string, id uint64) (string, error) {
 }
 
 func SetJobStatus(client *etcd.Client, name string, status int) error {
-	_, err := client.Set(JobStatusPath(name), "done", 0)
+	_, err := client.Set(JobStatusPath(name), strconv.Itoa(status), 0)
 	return err
 }
+
+// GetJobStatus returns the status the job was shut down with.
+func GetJobStatus(client *etcd.Client, name string) (int, error) {
+	resp, err := client.Get(JobStatusPath(name), false, false)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(resp.Node.Value)
+}
+
+// ListTaskIDs returns the IDs of all tasks laid out for the job, in order.
+func ListTaskIDs(client *etcd.Client, name string) ([]uint64, error) {
+	resp, err := client.Get(TaskDirPath(name), false, false)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, 0, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		id, err := strconv.ParseUint(path.Base(n.Key), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	sort.Sort(uint64Slice(ids))
+	return ids, nil
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
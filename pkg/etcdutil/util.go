@@ -6,6 +6,29 @@ import (
 	"github.com/coreos/go-etcd/etcd"
 )
 
+// error codes etcd returns when the requested key doesn't exist, when the
+// compare of a compare-and-swap failed, and when a key to create exists.
+const (
+	ErrCodeKeyNotFound = 100
+	ErrCodeTestFailed  = 101
+	ErrCodeNodeExist   = 105
+)
+
+func IsKeyNotFound(err error) bool {
+	e, ok := err.(*etcd.EtcdError)
+	return ok && e.ErrorCode == ErrCodeKeyNotFound
+}
+
+func IsTestFailed(err error) bool {
+	e, ok := err.(*etcd.EtcdError)
+	return ok && e.ErrorCode == ErrCodeTestFailed
+}
+
+func IsNodeExist(err error) bool {
+	e, ok := err.(*etcd.EtcdError)
+	return ok && e.ErrorCode == ErrCodeNodeExist
+}
+
 func ListKeys(nodes []*etcd.Node) []string {
 	res := make([]string, len(nodes))
 	for i, n := range nodes {
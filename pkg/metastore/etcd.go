@@ -0,0 +1,84 @@
+package metastore
+
+import (
+	"context"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// EtcdMetadataStore keeps metadata in etcd.
+type EtcdMetadataStore struct {
+	client *etcd.Client
+	// used for watches, which are long polls. It's client unless set.
+	watchClient *etcd.Client
+}
+
+func NewEtcdMetadataStore(client *etcd.Client) *EtcdMetadataStore {
+	return &EtcdMetadataStore{client: client, watchClient: client}
+}
+
+// SetWatchClient makes watches use a client of their own, e.g. one without
+// request timeout.
+func (s *EtcdMetadataStore) SetWatchClient(c *etcd.Client) { s.watchClient = c }
+
+func (s *EtcdMetadataStore) Put(ctx context.Context, key, value string) error {
+	_, err := s.client.Set(key, value, 0)
+	return err
+}
+
+func (s *EtcdMetadataStore) Get(ctx context.Context, key string) (string, error) {
+	resp, err := s.client.Get(key, false, false)
+	if err != nil {
+		if etcdutil.IsKeyNotFound(err) {
+			return "", meritop.ErrKeyNotFound
+		}
+		return "", err
+	}
+	return resp.Node.Value, nil
+}
+
+func (s *EtcdMetadataStore) Watch(ctx context.Context, prefix string) (<-chan meritop.KVEvent, error) {
+	// Find out where we are now, so that no change after return is missed.
+	var index uint64
+	resp, err := s.watchClient.Get(prefix, false, false)
+	switch {
+	case err == nil:
+		index = resp.EtcdIndex
+	case etcdutil.IsKeyNotFound(err):
+		index = err.(*etcd.EtcdError).Index
+	default:
+		return nil, err
+	}
+
+	stop := make(chan bool, 1)
+	receiver := make(chan *etcd.Response, 1)
+	events := make(chan meritop.KVEvent, 1)
+	go s.watchClient.Watch(prefix, index+1, true, receiver, stop)
+	go func() {
+		<-ctx.Done()
+		stop <- true
+	}()
+	go func() {
+		defer close(events)
+		for resp := range receiver {
+			ev := meritop.KVEvent{Type: meritop.KVPut, Key: resp.Node.Key, Value: resp.Node.Value}
+			switch resp.Action {
+			case "delete", "expire", "compareAndDelete":
+				ev.Type = meritop.KVDelete
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (s *EtcdMetadataStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.Delete(key, false)
+	return err
+}
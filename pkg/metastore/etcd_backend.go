@@ -0,0 +1,55 @@
+package metastore
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// EtcdBackend coordinates tasks through etcd.
+type EtcdBackend struct {
+	*EtcdMetadataStore
+}
+
+func NewEtcdBackend(client *etcd.Client) *EtcdBackend {
+	return &EtcdBackend{NewEtcdMetadataStore(client)}
+}
+
+func (b *EtcdBackend) Register(ctx context.Context, key, value string) error {
+	return b.Put(ctx, key, value)
+}
+
+func (b *EtcdBackend) Deregister(ctx context.Context, key string) error {
+	return b.Delete(ctx, key)
+}
+
+func (b *EtcdBackend) AtomicCompareAndSwap(ctx context.Context, key, prevValue, value string) error {
+	_, err := b.client.CompareAndSwap(key, value, 0, prevValue, 0)
+	switch {
+	case etcdutil.IsTestFailed(err):
+		return meritop.ErrCompareFailed
+	case etcdutil.IsKeyNotFound(err):
+		return meritop.ErrKeyNotFound
+	}
+	return err
+}
+
+func (b *EtcdBackend) CreateEphemeral(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := b.client.Create(key, value, ttlSeconds(ttl))
+	if etcdutil.IsNodeExist(err) {
+		return meritop.ErrKeyExists
+	}
+	return err
+}
+
+// ttlSeconds rounds ttl up to whole seconds, as etcd takes them.
+func ttlSeconds(ttl time.Duration) uint64 {
+	s := uint64((ttl + time.Second - 1) / time.Second)
+	if s < 1 {
+		return 1
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package metastore
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-distributed/meritop"
+)
+
+// InMemoryMetadataStore keeps metadata in a map. It's meant for testing
+// tasks running in the same process.
+type InMemoryMetadataStore struct {
+	mu       sync.Mutex
+	kvs      map[string]string
+	watchers map[*watcher]bool
+}
+
+type watcher struct {
+	ctx    context.Context
+	prefix string
+	events chan meritop.KVEvent
+}
+
+func NewInMemoryMetadataStore() *InMemoryMetadataStore {
+	return &InMemoryMetadataStore{
+		kvs:      make(map[string]string),
+		watchers: make(map[*watcher]bool),
+	}
+}
+
+func (s *InMemoryMetadataStore) Put(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kvs[key] = value
+	s.notify(meritop.KVEvent{Type: meritop.KVPut, Key: key, Value: value})
+	return nil
+}
+
+func (s *InMemoryMetadataStore) Get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.kvs[key]
+	if !ok {
+		return "", meritop.ErrKeyNotFound
+	}
+	return v, nil
+}
+
+func (s *InMemoryMetadataStore) Watch(ctx context.Context, prefix string) (<-chan meritop.KVEvent, error) {
+	w := &watcher{
+		ctx:    ctx,
+		prefix: prefix,
+		events: make(chan meritop.KVEvent, 100),
+	}
+	s.mu.Lock()
+	s.watchers[w] = true
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers, w)
+		close(w.events)
+	}()
+	return w.events, nil
+}
+
+func (s *InMemoryMetadataStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.kvs[key]; !ok {
+		return meritop.ErrKeyNotFound
+	}
+	delete(s.kvs, key)
+	s.notify(meritop.KVEvent{Type: meritop.KVDelete, Key: key})
+	return nil
+}
+
+// notify must be called with s.mu held. Watchers are only removed, and their
+// channels closed, under the same lock.
+func (s *InMemoryMetadataStore) notify(ev meritop.KVEvent) {
+	for w := range s.watchers {
+		if !strings.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.events <- ev:
+		case <-w.ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package metastore
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+// InMemoryBackend coordinates tasks running in the same process through a
+// map. It's meant for testing.
+type InMemoryBackend struct {
+	*InMemoryMetadataStore
+}
+
+func NewInMemoryBackend() *InMemoryBackend {
+	return &InMemoryBackend{NewInMemoryMetadataStore()}
+}
+
+func (b *InMemoryBackend) Register(ctx context.Context, key, value string) error {
+	return b.Put(ctx, key, value)
+}
+
+func (b *InMemoryBackend) Deregister(ctx context.Context, key string) error {
+	return b.Delete(ctx, key)
+}
+
+func (b *InMemoryBackend) AtomicCompareAndSwap(ctx context.Context, key, prevValue, value string) error {
+	s := b.InMemoryMetadataStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.kvs[key]
+	switch {
+	case !ok:
+		return meritop.ErrKeyNotFound
+	case v != prevValue:
+		return meritop.ErrCompareFailed
+	}
+	s.kvs[key] = value
+	s.notify(meritop.KVEvent{Type: meritop.KVPut, Key: key, Value: value})
+	return nil
+}
+
+func (b *InMemoryBackend) CreateEphemeral(ctx context.Context, key, value string, ttl time.Duration) error {
+	s := b.InMemoryMetadataStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.kvs[key]; ok {
+		return meritop.ErrKeyExists
+	}
+	s.kvs[key] = value
+	s.notify(meritop.KVEvent{Type: meritop.KVPut, Key: key, Value: value})
+	time.AfterFunc(ttl, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// Unless it was replaced in the meantime.
+		if v, ok := s.kvs[key]; ok && v == value {
+			delete(s.kvs, key)
+			s.notify(meritop.KVEvent{Type: meritop.KVDelete, Key: key})
+		}
+	})
+	return nil
+}
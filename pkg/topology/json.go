@@ -0,0 +1,77 @@
+// Package topology describes topologies in JSON, e.g.
+//
+//	{"type": "tree", "fanout": 2, "numTasks": 7}
+//
+// or
+//
+//	{"type": "dag", "numTasks": 3, "adjacency": {"0": [1, 2], "1": [2]}}
+//
+// Types are registered by the packages implementing them, e.g. tree and dag
+// by package example.
+package topology
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/go-distributed/meritop"
+)
+
+// Config is the JSON description of a topology. Fields other than Type are
+// up to the topology type.
+type Config struct {
+	Type      string              `json:"type"`
+	Fanout    uint64              `json:"fanout,omitempty"`
+	NumTasks  uint64              `json:"numTasks,omitempty"`
+	Adjacency map[uint64][]uint64 `json:"adjacency,omitempty"`
+}
+
+// A Describer is a topology that can tell its Config.
+type Describer interface {
+	Config() Config
+}
+
+var (
+	buildersMu sync.RWMutex
+	builders   = make(map[string]func(Config) meritop.Topology)
+)
+
+// Register makes topologies of the given type buildable from JSON. It
+// panics if the type is registered twice.
+func Register(typ string, build func(c Config) meritop.Topology) {
+	buildersMu.Lock()
+	defer buildersMu.Unlock()
+	if _, ok := builders[typ]; ok {
+		panic("topology: type " + typ + " registered twice")
+	}
+	builders[typ] = build
+}
+
+// ParseJSON builds the topology described by data and validates it.
+func ParseJSON(data []byte) (meritop.Topology, error) {
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	buildersMu.RLock()
+	build, ok := builders[c.Type]
+	buildersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown topology type %q", c.Type)
+	}
+	topo := build(c)
+	if err := topo.Validate(); err != nil {
+		return nil, err
+	}
+	return topo, nil
+}
+
+// MarshalJSON describes t in the format ParseJSON takes.
+func MarshalJSON(t meritop.Topology) ([]byte, error) {
+	d, ok := t.(Describer)
+	if !ok {
+		return nil, fmt.Errorf("can't describe topology of type %T", t)
+	}
+	return json.Marshal(d.Config())
+}
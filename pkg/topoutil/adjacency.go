@@ -0,0 +1,40 @@
+package topoutil
+
+import "github.com/go-distributed/meritop"
+
+// AdjacencyMatrix returns the topology at the given epoch as a numTasks x
+// numTasks matrix, in which m[i][j] is true if task i is a parent of task j.
+// Tasks out of range are left out.
+func AdjacencyMatrix(t meritop.Topology, numTasks, epoch uint64) [][]bool {
+	m := make([][]bool, numTasks)
+	for i := range m {
+		m[i] = make([]bool, numTasks)
+	}
+	for p, cs := range t.GetAdjacencyList(epoch) {
+		for _, c := range cs {
+			if p < numTasks && c < numTasks {
+				m[p][c] = true
+			}
+		}
+	}
+	return m
+}
+
+// TaskGraph returns the topology at the given epoch over the given tasks as a
+// meritop.TaskGraph. Edges to tasks not given are left out.
+func TaskGraph(t meritop.Topology, taskIDs []uint64, epoch uint64) *meritop.TaskGraph {
+	g := &meritop.TaskGraph{Nodes: append([]uint64(nil), taskIDs...)}
+	known := make(map[uint64]bool, len(taskIDs))
+	for _, id := range taskIDs {
+		known[id] = true
+	}
+	adj := t.GetAdjacencyList(epoch)
+	for _, p := range taskIDs {
+		for _, c := range adj[p] {
+			if known[c] {
+				g.Edges = append(g.Edges, [2]uint64{p, c})
+			}
+		}
+	}
+	return g
+}
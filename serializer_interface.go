@@ -0,0 +1,7 @@
+package meritop
+
+// Serializer encodes the data tasks exchange, e.g. parameters and gradients.
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
@@ -0,0 +1,42 @@
+package meritop
+
+// Commands a supervisor can send to a task.
+const (
+	CommandPause      = "pause"
+	CommandResume     = "resume"
+	CommandScaleDown  = "scale-down"
+	CommandCheckpoint = "checkpoint"
+)
+
+// SupervisorStatus is what a task reports to the supervisor periodically.
+type SupervisorStatus struct {
+	JobName     string
+	TaskID      uint64
+	Epoch       uint64
+	ActiveTasks int
+	ErrorCount  uint64
+}
+
+// SupervisorCommand is a command of the supervisor. N is the number of tasks
+// for CommandScaleDown, Dir the directory to save checkpoints in for
+// CommandCheckpoint.
+type SupervisorCommand struct {
+	Type string
+	N    int
+	Dir  string
+}
+
+// SupervisorClient talks to an external supervisor managing the job
+// lifecycle, e.g. an operator running in Kubernetes.
+type SupervisorClient interface {
+	// ReportStatus sends the status of the task to the supervisor and returns
+	// the commands the supervisor has for it.
+	ReportStatus(status SupervisorStatus) ([]SupervisorCommand, error)
+}
+
+// SupervisorCommandHandler is an interface that task need to implement if they
+// want to act on supervisor commands the framework can't carry out itself,
+// like scaling down, which depends on the application.
+type SupervisorCommandHandler interface {
+	HandleSupervisorCommand(ctx Context, cmd SupervisorCommand)
+}
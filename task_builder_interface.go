@@ -10,3 +10,33 @@ type TaskBuilder interface {
 	// right task implementation for given node/task.
 	GetTask(taskID uint64) Task
 }
+
+// ConfigurableTaskBuilder is a TaskBuilder that takes configuration loaded by
+// the framework, e.g. from environment variables. SetConfig is called before
+// any GetTask and returns the keys the builder doesn't know.
+type ConfigurableTaskBuilder interface {
+	TaskBuilder
+	SetConfig(config map[string]string) (unknown []string)
+}
+
+// ValidatableTaskBuilder is a TaskBuilder that can check its configuration
+// before any task is built. Framework refuses to start if Validate fails.
+type ValidatableTaskBuilder interface {
+	TaskBuilder
+	Validate() error
+}
+
+// IterativeTaskBuilder is a TaskBuilder that knows how many epochs the job
+// runs, so that the framework can size its per-epoch records up front.
+type IterativeTaskBuilder interface {
+	TaskBuilder
+	NumberOfEpochs() uint64
+}
+
+// SizedTaskBuilder is a TaskBuilder that knows how many tasks the job has.
+// If the topology knows too, see SizedTopology, the framework refuses to
+// start unless they agree. Zero means the builder doesn't know.
+type SizedTaskBuilder interface {
+	TaskBuilder
+	GetTaskCount() uint64
+}
@@ -0,0 +1,114 @@
+package meritop
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrNoPath = errors.New("task graph: no path")
+
+// TaskGraph is the topology of a job at an epoch as a plain graph, see
+// Framework.GetTaskGraph. An edge {p, c} tells that task p is a parent of
+// task c. Traversals follow edges both ways, since data flows both ways.
+type TaskGraph struct {
+	Nodes []uint64
+	Edges [][2]uint64
+}
+
+// neighbors returns the tasks each task shares an edge with, in ascending
+// order so that traversals are deterministic.
+func (g *TaskGraph) neighbors() map[uint64][]uint64 {
+	adj := make(map[uint64][]uint64, len(g.Nodes))
+	for _, id := range g.Nodes {
+		adj[id] = nil
+	}
+	for _, e := range g.Edges {
+		adj[e[0]] = append(adj[e[0]], e[1])
+		adj[e[1]] = append(adj[e[1]], e[0])
+	}
+	for _, ns := range adj {
+		sort.Sort(uint64s(ns))
+	}
+	return adj
+}
+
+// BFS returns the tasks reachable from startID in breadth-first order,
+// startID first. It returns nil if startID isn't in the graph.
+func (g *TaskGraph) BFS(startID uint64) []uint64 {
+	adj := g.neighbors()
+	if _, ok := adj[startID]; !ok {
+		return nil
+	}
+	visited := map[uint64]bool{startID: true}
+	order := []uint64{startID}
+	for i := 0; i < len(order); i++ {
+		for _, n := range adj[order[i]] {
+			if !visited[n] {
+				visited[n] = true
+				order = append(order, n)
+			}
+		}
+	}
+	return order
+}
+
+// DFS returns the tasks reachable from startID in depth-first preorder,
+// startID first. It returns nil if startID isn't in the graph.
+func (g *TaskGraph) DFS(startID uint64) []uint64 {
+	adj := g.neighbors()
+	if _, ok := adj[startID]; !ok {
+		return nil
+	}
+	visited := make(map[uint64]bool)
+	var order []uint64
+	var visit func(id uint64)
+	visit = func(id uint64) {
+		visited[id] = true
+		order = append(order, id)
+		for _, n := range adj[id] {
+			if !visited[n] {
+				visit(n)
+			}
+		}
+	}
+	visit(startID)
+	return order
+}
+
+// ShortestPath returns the tasks on a path with the fewest hops from one task
+// to another, both included. It returns ErrNoPath if there is none.
+func (g *TaskGraph) ShortestPath(from, to uint64) ([]uint64, error) {
+	adj := g.neighbors()
+	if _, ok := adj[from]; !ok {
+		return nil, ErrNoPath
+	}
+	prev := map[uint64]uint64{from: from}
+	queue := []uint64{from}
+	for i := 0; i < len(queue); i++ {
+		id := queue[i]
+		if id == to {
+			var path []uint64
+			for ; id != from; id = prev[id] {
+				path = append(path, id)
+			}
+			path = append(path, from)
+			for l, r := 0, len(path)-1; l < r; l, r = l+1, r-1 {
+				path[l], path[r] = path[r], path[l]
+			}
+			return path, nil
+		}
+		for _, n := range adj[id] {
+			if _, ok := prev[n]; !ok {
+				prev[n] = id
+				queue = append(queue, n)
+			}
+		}
+	}
+	return nil, ErrNoPath
+}
+
+type uint64s []uint64
+
+func (s uint64s) Len() int           { return len(s) }
+func (s uint64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
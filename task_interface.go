@@ -27,6 +27,19 @@ type Task interface {
 	ServeAsChild(fromID uint64, req string) []byte
 }
 
+// PushReceiver is an interface that task need to implement if their children
+// push data to them via Context.PushDataToParent.
+type PushReceiver interface {
+	ParentPushReceived(ctx Context, fromChildID uint64, data []byte)
+}
+
+// DataRequestFailureHandler is an interface that task can implement to learn
+// about data requests that won't be answered, e.g. because the response
+// exceeds the max message size.
+type DataRequestFailureHandler interface {
+	DataRequestFailed(ctx Context, toID uint64, req string, err error)
+}
+
 type UpdateLog interface {
 	UpdateID()
 }
@@ -42,3 +55,49 @@ type Backupable interface {
 	// one update the state of copy.
 	Update(log UpdateLog)
 }
+
+// EpochAbortHandler is an interface that task need to implement if they want
+// to know when an epoch is aborted, see Context.AbortEpoch. SetEpoch is
+// called again for the epoch right after.
+type EpochAbortHandler interface {
+	EpochAborted(ctx Context, epoch uint64, reason string)
+}
+
+// EpochRetryHandler is an interface that task need to implement if they want
+// to reset their state when an epoch is started over, after it was aborted or
+// failed validation. attempt counts the start overs of the epoch from 1. It's
+// called right before SetEpoch for the same epoch.
+type EpochRetryHandler interface {
+	EpochRetry(ctx Context, epoch, attempt uint64)
+}
+
+// Checkpointable is an interface that task need to implement if they want
+// their state to be saved and brought back, e.g. when a job is resumed.
+type Checkpointable interface {
+	// Checkpoint returns the state of the task at the given epoch.
+	Checkpoint(epoch uint64) ([]byte, error)
+	// Restore brings the task back to the state saved by Checkpoint.
+	Restore(epoch uint64, data []byte) error
+}
+
+// Aggregator is an interface that task need to implement if they aggregate
+// what their children send, so that the framework can hand the aggregate to
+// the epoch completion callback, see Bootstrap.SetEpochCompletionCallback.
+type Aggregator interface {
+	// Aggregate returns what the task aggregated in the given epoch.
+	Aggregate(epoch uint64) []byte
+}
+
+// Command is sent to a single task out of band, i.e. without waiting for an
+// epoch boundary, see Framework.SendCommandToTask. Type tells what to do,
+// e.g. "checkpoint", and Args are up to the application.
+type Command struct {
+	Type string
+	Args map[string]string
+}
+
+// CommandHandler is an interface that task need to implement if they want to
+// receive commands sent by Framework.SendCommandToTask.
+type CommandHandler interface {
+	HandleCommand(ctx Context, cmd Command) error
+}
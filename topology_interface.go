@@ -27,6 +27,35 @@ type Topology interface {
 	// given epoch.
 	GetChildren(epoch uint64) []uint64
 
+	// GetAncestors returns the IDs of all ancestors of the given task at the
+	// given epoch, from its parents up to the root, in order of increasing
+	// distance. Unlike GetParents, it works for any task, not only this one.
+	GetAncestors(taskID, epoch uint64) []uint64
+
+	// GetAdjacencyList returns the children of every task that has any at the
+	// given epoch, see topoutil.AdjacencyMatrix for the matrix form.
+	GetAdjacencyList(epoch uint64) map[uint64][]uint64
+
+	// GetLeafTasks returns the IDs of all tasks without children at the
+	// given epoch, in increasing order.
+	GetLeafTasks(epoch uint64) []uint64
+
+	// GetDepth returns the number of edges on the longest path from a task
+	// without parents to a task without children at the given epoch, e.g. 0
+	// for a single task.
+	GetDepth(epoch uint64) uint64
+
 	// Inform the new NumberOfTasks, this allow the number of tasks to change.
 	SetNumberOfTasks(numOfTasks uint64)
+
+	// Validate checks that the topology is consistent, e.g. parents and
+	// children of tasks agree. Framework refuses to start if it fails.
+	Validate() error
+}
+
+// SizedTopology is a Topology that tells the number of tasks it was set to,
+// see SetNumberOfTasks.
+type SizedTopology interface {
+	Topology
+	GetTaskCount() uint64
 }